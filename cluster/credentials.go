@@ -0,0 +1,130 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+const etcdStorageCredentials = "credentials"
+
+// Credentials are used by the cluster to authenticate with InfluxDB on the data nodes.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// DataCredentials holds the credentials of the data nodes. Credentials of a node are found by its
+// data location, and the default credentials are used for nodes that do not have any of their own.
+type DataCredentials struct {
+	Default *Credentials           `json:"default,omitempty"`
+	Nodes   map[string]Credentials `json:"nodes,omitempty"`
+}
+
+// Get returns the credentials for the data node at the location.
+func (c *DataCredentials) Get(location string) (Credentials, bool) {
+	if credentials, ok := c.Nodes[location]; ok {
+		return credentials, true
+	}
+	if c.Default != nil {
+		return *c.Default, true
+	}
+	return Credentials{}, false
+}
+
+// LoadDataCredentials reads credentials from a JSON file with the default credentials in "default" and
+// those of specific nodes in "nodes" by their data location. Both have a "username" and a "password".
+func LoadDataCredentials(path string) (*DataCredentials, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	credentials := &DataCredentials{}
+	err = json.Unmarshal(data, credentials)
+	return credentials, err
+}
+
+var dataCredentials = struct {
+	sync.RWMutex
+	credentials *DataCredentials
+}{credentials: &DataCredentials{}}
+
+// SetDataCredentials sets the credentials used for all requests to data nodes.
+func SetDataCredentials(credentials *DataCredentials) {
+	dataCredentials.Lock()
+	defer dataCredentials.Unlock()
+	dataCredentials.credentials = credentials
+}
+
+// GetDataCredentials returns the credentials to use for the data node at the location, if there are any.
+func GetDataCredentials(location string) (Credentials, bool) {
+	dataCredentials.RLock()
+	defer dataCredentials.RUnlock()
+	return dataCredentials.credentials.Get(location)
+}
+
+// AuthorizeDataRequest adds the credentials of the data node at the location to the request.
+// It returns false if there are no credentials and the request is left as is.
+func AuthorizeDataRequest(req *http.Request, location string) bool {
+	credentials, ok := GetDataCredentials(location)
+	if ok {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+	return ok
+}
+
+type EtcdCredentialsStorage struct {
+	EtcdStorageBase
+}
+
+func NewEtcdCredentialsStorage(c *clientv3.Client) *EtcdCredentialsStorage {
+	s := &EtcdCredentialsStorage{}
+	s.Client = c
+	return s
+}
+
+func (s *EtcdCredentialsStorage) Get() (*DataCredentials, error) {
+	resp, err := s.Client.Get(context.Background(), s.path(etcdStorageCredentials))
+	if err != nil {
+		return nil, err
+	}
+	credentials := &DataCredentials{}
+	if resp.Count == 0 {
+		return credentials, nil
+	}
+	err = json.Unmarshal(resp.Kvs[0].Value, credentials)
+	return credentials, err
+}
+
+func (s *EtcdCredentialsStorage) Save(credentials *DataCredentials) error {
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.Put(context.Background(), s.path(etcdStorageCredentials), string(data))
+	return err
+}
+
+// Watch sends the credentials every time they are changed.
+func (s *EtcdCredentialsStorage) Watch() chan *DataCredentials {
+	updates := make(chan *DataCredentials)
+	go (func() {
+		for update := range s.Client.Watch(context.Background(), s.path(etcdStorageCredentials)) {
+			for _, event := range update.Events {
+				credentials := &DataCredentials{}
+				if event.Type == mvccpb.DELETE {
+					updates <- credentials
+				} else if err := json.Unmarshal(event.Kv.Value, credentials); err == nil {
+					updates <- credentials
+				}
+			}
+		}
+		close(updates)
+	})()
+	return updates
+}
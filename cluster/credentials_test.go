@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDataCredentials(t *testing.T) {
+	f, err := ioutil.TempFile("", "credentials")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+		"default": {"username": "cluster", "password": "secret"},
+		"nodes": {"influx-2:8086": {"username": "other", "password": "password"}}
+	}`)
+	f.Close()
+
+	credentials, err := LoadDataCredentials(f.Name())
+	assert.NoError(t, err)
+	SetDataCredentials(credentials)
+	defer SetDataCredentials(&DataCredentials{})
+
+	c, ok := GetDataCredentials("influx-1:8086")
+	assert.True(t, ok)
+	assert.Equal(t, Credentials{"cluster", "secret"}, c)
+	c, ok = GetDataCredentials("influx-2:8086")
+	assert.True(t, ok)
+	assert.Equal(t, Credentials{"other", "password"}, c)
+
+	req, _ := http.NewRequest("POST", "http://influx-2:8086/write", nil)
+	assert.True(t, AuthorizeDataRequest(req, "influx-2:8086"))
+	username, password, _ := req.BasicAuth()
+	assert.Equal(t, "other", username)
+	assert.Equal(t, "password", password)
+}
+
+func TestDataCredentials_NoDefault(t *testing.T) {
+	credentials := &DataCredentials{Nodes: map[string]Credentials{"influx-2:8086": {"other", "password"}}}
+	_, ok := credentials.Get("influx-1:8086")
+	assert.False(t, ok)
+
+	req, _ := http.NewRequest("POST", "http://influx-1:8086/write", nil)
+	assert.False(t, AuthorizeDataRequest(req, "influx-1:8086"))
+	assert.Empty(t, req.Header.Get("Authorization"))
+}
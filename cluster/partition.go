@@ -38,6 +38,15 @@ func (c *PartitionCollection) Clear() {
 	c.tree.Clear()
 }
 
+// Tokens returns the tokens of all partitions in ascending order.
+func (c *PartitionCollection) Tokens() []int {
+	tokens := make([]int, 0, c.tree.Size())
+	for _, key := range c.tree.Keys() {
+		tokens = append(tokens, key.(int))
+	}
+	return tokens
+}
+
 func (c *PartitionCollection) Get(key int) *Partition {
 	node, ok := c.findNode(key)
 	if !ok {
@@ -26,7 +26,7 @@ func NewEtcdPartitionKeyStorage(c *clientv3.Client) *EtcdPartitionKeyStorage {
 }
 
 func (s *EtcdPartitionKeyStorage) Watch() clientv3.WatchChan {
-	return s.Client.Watch(context.Background(), s.path(etcdStoragePartitionKeys), clientv3.WithPrefix())
+	return s.Client.Watch(context.Background(), s.path(etcdStoragePartitionKeys), clientv3.WithPrefix(), clientv3.WithPrevKV())
 }
 
 func (s *EtcdPartitionKeyStorage) Save(partitionKey *PartitionKey) error {
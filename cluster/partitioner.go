@@ -153,21 +153,25 @@ func (c *SyncedPartitioner) trackUpdates() {
 		select {
 		case update := <-c.storage.Watch():
 			for _, event := range update.Events {
-				event.IsModify()
-				if len(event.Kv.Value) == 0 {
-					log.Println("Warning: Parsing partition key failed. Invalid format: " + string(event.Kv.Value))
+				value := event.Kv.Value
+				if event.Type == mvccpb.DELETE && event.PrevKv != nil {
+					// Deleted keys have no value, so the key is read from the previous version.
+					value = event.PrevKv.Value
+				}
+				if len(value) == 0 {
+					log.Println("Warning: Parsing partition key failed. Invalid format: " + string(value))
 					continue
 				}
 				var partitionKey PartitionKey
-				err := json.Unmarshal(event.Kv.Value, &partitionKey)
+				err := json.Unmarshal(value, &partitionKey)
 				if err != nil {
-					panic("Failed to parse partition key from update: " + string(event.Kv.Value))
+					panic("Failed to parse partition key from update: " + string(value))
 				}
 				if event.Type == mvccpb.PUT {
 					c.AddKey(partitionKey)
 				} else if event.Type == mvccpb.DELETE {
 					// Removing a partition key requires that data is re-distributed which must happen
-					// before the key is removed.
+					// before the key is removed. This is done while the key is draining.
 					c.RemoveKey(partitionKey)
 				}
 			}
@@ -218,6 +222,42 @@ type PartitionKey struct {
 	// specified in the the key. Reads can only make use of it if all tags in the
 	// key are also in the query.
 	Tags []string
+
+	// Status is empty for keys in use, and otherwise tells how the key is being changed.
+	Status string `json:",omitempty"`
+
+	// MigrationTags are the other tags that points are written by while the key is resharding.
+	// They are the new tags until the key is switched, and then the old ones.
+	MigrationTags []string `json:",omitempty"`
+
+	// Progress describes how far the key has been changed.
+	Progress string `json:",omitempty"`
+}
+
+const (
+	// PartitionKeyStatusDraining is the status of a partition key whose data is being moved to the
+	// nodes of its database before the key is removed. Points are written both by the key and by the
+	// database while reads still use the key.
+	PartitionKeyStatusDraining = "draining"
+
+	// PartitionKeyStatusResharding is the status of a partition key whose tags are being changed.
+	// Points are written by both the tags and the migration tags while its data is moved.
+	PartitionKeyStatusResharding = "resharding"
+)
+
+// Draining returns true if the key is being dropped.
+func (pk *PartitionKey) Draining() bool {
+	return pk.Status == PartitionKeyStatusDraining
+}
+
+// Resharding returns true if the tags of the key are being changed.
+func (pk *PartitionKey) Resharding() bool {
+	return pk.Status == PartitionKeyStatusResharding
+}
+
+// MigrationKey returns the key with the migration tags of a key that is resharding.
+func (pk *PartitionKey) MigrationKey() PartitionKey {
+	return PartitionKey{Database: pk.Database, Measurement: pk.Measurement, Tags: pk.MigrationTags}
 }
 
 func (pk *PartitionKey) Identifier() string {
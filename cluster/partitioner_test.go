@@ -11,7 +11,7 @@ func Test_createCompoundKeys(t *testing.T) {
 		"type": {"gold", "silver"},
 		"captain": {"goblin", "pirate"},
 	}
-	key := PartitionKey{"sharded", "treasures", []string{"type", "captain"}}
+	key := PartitionKey{Database: "sharded", Measurement: "treasures", Tags: []string{"type", "captain"}}
 	result := createCompoundKeys(key, values)
 	assert.NotEmpty(t, result)
 	sort.Strings(result)
@@ -185,7 +185,7 @@ func createFilename(nodeName, db, rp string) string {
 }
 
 func RecoverNodes(hs *EtcdHintStorage, data RecoveryStorage, nodes NodeCollection) {
-	client := &http.Client{Timeout: time.Second * 2}
+	client := NewDataClient(time.Second * 2)
 	for {
 		// TODO This can probably be replaced with an event handler of some sort that listens for nodes to start up. However, that would rely on Etcd.
 		// This would remove the need of the sleep. The faster the node is recovered, the faster the health of the cluster can be recovered.
@@ -229,12 +229,12 @@ func recoverNode(node Node, data RecoveryStorage) error {
 }
 
 func IsAlive(location string, client *http.Client) bool {
-	resp, err := client.Get("http://" + location + "/ping")
+	resp, err := client.Get(DataURL(location) + "/ping")
 	return err == nil && resp.StatusCode >= 200 && resp.StatusCode <= 299
 }
 
 func postData(location, db, rp string, buf []byte) (*http.Response, error) {
-	req, err := http.NewRequest("POST", "http://"+location+"/write", bytes.NewReader(buf))
+	req, err := http.NewRequest("POST", DataURL(location)+"/write", bytes.NewReader(buf))
 	if err != nil {
 		return nil, err
 	}
@@ -246,10 +246,8 @@ func postData(location, db, rp string, buf []byte) (*http.Response, error) {
 	req.URL.RawQuery = strings.Join(query, "&")
 	req.Header.Set("Content-Type", "text/plain")
 	req.Header.Set("Content-Length", strconv.Itoa(len(buf)))
-	//if auth != "" {
-	//	req.Header.Set("Authorization", auth)
-	//}
-	client := http.Client{Timeout: 60 * time.Second}
+	AuthorizeDataRequest(req, location)
+	client := NewDataClient(60 * time.Second)
 
 	resp, err := client.Do(req)
 	if err != nil {
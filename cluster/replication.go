@@ -0,0 +1,54 @@
+package cluster
+
+import "strings"
+
+// DefaultReplicationFactor is used until a default has been set for the cluster.
+const DefaultReplicationFactor = 2
+
+// ReplicationScope is the database or measurement that a replication factor is set on.
+// The scope of the default replication factor has neither.
+type ReplicationScope struct {
+	Database    string
+	Measurement string
+}
+
+// ParseReplicationScope parses "db" or "db.msmt". Measurements may contain dots but databases may not.
+func ParseReplicationScope(s string) ReplicationScope {
+	parts := strings.SplitN(s, ".", 2)
+	if len(parts) == 1 {
+		return ReplicationScope{Database: parts[0]}
+	}
+	return ReplicationScope{parts[0], parts[1]}
+}
+
+func (s ReplicationScope) String() string {
+	return CreatePartitionKeyIdentifier(s.Database, s.Measurement)
+}
+
+// Includes returns true if the measurement is in the scope.
+func (s ReplicationScope) Includes(db, measurement string) bool {
+	return (s.Database == "" || s.Database == db) && (s.Measurement == "" || s.Measurement == measurement)
+}
+
+func (s ReplicationScope) depth() int {
+	if s.Database == "" {
+		return 0
+	} else if s.Measurement == "" {
+		return 1
+	}
+	return 2
+}
+
+type ReplicationFactorUpdate struct {
+	Scope ReplicationScope
+	// Factor is 0 if the factor of the scope was removed.
+	Factor int
+}
+
+type ReplicationFactorStorage interface {
+	GetDefaultReplicationFactor(fallback int) (int, error)
+	SetDefaultReplicationFactor(factor int) error
+	GetReplicationFactors() (map[ReplicationScope]int, error)
+	// SetReplicationFactor sets the factor of a database or measurement, or removes it if the factor is 0.
+	SetReplicationFactor(scope ReplicationScope, factor int) error
+}
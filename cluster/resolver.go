@@ -3,8 +3,10 @@ package cluster
 import (
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/mvcc/mvccpb"
@@ -25,7 +27,13 @@ type Resolver struct {
 	// TODO add collection for reserved tokens which are used when writing
 	collection *PartitionCollection
 	nodes             NodeCollection
+	// ReplicationFactor is the default factor. It is guarded by factorsMu, so once the resolver is
+	// in use it has to be changed with SetReplicationFactor.
 	ReplicationFactor int
+
+	factorsMu sync.RWMutex
+	// factors are the replication factors of databases and measurements that do not use the default.
+	factors map[ReplicationScope]int
 }
 
 func NewResolver() *Resolver {
@@ -33,7 +41,12 @@ func NewResolver() *Resolver {
 }
 
 func NewResolverWithNodes(nodes NodeCollection) *Resolver {
-	return &Resolver{NewPartitionCollection(), nodes, 2}
+	return &Resolver{
+		collection:        NewPartitionCollection(),
+		nodes:             nodes,
+		ReplicationFactor: DefaultReplicationFactor,
+		factors:           map[ReplicationScope]int{},
+	}
 }
 
 func (r *Resolver) PrintRing() {
@@ -53,8 +66,129 @@ func (r *Resolver) FindTokenByKey(key int) (int, bool) {
 	return partition.Token, ok
 }
 
-func (r *Resolver) FindNodesByKey(key int, purpose ResolvePurpose) []*Node {
-	partitions := r.collection.GetMultiple(key, r.ReplicationFactor)
+// FindAllTokens returns the first token of every range on the ring. Resolving each of them
+// gives the replicas of every range, which is what is needed to reach all data in the cluster.
+func (r *Resolver) FindAllTokens() []int {
+	return r.collection.Tokens()
+}
+
+// SetReplicationFactor sets the factor of a database or measurement, or removes it if the factor is 0.
+// The default factor is set if the scope is empty.
+func (r *Resolver) SetReplicationFactor(scope ReplicationScope, factor int) {
+	r.factorsMu.Lock()
+	defer r.factorsMu.Unlock()
+	if scope.Database == "" {
+		r.ReplicationFactor = factor
+		return
+	}
+	if factor <= 0 {
+		delete(r.factors, scope)
+	} else {
+		r.factors[scope] = factor
+	}
+}
+
+// ReplicationScopeOf returns the scope that decides the replication factor of the measurement.
+// A factor set on the measurement is used before one set on its database, and the default is used
+// if neither has one.
+func (r *Resolver) ReplicationScopeOf(db, measurement string) ReplicationScope {
+	r.factorsMu.RLock()
+	defer r.factorsMu.RUnlock()
+	return r.replicationScopeOf(db, measurement)
+}
+
+// replicationScopeOf is ReplicationScopeOf for callers that hold factorsMu.
+func (r *Resolver) replicationScopeOf(db, measurement string) ReplicationScope {
+	if _, ok := r.factors[ReplicationScope{db, measurement}]; ok && measurement != "" {
+		return ReplicationScope{db, measurement}
+	}
+	if _, ok := r.factors[ReplicationScope{Database: db}]; ok {
+		return ReplicationScope{Database: db}
+	}
+	return ReplicationScope{}
+}
+
+// InReplicationScope returns true if the replication factor of the measurement is decided by the scope,
+// or by a scope that includes it in case the factor of the scope has been removed.
+func (r *Resolver) InReplicationScope(scope ReplicationScope, db, measurement string) bool {
+	return scope.Includes(db, measurement) && r.ReplicationScopeOf(db, measurement).depth() <= scope.depth()
+}
+
+func (r *Resolver) ReplicationFactorOf(db, measurement string) int {
+	r.factorsMu.RLock()
+	defer r.factorsMu.RUnlock()
+	return r.replicationFactorOf(db, measurement)
+}
+
+// replicationFactorOf is ReplicationFactorOf for callers that hold factorsMu.
+func (r *Resolver) replicationFactorOf(db, measurement string) int {
+	scope := r.replicationScopeOf(db, measurement)
+	if scope.Database == "" {
+		return r.ReplicationFactor
+	}
+	return r.factors[scope]
+}
+
+// minReplicationFactor returns the lowest factor of the measurements in the database, or in all databases
+// if none is given.
+func (r *Resolver) minReplicationFactor(db string) int {
+	r.factorsMu.RLock()
+	defer r.factorsMu.RUnlock()
+	factor := r.replicationFactorOf(db, "")
+	for scope, f := range r.factors {
+		if (db == "" || scope.Database == db) && f < factor {
+			factor = f
+		}
+	}
+	return factor
+}
+
+// ReplicationScopes returns the scope of the default factor followed by those of databases and measurements
+// that have a factor of their own.
+func (r *Resolver) ReplicationScopes() []ReplicationScope {
+	r.factorsMu.RLock()
+	defer r.factorsMu.RUnlock()
+	scopes := []ReplicationScope{{}}
+	for scope := range r.factors {
+		scopes = append(scopes, scope)
+	}
+	sort.Slice(scopes[1:], func(i, j int) bool { return scopes[i+1].String() < scopes[j+1].String() })
+	return scopes
+}
+
+// ReplicationFactors returns the factors of databases and measurements that do not use the default.
+func (r *Resolver) ReplicationFactors() map[ReplicationScope]int {
+	r.factorsMu.RLock()
+	defer r.factorsMu.RUnlock()
+	factors := make(map[ReplicationScope]int, len(r.factors))
+	for scope, factor := range r.factors {
+		factors[scope] = factor
+	}
+	return factors
+}
+
+// ReplicaTokens returns the tokens of the ranges that the node has a replica of with the given factor.
+func (r *Resolver) ReplicaTokens(nodeName string, factor int) []int {
+	tokens := []int{}
+	for _, token := range r.collection.Tokens() {
+		for _, p := range r.collection.GetMultiple(token, factor) {
+			if p.Node != nil && p.Node.Name == nodeName {
+				tokens = append(tokens, token)
+				break
+			}
+		}
+	}
+	return tokens
+}
+
+// FindNodesByKey returns the nodes that have replicas of the key in the measurement, which depend
+// on its replication factor.
+func (r *Resolver) FindNodesByKey(key int, db, measurement string, purpose ResolvePurpose) []*Node {
+	return r.findNodes(key, r.ReplicationFactorOf(db, measurement), purpose)
+}
+
+func (r *Resolver) findNodes(key int, factor int, purpose ResolvePurpose) []*Node {
+	partitions := r.collection.GetMultiple(key, factor)
 	nodesMap := make(map[*Node]bool)
 	for _, p := range partitions {
 		// Getting node from the nodes collection instead as the one in the
@@ -76,9 +210,15 @@ func (r *Resolver) FindNodesByKey(key int, purpose ResolvePurpose) []*Node {
 // FindByKey can return multiple locations for replication and load balancing.
 // On reads, it will not return nodes with status "recovering"
 // However, on writes it will return recoverings nodes so that they can catch up.
-func (r *Resolver) FindByKey(key int, purpose ResolvePurpose) []string {
+// If no measurement is given, only the locations that have replicas of every measurement
+// in the database are returned, or of every database if neither is given.
+func (r *Resolver) FindByKey(key int, db, measurement string, purpose ResolvePurpose) []string {
+	factor := r.ReplicationFactorOf(db, measurement)
+	if measurement == "" {
+		factor = r.minReplicationFactor(db)
+	}
 	locations := []string{}
-	for _, node := range r.FindNodesByKey(key, purpose) {
+	for _, node := range r.findNodes(key, factor, purpose) {
 		locations = append(locations, node.DataLocation)
 	}
 	return locations
@@ -98,8 +238,11 @@ func (r *Resolver) FindPrimary(key int) *Node {
 	return nil
 }
 
-func (r *Resolver) ReverseSecondaryLookup(key int) []int {
-	if r.ReplicationFactor == 1 {
+// ReverseSecondaryLookup returns the tokens of the ranges whose replicas of the measurement include the
+// partition of the key, other than its own range.
+func (r *Resolver) ReverseSecondaryLookup(key int, db, measurement string) []int {
+	factor := r.ReplicationFactorOf(db, measurement)
+	if factor == 1 {
 		return []int{key}
 	}
 	// If the caller has a sorted list of keys, it is trivial to avoid an n^2 complexity by optimizing this.
@@ -109,7 +252,7 @@ func (r *Resolver) ReverseSecondaryLookup(key int) []int {
 		if p.(*Partition).Token == key {
 			continue
 		}
-		targets := r.collection.GetMultiple(p.(*Partition).Token, r.ReplicationFactor)
+		targets := r.collection.GetMultiple(p.(*Partition).Token, factor)
 		for _, other := range targets {
 			if other.Token == key {
 				tokens = append(tokens, p.(*Partition).Token)
@@ -122,6 +265,7 @@ func (r *Resolver) ReverseSecondaryLookup(key int) []int {
 func (r *Resolver) FindAllNodes() []*Node {
 	nodes := []*Node{}
 	for _, node := range r.nodes.GetAll() {
+		node := node
 		nodes = append(nodes, &node)
 	}
 	return nodes
@@ -19,18 +19,18 @@ func TestResolver_FindAll(t *testing.T) {
 func TestResolver_FindByKey(t *testing.T) {
 	resolver := NewResolver()
 	resolver.ReplicationFactor = 2
-	assert.Len(t, resolver.FindByKey(2, READ), 0)
+	assert.Len(t, resolver.FindByKey(2, "", "", READ), 0)
 
 	resolver.AddToken(1, &Node{[]int{1}, NodeStatusUp, ":8086", "local"})
 	resolver.AddToken(3, &Node{[]int{3}, NodeStatusJoining, ":9096", "local2"})
 
-	locations := resolver.FindByKey(1, READ)
+	locations := resolver.FindByKey(1, "", "", READ)
 	assert.Len(t, locations, 1)
 	assert.Contains(t, locations, ":8086")
 
 	resolver.RemoveToken(1)
 
-	locations2 := resolver.FindByKey(2, WRITE)
+	locations2 := resolver.FindByKey(2, "", "", WRITE)
 	assert.Len(t, locations2, 1)
 	assert.Contains(t, locations2, ":9096")
 }
@@ -47,15 +47,105 @@ func TestResolver_ReverseSecondaryLookup(t *testing.T) {
 	resolver.AddToken(5, node1)
 	resolver.AddToken(6, node2)
 
-	assert.Equal(t, []int{6}, resolver.ReverseSecondaryLookup(1))
-	assert.Equal(t, []int{1}, resolver.ReverseSecondaryLookup(2))
-	assert.Equal(t, []int{2}, resolver.ReverseSecondaryLookup(3))
+	assert.Equal(t, []int{6}, resolver.ReverseSecondaryLookup(1, "", ""))
+	assert.Equal(t, []int{1}, resolver.ReverseSecondaryLookup(2, "", ""))
+	assert.Equal(t, []int{2}, resolver.ReverseSecondaryLookup(3, "", ""))
 
 	// Due to the non-uniform layout of the tokens, these are never the target for replication
 	// with a replication factor of 2 as all data in node 2 is replicated to token 3 and 6
-	assert.Empty(t, resolver.ReverseSecondaryLookup(4))
-	assert.Empty(t, resolver.ReverseSecondaryLookup(5))
+	assert.Empty(t, resolver.ReverseSecondaryLookup(4, "", ""))
+	assert.Empty(t, resolver.ReverseSecondaryLookup(5, "", ""))
 
 	// This illustrates that an uneven token distribution leads to some tokens to carry more data than others
-	assert.Equal(t, []int{3, 4, 5}, resolver.ReverseSecondaryLookup(6))
+	assert.Equal(t, []int{3, 4, 5}, resolver.ReverseSecondaryLookup(6, "", ""))
+}
+
+func TestResolver_ScopedLookups(t *testing.T) {
+	resolver := NewResolver()
+	resolver.ReplicationFactor = 2
+	resolver.SetReplicationFactor(ReplicationScope{"db", "raw"}, 1)
+	resolver.SetReplicationFactor(ReplicationScope{"db", "hot"}, 3)
+	for i, name := range []string{"a", "b", "c"} {
+		resolver.AddToken(i*10, &Node{Name: name, Status: NodeStatusUp, DataLocation: name})
+	}
+
+	assert.Equal(t, []string{"a"}, resolver.FindByKey(5, "db", "raw", READ))
+	assert.Len(t, resolver.FindByKey(5, "db", "hot", READ), 3)
+	assert.Len(t, resolver.FindByKey(5, "db", "cpu", READ), 2)
+	// Without a measurement, only the nodes with replicas of all measurements are used.
+	assert.Equal(t, []string{"a"}, resolver.FindByKey(5, "db", "", READ))
+	assert.Len(t, resolver.FindByKey(5, "other", "", READ), 2)
+
+	assert.Equal(t, []int{0}, resolver.ReverseSecondaryLookup(0, "db", "raw"))
+	assert.Equal(t, []int{20}, resolver.ReverseSecondaryLookup(0, "db", "cpu"))
+	assert.Equal(t, []int{10, 20}, resolver.ReverseSecondaryLookup(0, "db", "hot"))
+
+	assert.Equal(t, []ReplicationScope{{}, {"db", "hot"}, {"db", "raw"}}, resolver.ReplicationScopes())
+}
+
+func TestResolver_FindAllTokens(t *testing.T) {
+	resolver := NewResolver()
+	assert.Empty(t, resolver.FindAllTokens())
+
+	resolver.AddToken(30, &Node{Name: "a", DataLocation: "a"})
+	resolver.AddToken(10, &Node{Name: "b", DataLocation: "b"})
+	resolver.AddToken(20, &Node{Name: "a", DataLocation: "a"})
+
+	assert.Equal(t, []int{10, 20, 30}, resolver.FindAllTokens())
+}
+
+func TestResolver_ReplicationFactorOf(t *testing.T) {
+	resolver := NewResolver()
+	resolver.ReplicationFactor = 2
+	resolver.SetReplicationFactor(ReplicationScope{Database: "db"}, 1)
+	resolver.SetReplicationFactor(ReplicationScope{"db", "hot"}, 3)
+
+	assert.Equal(t, 2, resolver.ReplicationFactorOf("other", "cpu"))
+	assert.Equal(t, 1, resolver.ReplicationFactorOf("db", "cpu"))
+	assert.Equal(t, 3, resolver.ReplicationFactorOf("db", "hot"))
+	assert.True(t, resolver.InReplicationScope(ReplicationScope{Database: "db"}, "db", "cpu"))
+	assert.False(t, resolver.InReplicationScope(ReplicationScope{Database: "db"}, "db", "hot"))
+	assert.False(t, resolver.InReplicationScope(ReplicationScope{}, "db", "cpu"))
+
+	for i, name := range []string{"a", "b", "c"} {
+		resolver.AddToken(i*10, &Node{Name: name, Status: NodeStatusUp, DataLocation: name})
+	}
+	assert.Len(t, resolver.FindNodesByKey(5, "db", "cpu", WRITE), 1)
+	assert.Len(t, resolver.FindNodesByKey(5, "db", "hot", WRITE), 3)
+	assert.Len(t, resolver.FindNodesByKey(5, "other", "cpu", WRITE), 2)
+
+	// Removing the factor of the measurement makes it use the one of the database.
+	resolver.SetReplicationFactor(ReplicationScope{"db", "hot"}, 0)
+	assert.Equal(t, 1, resolver.ReplicationFactorOf("db", "hot"))
+	assert.True(t, resolver.InReplicationScope(ReplicationScope{"db", "hot"}, "db", "hot"))
+}
+
+func TestResolver_ReplicaTokens(t *testing.T) {
+	resolver := NewResolver()
+	for i, name := range []string{"a", "b", "c"} {
+		resolver.AddToken(i*10, &Node{Name: name, Status: NodeStatusUp, DataLocation: name})
+	}
+	assert.Equal(t, []int{20}, resolver.ReplicaTokens("c", 1))
+	assert.Equal(t, []int{10, 20}, resolver.ReplicaTokens("c", 2))
+	assert.Equal(t, []int{0, 10, 20}, resolver.ReplicaTokens("c", 3))
+}
+
+func TestResolver_SetDefaultReplicationFactorConcurrently(t *testing.T) {
+	resolver := NewResolver()
+	for i, name := range []string{"a", "b", "c"} {
+		resolver.AddToken(i*10, &Node{Name: name, Status: NodeStatusUp, DataLocation: name})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			resolver.SetReplicationFactor(ReplicationScope{}, i%3+1)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		resolver.FindByKey(5, "db", "cpu", READ)
+		resolver.FindByKey(5, "db", "", READ)
+	}
+	<-done
+	assert.Equal(t, 1, resolver.ReplicationFactorOf("db", "cpu"))
 }
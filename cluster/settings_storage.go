@@ -5,8 +5,8 @@ import (
 	"encoding/json"
 	"context"
 	"strconv"
-	"github.com/coreos/etcd/mvcc/mvccpb"
 	"strings"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 )
 
 const etcdStorageSettings = "settings"
@@ -23,7 +23,7 @@ func NewEtcdSettingsStorage(c *clientv3.Client) *EtcdSettingsStorage {
 }
 
 func (s *EtcdSettingsStorage) Watch() clientv3.WatchChan {
-	return s.Client.Watch(context.Background(), s.path(etcdStorageSettings))
+	return s.Client.Watch(context.Background(), s.path(etcdStorageSettings), clientv3.WithPrefix())
 }
 
 func (s *EtcdSettingsStorage) Get(partitionKey *PartitionKey) error {
@@ -43,16 +43,16 @@ func (s *EtcdSettingsStorage) set(key, value string) error {
 
 func (s* EtcdSettingsStorage) watchKey(key string) chan string {
 	updates := make(chan string)
-	for update := range s.Watch() {
-		for _, event := range update.Events {
-			if event.Type == mvccpb.PUT {
-				key := string(event.Kv.Key)
-				if strings.HasPrefix(key, s.path(etcdStorageSettings) + key) {
+	go (func() {
+		for update := range s.Watch() {
+			for _, event := range update.Events {
+				if event.Type == mvccpb.PUT && string(event.Kv.Key) == s.path(etcdStorageSettings) + key {
 					updates <- string(event.Kv.Value)
 				}
 			}
 		}
-	}
+		close(updates)
+	})()
 	return updates
 }
 
@@ -70,10 +70,13 @@ func (s *EtcdSettingsStorage) GetDefaultReplicationFactor(fallback int) (int, er
 
 func (s* EtcdSettingsStorage) WatchDefaultReplicationFactor() chan int {
 	updates := make(chan int)
-	for update := range s.watchKey("rf_default") {
-		value, _ := strconv.Atoi(update)
-		updates <- value
-	}
+	go (func() {
+		for update := range s.watchKey("rf_default") {
+			value, _ := strconv.Atoi(update)
+			updates <- value
+		}
+		close(updates)
+	})()
 	return updates
 }
 
@@ -81,9 +84,86 @@ func (s *EtcdSettingsStorage) SetDefaultReplicationFactor(factor int) error {
 	return s.set("rf_default", strconv.Itoa(factor))
 }
 
+// GetDefaultWriteConsistency returns the consistency level of writes that do not specify one.
+func (s *EtcdSettingsStorage) GetDefaultWriteConsistency(fallback string) (string, error) {
+	resp, err := s.Client.Get(context.Background(), s.path(etcdStorageSettings) + "write_consistency_default")
+	if err != nil {
+		return fallback, err
+	}
+	if resp.Count == 0 {
+		return fallback, nil
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+func (s* EtcdSettingsStorage) WatchDefaultWriteConsistency() chan string {
+	return s.watchKey("write_consistency_default")
+}
+
+func (s *EtcdSettingsStorage) SetDefaultWriteConsistency(level string) error {
+	return s.set("write_consistency_default", level)
+}
+
+func (s *EtcdSettingsStorage) replicationFactorKey(scope ReplicationScope) string {
+	return s.path(etcdStorageSettings) + "rf/" + scope.String()
+}
 
-func (s *EtcdSettingsStorage) SetReplicationFactor(db, measurement string, factor int) error {
-	return s.set("rf/" + db + "." + measurement, strconv.Itoa(factor))
+func (s *EtcdSettingsStorage) SetReplicationFactor(scope ReplicationScope, factor int) error {
+	if scope.Database == "" {
+		return s.SetDefaultReplicationFactor(factor)
+	}
+	if factor <= 0 {
+		_, err := s.Client.Delete(context.Background(), s.replicationFactorKey(scope))
+		return err
+	}
+	return s.set("rf/" + scope.String(), strconv.Itoa(factor))
+}
+
+// GetReplicationFactors returns the factors that are set on databases and measurements.
+func (s *EtcdSettingsStorage) GetReplicationFactors() (map[ReplicationScope]int, error) {
+	prefix := s.path(etcdStorageSettings) + "rf/"
+	resp, err := s.Client.Get(context.Background(), prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	factors := map[ReplicationScope]int{}
+	for _, kv := range resp.Kvs {
+		factor, err := strconv.Atoi(string(kv.Value))
+		if err != nil {
+			return nil, err
+		}
+		factors[ParseReplicationScope(strings.TrimPrefix(string(kv.Key), prefix))] = factor
+	}
+	return factors, nil
+}
+
+// WatchReplicationFactors sends updates of the factors of databases and measurements.
+func (s* EtcdSettingsStorage) WatchReplicationFactors() chan ReplicationFactorUpdate {
+	prefix := s.path(etcdStorageSettings) + "rf/"
+	updates := make(chan ReplicationFactorUpdate)
+	go (func() {
+		for update := range s.Watch() {
+			for _, event := range update.Events {
+				key := string(event.Kv.Key)
+				if !strings.HasPrefix(key, prefix) {
+					continue
+				}
+				scope := ParseReplicationScope(strings.TrimPrefix(key, prefix))
+				switch event.Type {
+				case mvccpb.PUT:
+					factor, err := strconv.Atoi(string(event.Kv.Value))
+					if err != nil {
+						continue
+					}
+					updates <- ReplicationFactorUpdate{scope, factor}
+				case mvccpb.DELETE:
+					updates <- ReplicationFactorUpdate{scope, 0}
+				}
+			}
+		}
+		close(updates)
+	})()
+	return updates
 }
 
 func (s *EtcdSettingsStorage) GetAll() ([]*PartitionKey, error) {
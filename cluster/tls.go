@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var dataTLS = struct {
+	sync.RWMutex
+	config    *tls.Config
+	transport *http.Transport
+}{transport: newDataTransport(nil)}
+
+// SetDataTLS makes requests to data nodes use HTTPS with the configuration. If it is nil, HTTP is used.
+func SetDataTLS(config *tls.Config) {
+	dataTLS.Lock()
+	defer dataTLS.Unlock()
+	dataTLS.config = config
+	dataTLS.transport = newDataTransport(config)
+}
+
+// DataTLSConfig returns the TLS configuration of requests to data nodes, or nil if they use HTTP.
+func DataTLSConfig() *tls.Config {
+	dataTLS.RLock()
+	defer dataTLS.RUnlock()
+	return dataTLS.config
+}
+
+// DataURL returns the base URL of the data node at the location. A location may include the scheme
+// to use for that node, otherwise HTTPS is used if TLS is configured for data nodes.
+func DataURL(location string) string {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return location
+	}
+	if DataTLSConfig() != nil {
+		return "https://" + location
+	}
+	return "http://" + location
+}
+
+// NewDataClient creates a client for requests to data nodes. Clients share connections.
+func NewDataClient(timeout time.Duration) *http.Client {
+	dataTLS.RLock()
+	defer dataTLS.RUnlock()
+	return &http.Client{Timeout: timeout, Transport: dataTLS.transport}
+}
+
+// NewDataTransport creates a transport for requests to data nodes that does not share connections
+// with other clients, so that it can be configured further.
+func NewDataTransport() *http.Transport {
+	return newDataTransport(DataTLSConfig())
+}
+
+func newDataTransport(config *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     config,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+// LoadTLSConfig creates a client TLS configuration. The CA bundle is used to verify servers instead of
+// the system certificates, and the certificate and key are sent to servers that require client
+// certificates. Every file is optional.
+func LoadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
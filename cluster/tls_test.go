@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataURL(t *testing.T) {
+	assert.Equal(t, "http://influx-1:8086", DataURL("influx-1:8086"))
+	assert.Equal(t, "https://influx-1:8086", DataURL("https://influx-1:8086"))
+
+	config, err := LoadTLSConfig("", "", "")
+	assert.NoError(t, err)
+	SetDataTLS(config)
+	defer SetDataTLS(nil)
+	assert.Equal(t, "https://influx-1:8086", DataURL("influx-1:8086"))
+	assert.Equal(t, "http://influx-1:8086", DataURL("http://influx-1:8086"))
+}
+
+func TestNewDataClient_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	f, err := ioutil.TempFile("", "ca")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	f.Close()
+
+	config, err := LoadTLSConfig(f.Name(), "", "")
+	assert.NoError(t, err)
+	SetDataTLS(config)
+	defer SetDataTLS(nil)
+
+	location := strings.TrimPrefix(server.URL, "https://")
+	assert.True(t, IsAlive(location, NewDataClient(time.Second)))
+}
+
+func TestLoadTLSConfig_InvalidCA(t *testing.T) {
+	f, err := ioutil.TempFile("", "ca")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("not a certificate")
+	f.Close()
+
+	_, err = LoadTLSConfig(f.Name(), "", "")
+	assert.Error(t, err)
+}
@@ -137,13 +137,14 @@ func (wq *EtcdWorkQueue) Push(target string, payload interface{}) {
 }
 
 // lock prevents any other consumer from subscribing on the same queue.
-// The lock will be released when this node stops.
+// The lock will be released when this node stops. Queues of different types have their own locks,
+// so that a node can consume all of them.
 func (wq *EtcdWorkQueue) lock() error {
 	session, err := concurrency.NewSession(wq.Client)
 	if err != nil {
 		return err
 	}
-	mtx := concurrency.NewMutex(session, wq.path("tasks/lock/"+wq.Target))
+	mtx := concurrency.NewMutex(session, wq.path("tasks/lock/"+wq.Type+"/"+wq.Target))
 	err = mtx.Lock(context.Background())
 	if err != nil {
 		return err
@@ -64,12 +64,18 @@ func joinExisting(localNode *cluster.Node, tokenStorage cluster.LockableTokenSto
 	targetClient, _ := syncing.NewInfluxClientHTTPFromNode(*localNode)
 	importer.ImportPartitioned(reserved, targetClient)
 
-	oldDataHolders := map[int][]*cluster.Node{}
-	for _, token := range reserved {
-		oldDataHolders[token] = resolver.FindNodesByKey(token, cluster.WRITE)
-		for _, secondary := range resolver.ReverseSecondaryLookup(token) {
-			oldDataHolders[secondary] = resolver.FindNodesByKey(secondary, cluster.WRITE)
+	// Replicas depend on the replication factor, so the data of every replication scope is moved on its own.
+	scopes := resolver.ReplicationScopes()
+	oldDataHolders := map[cluster.ReplicationScope]map[int][]*cluster.Node{}
+	for _, scope := range scopes {
+		holders := map[int][]*cluster.Node{}
+		for _, token := range reserved {
+			holders[token] = resolver.FindNodesByKey(token, scope.Database, scope.Measurement, cluster.WRITE)
+			for _, secondary := range resolver.ReverseSecondaryLookup(token, scope.Database, scope.Measurement) {
+				holders[secondary] = resolver.FindNodesByKey(secondary, scope.Database, scope.Measurement, cluster.WRITE)
+			}
 		}
+		oldDataHolders[scope] = holders
 	}
 	for _, token := range reserved {
 		err = tokenStorage.Release(token)
@@ -90,36 +96,40 @@ func joinExisting(localNode *cluster.Node, tokenStorage cluster.LockableTokenSto
 	// This takes one token and finds what tokens are also replicated to to the same node this node is assigned to.
 	// This can only be done after assigning the tokens as the resolver needs to understand which
 	// nodes tokens are allocated to, as the logic is skipping tokens assigned to the same node.
-	secondaryTokens := []int{}
-	for _, token := range reserved {
-		secondaryTokens = append(secondaryTokens, resolver.ReverseSecondaryLookup(token)...)
-	}
-	if len(secondaryTokens) > 0 {
-		log.Println("Starting import of replicated data")
-		importer.ImportPartitioned(secondaryTokens, localNodeClient)
+	for _, scope := range scopes {
+		secondaryTokens := []int{}
+		for _, token := range reserved {
+			secondaryTokens = append(secondaryTokens, resolver.ReverseSecondaryLookup(token, scope.Database, scope.Measurement)...)
+		}
+		if len(secondaryTokens) > 0 {
+			log.Printf("Starting import of replicated data of %s", scopeName(scope))
+			importer.InScope(scope).ImportPartitioned(secondaryTokens, localNodeClient)
+		}
 	}
 
 	// Importing non partitioned after tokens been assigned to get primary and secondary data.
 	importer.ImportNonPartitioned(localNodeClient)
 
-	// The filtered list of primaries which not longer should hold data for assigned tokens.
-	deleteMap := map[int]*cluster.Node{}
-	for token, nodes := range oldDataHolders {
-		for _, node := range nodes {
-			// check if the token still resolves the location
-			// if not, the data should be deleted
-			shouldDelete := true
-			for _, replLoc := range resolver.FindByKey(token, cluster.WRITE) {
-				if replLoc == node.DataLocation {
-					shouldDelete = false
+	for _, scope := range scopes {
+		// The filtered list of primaries which not longer should hold data for assigned tokens.
+		deleteMap := map[int]*cluster.Node{}
+		for token, nodes := range oldDataHolders[scope] {
+			for _, node := range nodes {
+				// check if the token still resolves the location
+				// if not, the data should be deleted
+				shouldDelete := true
+				for _, replica := range resolver.FindNodesByKey(token, scope.Database, scope.Measurement, cluster.WRITE) {
+					if replica.DataLocation == node.DataLocation {
+						shouldDelete = false
+					}
+				}
+				if shouldDelete {
+					deleteMap[token] = node
 				}
-			}
-			if shouldDelete {
-				deleteMap[token] = node
 			}
 		}
+		deleteTokensData(deleteMap, importer.InScope(scope))
 	}
-	deleteTokensData(deleteMap, importer)
 	return nil
 }
 
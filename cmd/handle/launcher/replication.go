@@ -0,0 +1,85 @@
+package launcher
+
+import (
+	"log"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/adamringhede/influxdb-ha/syncing"
+)
+
+// ReplicationRebalancer moves data to and from the local node when a replication factor changes.
+// The tokens that the node becomes a replica of are imported through the work queue, and the data of
+// tokens that it no longer is a replica of is deleted, as the remaining replicas already have it.
+type ReplicationRebalancer struct {
+	resolver  *cluster.Resolver
+	importer  syncing.Importer
+	importWQ  cluster.WorkQueue
+	localNode *cluster.Node
+}
+
+func NewReplicationRebalancer(resolver *cluster.Resolver, importer syncing.Importer, importWQ cluster.WorkQueue, localNode *cluster.Node) *ReplicationRebalancer {
+	return &ReplicationRebalancer{resolver, importer, importWQ, localNode}
+}
+
+// Update sets the factor of the scope and starts moving its data.
+func (rb *ReplicationRebalancer) Update(scope cluster.ReplicationScope, factor int) {
+	oldFactor := rb.resolver.ReplicationFactorOf(scope.Database, scope.Measurement)
+	rb.resolver.SetReplicationFactor(scope, factor)
+	newFactor := rb.resolver.ReplicationFactorOf(scope.Database, scope.Measurement)
+	if oldFactor == newFactor {
+		return
+	}
+	log.Printf("Replication factor of %s changed from %d to %d", scopeName(scope), oldFactor, newFactor)
+
+	gained, lost := diffTokens(
+		rb.resolver.ReplicaTokens(rb.localNode.Name, oldFactor),
+		rb.resolver.ReplicaTokens(rb.localNode.Name, newFactor))
+	if len(gained) > 0 {
+		rb.importWQ.Push(rb.localNode.Name, syncing.ReliableImportPayload{Tokens: gained, NonPartitioned: true, Scope: &scope})
+	}
+	if len(lost) > 0 {
+		go rb.delete(scope, lost)
+	}
+}
+
+func (rb *ReplicationRebalancer) delete(scope cluster.ReplicationScope, tokens []int) {
+	client, err := syncing.NewInfluxClientHTTPFromNode(*rb.localNode)
+	if err != nil {
+		log.Printf("Failed to delete replicas of %s: %s", scopeName(scope), err)
+		return
+	}
+	importer := rb.importer.InScope(scope)
+	for _, token := range tokens {
+		if err := importer.DeleteByToken(client, token); err != nil {
+			log.Printf("Failed to delete replicas of %s with token %d: %s", scopeName(scope), token, err)
+		}
+	}
+}
+
+// diffTokens returns the tokens that are only in after, and those that are only in before.
+func diffTokens(before, after []int) (gained, lost []int) {
+	inBefore := map[int]bool{}
+	for _, token := range before {
+		inBefore[token] = true
+	}
+	inAfter := map[int]bool{}
+	for _, token := range after {
+		inAfter[token] = true
+		if !inBefore[token] {
+			gained = append(gained, token)
+		}
+	}
+	for _, token := range before {
+		if !inAfter[token] {
+			lost = append(lost, token)
+		}
+	}
+	return gained, lost
+}
+
+func scopeName(scope cluster.ReplicationScope) string {
+	if scope.Database == "" {
+		return "the cluster"
+	}
+	return scope.String()
+}
@@ -2,6 +2,7 @@ package launcher
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/adamringhede/influxdb-ha/cluster"
 	"github.com/adamringhede/influxdb-ha/service"
 	"github.com/adamringhede/influxdb-ha/syncing"
@@ -19,7 +20,11 @@ type Launcher struct {
 	recovery    cluster.RecoveryStorage
 	pks         cluster.PartitionKeyStorage
 	ns          cluster.NodeStorage
+	settings    cluster.ReplicationFactorStorage
+	drainer     *syncing.PartitionKeyDrainer
+	resharder   *syncing.PartitionKeyResharder
 	auth        service.AuthService
+	consistency *service.DefaultConsistency
 	httpConfig  service.Config
 
 	importer     syncing.Importer
@@ -29,13 +34,32 @@ type Launcher struct {
 	IsNew        bool
 }
 
-func NewLauncher(clusterID string, nodeName string, etcdEndpoints string, dataLocation string, httpConfig service.Config) *Launcher {
+func NewLauncher(clusterID string, nodeName string, etcdEndpoints string, dataLocation string, credentialsPath string, etcdTLS *tls.Config, httpConfig service.Config) *Launcher {
 	c, etcdErr := clientv3.New(clientv3.Config{
 		Endpoints:   strings.Split(etcdEndpoints, ","),
 		DialTimeout: etcdTimeout,
+		TLS:         etcdTLS,
 	})
 	handleErr(etcdErr)
 
+	// Credentials are needed for every request to the data nodes, so they are loaded first.
+	if credentialsPath != "" {
+		credentials, err := cluster.LoadDataCredentials(credentialsPath)
+		handleErr(err)
+		cluster.SetDataCredentials(credentials)
+	} else {
+		credentialsStorage := cluster.NewEtcdCredentialsStorage(c)
+		credentialsStorage.ClusterID = clusterID
+		credentials, err := credentialsStorage.Get()
+		handleErr(err)
+		cluster.SetDataCredentials(credentials)
+		go (func() {
+			for credentials := range credentialsStorage.Watch() {
+				cluster.SetDataCredentials(credentials)
+			}
+		})()
+	}
+
 	if err := isHttpAvailable(dataLocation); err != nil {
 		log.Panicf("Could not reach InfluxDB at %s because of %s", dataLocation, err)
 	}
@@ -59,13 +83,24 @@ func NewLauncher(clusterID string, nodeName string, etcdEndpoints string, dataLo
 	nodeCollection, err := cluster.NewSyncedNodeCollection(nodeStorage)
 	handleErr(err)
 
-	defaultReplicationFactor, err := settingsStorage.GetDefaultReplicationFactor(2)
+	defaultReplicationFactor, err := settingsStorage.GetDefaultReplicationFactor(cluster.DefaultReplicationFactor)
 	handleErr(err)
 
 	resolver := cluster.NewResolverWithNodes(nodeCollection)
 	_, err = cluster.NewResolverSyncer(resolver, tokenStorage, nodeCollection)
 	handleErr(err)
-	resolver.ReplicationFactor = defaultReplicationFactor
+	resolver.SetReplicationFactor(cluster.ReplicationScope{}, defaultReplicationFactor)
+	replicationFactors, err := settingsStorage.GetReplicationFactors()
+	handleErr(err)
+	for scope, factor := range replicationFactors {
+		resolver.SetReplicationFactor(scope, factor)
+	}
+
+	defaultConsistency, err := settingsStorage.GetDefaultWriteConsistency(service.DefaultConsistencyLevel.String())
+	handleErr(err)
+	consistencyLevel, err := service.ParseConsistencyLevel(defaultConsistency)
+	handleErr(err)
+	consistency := service.NewDefaultConsistency(consistencyLevel)
 
 	partitioner, err := cluster.NewSyncedPartitioner(partitionKeyStorage)
 	handleErr(err)
@@ -86,14 +121,33 @@ func NewLauncher(clusterID string, nodeName string, etcdEndpoints string, dataLo
 		tokenStorage.Assign(token, localNode.Name)
 	}
 
+	drainer := startDrainer(c, resolver, partitioner, partitionKeyStorage, *localNode, clusterID)
+	resharder := startResharder(c, resolver, partitioner, partitionKeyStorage, *localNode, clusterID)
+
 	authService := service.NewPersistentAuthService(authStorage)
 
 	// TODO change this to another way of handling node removal in the request handler.
 	nodeStorage.OnRemove(NewClusterNodeDeallocator(nodeCollection, tokenStorage, resolver, hintsStorage, importWQ).Remove)
 
+	rebalancer := NewReplicationRebalancer(resolver, importer, importWQ, localNode)
 	go (func() {
 		for rf := range settingsStorage.WatchDefaultReplicationFactor() {
-			resolver.ReplicationFactor = rf
+			rebalancer.Update(cluster.ReplicationScope{}, rf)
+		}
+	})()
+	go (func() {
+		for update := range settingsStorage.WatchReplicationFactors() {
+			rebalancer.Update(update.Scope, update.Factor)
+		}
+	})()
+	go (func() {
+		for update := range settingsStorage.WatchDefaultWriteConsistency() {
+			level, err := service.ParseConsistencyLevel(update)
+			if err != nil {
+				log.Printf("Ignoring default write consistency: %s", err)
+				continue
+			}
+			consistency.Set(level)
 		}
 	})()
 
@@ -106,7 +160,11 @@ func NewLauncher(clusterID string, nodeName string, etcdEndpoints string, dataLo
 		recoveryStorage,
 		partitionKeyStorage,
 		nodeStorage,
+		settingsStorage,
+		drainer,
+		resharder,
 		authService,
+		consistency,
 		httpConfig,
 		importer,
 		tokenStorage,
@@ -129,7 +187,7 @@ func (l *Launcher) Run() {
 }
 
 func (l *Launcher) Listen(ctx context.Context) {
-	service.Start(l.resolver, l.partitioner, l.recovery, l.pks, l.ns, l.auth, l.httpConfig, l.localNode, ctx)
+	service.Start(l.resolver, l.partitioner, l.recovery, l.pks, l.ns, l.settings, l.drainer, l.resharder, l.auth, l.consistency, l.httpConfig, l.localNode, ctx)
 }
 
 func (l *Launcher) Join() error {
@@ -188,6 +246,26 @@ func startImporter(importer syncing.Importer, etcdClient *clientv3.Client, resol
 	return reliableImporter, wq
 }
 
+// startDrainer starts the worker that drops the partition keys that are draining on this node.
+func startDrainer(etcdClient *clientv3.Client, resolver *cluster.Resolver, partitionKeys cluster.PartitionKeyCollection,
+	pks cluster.PartitionKeyStorage, localNode cluster.Node, clusterID string) *syncing.PartitionKeyDrainer {
+	wq := cluster.NewEtcdWorkQueue(etcdClient, localNode.Name, syncing.PartitionKeyDrainWorkName)
+	wq.ClusterID = clusterID
+	drainer := syncing.NewPartitionKeyDrainer(wq, resolver, partitionKeys, pks, localNode.Name)
+	go drainer.Start()
+	return drainer
+}
+
+// startResharder starts the worker that changes the tags of the partition keys that are resharding on this node.
+func startResharder(etcdClient *clientv3.Client, resolver *cluster.Resolver, partitionKeys cluster.PartitionKeyCollection,
+	pks cluster.PartitionKeyStorage, localNode cluster.Node, clusterID string) *syncing.PartitionKeyResharder {
+	wq := cluster.NewEtcdWorkQueue(etcdClient, localNode.Name, syncing.PartitionKeyReshardWorkName)
+	wq.ClusterID = clusterID
+	resharder := syncing.NewPartitionKeyResharder(wq, resolver, partitionKeys, pks, localNode.Name)
+	go resharder.Start()
+	return resharder
+}
+
 type NodeDeallocator interface {
 	// Remove should reassign partitions from the node to other nodes in the cluster.
 	Remove(cluster.Node)
@@ -229,17 +307,32 @@ func (nd *ClusterNodeDeallocator) Remove(node cluster.Node) {
 		// TODO Recover from being unable to get from tokenStorage
 		return
 	}
+	// The secondary tokens depend on the replication factor, so they are imported for each scope on its own.
+	scopes := nd.resolver.ReplicationScopes()
+	secondaryGroups := map[string]map[cluster.ReplicationScope][]int{}
 	var i int
 	for token, nodeName := range tokensMap {
 		if nodeName != node.Name {
 			selectedNode := nodes[i%len(nodes)]
 			tokenGroups[selectedNode] = append(tokenGroups[selectedNode], token)
-			tokenGroups[selectedNode] = append(tokenGroups[selectedNode], nd.resolver.ReverseSecondaryLookup(token)...)
+			if secondaryGroups[selectedNode] == nil {
+				secondaryGroups[selectedNode] = map[cluster.ReplicationScope][]int{}
+			}
+			for _, scope := range scopes {
+				secondaryGroups[selectedNode][scope] = append(secondaryGroups[selectedNode][scope],
+					nd.resolver.ReverseSecondaryLookup(token, scope.Database, scope.Measurement)...)
+			}
 			i++
 		}
 	}
 	for nodeName, tokens := range tokenGroups {
 		nd.importWQ.Push(nodeName, syncing.ReliableImportPayload{Tokens: tokens, NonPartitioned: true})
+		for _, scope := range scopes {
+			if secondary := secondaryGroups[nodeName][scope]; len(secondary) > 0 {
+				scope := scope
+				nd.importWQ.Push(nodeName, syncing.ReliableImportPayload{Tokens: secondary, Scope: &scope})
+			}
+		}
 	}
 	// Remove all hints that may be held by this node. If the node is removed, there will be no way
 	// for it to recover the data to the target node so we need to delete the hints so that the target
@@ -2,6 +2,7 @@ package launcher
 
 import (
 	"fmt"
+	"github.com/adamringhede/influxdb-ha/cluster"
 	influx "github.com/influxdata/influxdb/client/v2"
 	"log"
 	"strings"
@@ -45,7 +46,8 @@ func isVersionSupported(version string) bool {
 
 func newInfluxClient(location string) influx.Client {
 	c, err := influx.NewHTTPClient(influx.HTTPConfig{
-		Addr: "http://" + location,
+		Addr:      cluster.DataURL(location),
+		TLSConfig: cluster.DataTLSConfig(),
 	})
 	if err != nil {
 		log.Fatal(err)
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
+	"github.com/adamringhede/influxdb-ha/cluster"
 	. "github.com/adamringhede/influxdb-ha/cmd/handle/launcher"
 	"github.com/adamringhede/influxdb-ha/service"
+	"log"
 	"os"
+	"strings"
 )
 
 func main() {
@@ -18,14 +22,95 @@ func main() {
 	dataLocation := flag.String("data", "localhost:8086", "InfluxDB database public host:port")
 	etcdEndpoints := flag.String("etcd", "localhost:2379", "Comma separated locations of etcd nodes")
 	clusterID := flag.String("cluster-id", "default", "Comma separated locations of etcd nodes")
+	credentialsPath := flag.String("data-credentials", "", "JSON file with credentials for InfluxDB on the data nodes, instead of those stored in etcd")
 	nodeName := flag.String("node-name", hostName, "A unique name of the node to use instead of the hostname")
+	queryTimeout := flag.Duration("query-timeout", service.DefaultQueryTimeout, "Deadline of a query, including all requests to data nodes")
+	queryConcurrency := flag.Int("query-concurrency", service.DefaultQueryConcurrency, "Maximum number of data nodes that a query requests at the same time")
+	queryHedgeDelay := flag.Duration("query-hedge-delay", service.DefaultQueryHedgeDelay, "Time to wait for a replica before the next one is requested as well")
+	writeBatchSize := flag.Int("write-batch-size", service.DefaultWriteBatchSize, "Maximum number of points written to a node in one request")
+	writeBatchInterval := flag.Duration("write-batch-interval", service.DefaultWriteBatchInterval, "Time to collect points for a node before writing them, 0 disables batching")
+
+	tlsCert := flag.String("tls-cert", "", "Certificate file for serving clients with HTTPS")
+	tlsKey := flag.String("tls-key", "", "Private key file for serving clients with HTTPS")
+	dataTLS := flag.Bool("data-tls", false, "Use HTTPS for data nodes that do not have a scheme in their location")
+	dataCA := flag.String("data-ca", "", "CA bundle for verifying data nodes instead of the system certificates")
+	dataCert := flag.String("data-cert", "", "Client certificate file for data nodes that require one")
+	dataKey := flag.String("data-key", "", "Client private key file for data nodes that require one")
+	etcdCA := flag.String("etcd-ca", "", "CA bundle for connecting to etcd with TLS")
+	etcdCert := flag.String("etcd-cert", "", "Client certificate file for etcd")
+	etcdKey := flag.String("etcd-key", "", "Client private key file for etcd")
+
+	walDir := flag.String("wal-dir", "", "Directory for a write-ahead log that saves writes before they are relayed, disabled if empty")
+	walMaxSize := flag.Int64("wal-max-size", service.DefaultWALMaxSize, "Size in bytes of the write-ahead log at which writes are rejected")
+
+	udpBind := flag.String("udp-bind", "", "Address for receiving line protocol over UDP, disabled if empty")
+	udpDB := flag.String("udp-db", "udp", "Database of points received over UDP")
+	udpRP := flag.String("udp-rp", "", "Retention policy of points received over UDP")
+	udpPrecision := flag.String("udp-precision", "", "Precision of timestamps received over UDP")
+	graphiteBind := flag.String("graphite-bind", "", "Address for receiving Graphite metrics, disabled if empty")
+	graphiteDB := flag.String("graphite-db", "graphite", "Database of Graphite metrics")
+	graphiteRP := flag.String("graphite-rp", "", "Retention policy of Graphite metrics")
+	graphiteProtocol := flag.String("graphite-protocol", "tcp", "Protocol for Graphite metrics, tcp or udp")
+	graphiteSeparator := flag.String("graphite-separator", ".", "Separator of measurement names created by Graphite templates")
+	graphiteTemplates := flag.String("graphite-templates", "", "Semicolon separated Graphite templates")
 
 	flag.Parse()
 
+	if *dataTLS || *dataCA != "" || *dataCert != "" {
+		config, err := cluster.LoadTLSConfig(*dataCA, *dataCert, *dataKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cluster.SetDataTLS(config)
+	}
+	var etcdTLS *tls.Config
+	if *etcdCA != "" || *etcdCert != "" {
+		var err error
+		etcdTLS, err = cluster.LoadTLSConfig(*etcdCA, *etcdCert, *etcdKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	httpConfig := service.Config{
 		BindAddr: *bindClientAddr,
 		BindPort: *bindClientPort,
+
+		TLSCertFile: *tlsCert,
+		TLSKeyFile:  *tlsKey,
+
+		WALDir:     *walDir,
+		WALMaxSize: *walMaxSize,
+
+		QueryTimeout:     *queryTimeout,
+		QueryConcurrency: *queryConcurrency,
+		QueryHedgeDelay:  *queryHedgeDelay,
+
+		WriteBatchSize:     *writeBatchSize,
+		WriteBatchInterval: *writeBatchInterval,
+	}
+	if *udpBind != "" {
+		httpConfig.UDP = append(httpConfig.UDP, service.UDPConfig{
+			BindAddress:     *udpBind,
+			Database:        *udpDB,
+			RetentionPolicy: *udpRP,
+			Precision:       *udpPrecision,
+		})
+	}
+	if *graphiteBind != "" {
+		var templates []string
+		if *graphiteTemplates != "" {
+			templates = strings.Split(*graphiteTemplates, ";")
+		}
+		httpConfig.Graphite = append(httpConfig.Graphite, service.GraphiteConfig{
+			BindAddress:     *graphiteBind,
+			Database:        *graphiteDB,
+			RetentionPolicy: *graphiteRP,
+			Protocol:        *graphiteProtocol,
+			Separator:       *graphiteSeparator,
+			Templates:       templates,
+		})
 	}
-	launcher := NewLauncher(*clusterID, *nodeName, *etcdEndpoints, *dataLocation, httpConfig)
+	launcher := NewLauncher(*clusterID, *nodeName, *etcdEndpoints, *dataLocation, *credentialsPath, etcdTLS, httpConfig)
 	launcher.Run()
 }
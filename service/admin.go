@@ -3,6 +3,7 @@ package service
 import (
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/adamringhede/influxdb-ha/cluster"
@@ -14,17 +15,35 @@ import (
 var clusterLanguage = clusterql.CreateLanguage()
 
 func isAdminQuery(queryParam string) bool {
-	matched, err := regexp.MatchString("(REMOVE|SHOW|DROP|CREATE|SET)\\s+(NODES|NODE|PARTITION|REPLICATION)", strings.ToUpper(queryParam))
+	matched, err := regexp.MatchString("(REMOVE|SHOW|DROP|CREATE|UPDATE|SET)\\s+(NODES|NODE|PARTITION|REPLICATION)", strings.ToUpper(queryParam))
 	if err != nil {
 		fmt.Printf("Warning: Rexexp error on query: %s\n", err.Error())
 	}
 	return matched
 }
 
+// PartitionKeyDrainer moves the data of a draining partition key to the nodes of its database
+// before removing the key.
+type PartitionKeyDrainer interface {
+	Drain(key cluster.PartitionKey) error
+}
+
+// PartitionKeyResharder moves the data of a resharding partition key to the nodes of its new tags
+// before switching the key to them.
+type PartitionKeyResharder interface {
+	Reshard(key cluster.PartitionKey) error
+}
+
 type ClusterHandler struct {
 	partitionKeyStorage cluster.PartitionKeyStorage
 	nodeStorage         cluster.NodeStorage
+	settingsStorage     cluster.ReplicationFactorStorage
 	authService			AuthService
+	drainer             PartitionKeyDrainer
+	resharder           PartitionKeyResharder
+	// resolver and client are used for inspecting existing data when creating partition keys.
+	resolver            *cluster.Resolver
+	client              *http.Client
 }
 
 func (h *ClusterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -41,9 +60,17 @@ func (h *ClusterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case clusterql.ShowPartitionKeysStatement:
 		handleShowPartitionKeys(_stmt, h.partitionKeyStorage, w)
 	case clusterql.CreatePartitionKeyStatement:
-		handleCreatePartitionKey(_stmt, h.partitionKeyStorage, w)
+		h.handleCreatePartitionKey(_stmt, r, w)
+	case clusterql.ExplainCreatePartitionKeyStatement:
+		h.handleExplainCreatePartitionKey(_stmt, r, w)
 	case clusterql.DropPartitionKeyStatement:
-		handleDropPartitionKey(_stmt, h.partitionKeyStorage, w)
+		handleDropPartitionKey(_stmt, h.partitionKeyStorage, h.drainer, w)
+	case clusterql.UpdatePartitionKeyStatement:
+		handleUpdatePartitionKey(_stmt, h.partitionKeyStorage, h.resharder, w)
+	case clusterql.SetReplicationFactorStatement:
+		handleSetReplicationFactor(_stmt, h.settingsStorage, w)
+	case clusterql.ShowReplicationFactorsStatement:
+		handleShowReplicationFactors(_stmt, h.settingsStorage, w)
 	case clusterql.RemoveNodeStatement:
 		handleRemoveNode(_stmt, h.nodeStorage, w)
 	case clusterql.ShowNodesStatement:
@@ -86,41 +113,236 @@ func createListResults(name string, columns []string, values [][]interface{}) []
 
 func handleShowPartitionKeys(stmt clusterql.ShowPartitionKeysStatement, pks cluster.PartitionKeyStorage, w http.ResponseWriter) {
 	keys, err := pks.GetAll()
-	handleInternalError(w, err)
-	columns := []string{"database", "measurement", "tags"}
+	if err != nil {
+		handleInternalError(w, err)
+		return
+	}
+	columns := []string{"database", "measurement", "tags", "status", "progress"}
 	var values [][]interface{}
 	for _, key := range keys {
 		if stmt.Database == "" || stmt.Database == key.Database {
-			values = append(values, []interface{}{key.Database, key.Measurement, strings.Join(key.Tags, ".")})
+			status := "active"
+			if key.Draining() {
+				status = key.Status
+			} else if key.Resharding() {
+				status = key.Status + " with " + strings.Join(key.MigrationTags, ".")
+			}
+			values = append(values, []interface{}{key.Database, key.Measurement, strings.Join(key.Tags, "."), status, key.Progress})
 		}
 	}
 	respondWithResults(w, createListResults("partition keys", columns, values))
 }
 
-func handleCreatePartitionKey(stmt clusterql.CreatePartitionKeyStatement, pks cluster.PartitionKeyStorage, w http.ResponseWriter) {
+func (h *ClusterHandler) handleCreatePartitionKey(stmt clusterql.CreatePartitionKeyStatement, r *http.Request, w http.ResponseWriter) {
+	partitionKey, report, ok := h.inspectNewPartitionKey(stmt, r, w)
+	if !ok {
+		return
+	}
+	if err := h.partitionKeyStorage.Save(partitionKey); err != nil {
+		handleInternalError(w, err)
+		return
+	}
+	if len(report.warnings) == 0 {
+		respondWithEmpty(w)
+		return
+	}
+	respondWithResults(w, []Result{{Messages: report.messages()}})
+}
+
+// handleExplainCreatePartitionKey reports how the existing data would be distributed by the key
+// without creating it.
+func (h *ClusterHandler) handleExplainCreatePartitionKey(stmt clusterql.ExplainCreatePartitionKeyStatement, r *http.Request, w http.ResponseWriter) {
+	partitionKey, report, ok := h.inspectNewPartitionKey(stmt.CreatePartitionKeyStatement, r, w)
+	if !ok {
+		return
+	}
+	respondWithResults(w, report.results(*partitionKey))
+}
+
+// inspectNewPartitionKey checks that the key can be created and inspects the existing data that it
+// would partition. If it can not be created, an error is written and false is returned.
+func (h *ClusterHandler) inspectNewPartitionKey(stmt clusterql.CreatePartitionKeyStatement, r *http.Request, w http.ResponseWriter) (*cluster.PartitionKey, *partitionKeyReport, bool) {
 	partitionKey := &cluster.PartitionKey{Database: stmt.Database, Measurement: stmt.Measurement, Tags: stmt.Tags}
-	// check that one not already exists
-	// create and save one
-	keys, err := pks.GetAll()
-	handleInternalError(w, err)
+	keys, err := h.partitionKeyStorage.GetAll()
+	if err != nil {
+		handleInternalError(w, err)
+		return nil, nil, false
+	}
+	// It should not be possible to create a partition key for a collection that already has one.
 	for _, pk := range keys {
 		if pk.Identifier() == partitionKey.Identifier() {
 			jsonError(w, http.StatusConflict, "a partition key already exist on "+pk.Identifier())
+			return nil, nil, false
+		}
+	}
+	seen := map[string]bool{}
+	for _, tag := range stmt.Tags {
+		if tag == "" || seen[tag] {
+			jsonError(w, http.StatusBadRequest, "invalid partition key tags "+strings.Join(stmt.Tags, ", "))
+			return nil, nil, false
+		}
+		seen[tag] = true
+	}
+	report, err := inspectPartitionKey(*partitionKey, keys, h.resolver, h.client, r)
+	if err != nil {
+		handleInternalError(w, err)
+		return nil, nil, false
+	}
+	if len(report.problems) > 0 {
+		jsonError(w, http.StatusBadRequest, "invalid partition key: "+strings.Join(report.problems, "; "))
+		return nil, nil, false
+	}
+	return partitionKey, report, true
+}
+
+// handleDropPartitionKey marks the key as draining and leaves it to the drainer to move its data
+// before it is removed. Without a drainer, the key is removed immediately.
+func handleDropPartitionKey(stmt clusterql.DropPartitionKeyStatement, pks cluster.PartitionKeyStorage, drainer PartitionKeyDrainer, w http.ResponseWriter) {
+	if drainer == nil {
+		if err := pks.Drop(stmt.Database, stmt.Measurement); err != nil {
+			handleInternalError(w, err)
 			return
 		}
+		respondWithEmpty(w)
+		return
+	}
+	keys, err := pks.GetAll()
+	if err != nil {
+		handleInternalError(w, err)
+		return
+	}
+	id := cluster.CreatePartitionKeyIdentifier(stmt.Database, stmt.Measurement)
+	pk := findPartitionKey(keys, stmt.Database, stmt.Measurement)
+	if pk == nil {
+		jsonError(w, http.StatusNotFound, "no partition key exists on "+id)
+		return
+	}
+	if pk.Status != "" {
+		jsonError(w, http.StatusConflict, "the partition key on "+id+" is already being changed")
+		return
+	}
+	if stmt.Measurement != "" && findPartitionKey(keys, stmt.Database, "") != nil {
+		// The data would have to be partitioned by the key of the database instead.
+		jsonError(w, http.StatusBadRequest, "the partition key on "+id+" can not be dropped while "+stmt.Database+" has a partition key")
+		return
+	}
+	draining := *pk
+	draining.Status = cluster.PartitionKeyStatusDraining
+	if err := pks.Save(&draining); err != nil {
+		handleInternalError(w, err)
+		return
+	}
+	if err := drainer.Drain(draining); err != nil {
+		handleInternalError(w, err)
+		return
 	}
-	// It should not be possible to create a partition token for a collection that already has one.
-	saveErr := pks.Save(partitionKey)
-	handleInternalError(w, saveErr)
 	respondWithEmpty(w)
 }
 
-func handleDropPartitionKey(stmt clusterql.DropPartitionKeyStatement, pks cluster.PartitionKeyStorage, w http.ResponseWriter) {
-	err := pks.Drop(stmt.Database, stmt.Measurement)
-	handleInternalError(w, err)
+// handleUpdatePartitionKey marks the key as resharding and leaves it to the resharder to move its data
+// before the key is switched to the new tags. Without a resharder, the tags are changed immediately.
+func handleUpdatePartitionKey(stmt clusterql.UpdatePartitionKeyStatement, pks cluster.PartitionKeyStorage, resharder PartitionKeyResharder, w http.ResponseWriter) {
+	keys, err := pks.GetAll()
+	if err != nil {
+		handleInternalError(w, err)
+		return
+	}
+	id := cluster.CreatePartitionKeyIdentifier(stmt.Database, stmt.Measurement)
+	pk := findPartitionKey(keys, stmt.Database, stmt.Measurement)
+	if pk == nil {
+		jsonError(w, http.StatusNotFound, "no partition key exists on "+id)
+		return
+	}
+	if pk.Status != "" {
+		jsonError(w, http.StatusConflict, "the partition key on "+id+" is already being changed")
+		return
+	}
+	for _, tag := range stmt.Tags {
+		if tag == "" {
+			jsonError(w, http.StatusBadRequest, "invalid partition key tags "+strings.Join(stmt.Tags, "."))
+			return
+		}
+	}
+	if strings.Join(pk.Tags, ".") == strings.Join(stmt.Tags, ".") {
+		jsonError(w, http.StatusBadRequest, "the partition key on "+id+" already has the tags "+strings.Join(stmt.Tags, "."))
+		return
+	}
+
+	updated := *pk
+	if resharder == nil {
+		updated.Tags = stmt.Tags
+		if err := pks.Save(&updated); err != nil {
+			handleInternalError(w, err)
+			return
+		}
+		respondWithEmpty(w)
+		return
+	}
+	updated.Status = cluster.PartitionKeyStatusResharding
+	updated.MigrationTags = stmt.Tags
+	if err := pks.Save(&updated); err != nil {
+		handleInternalError(w, err)
+		return
+	}
+	if err := resharder.Reshard(updated); err != nil {
+		handleInternalError(w, err)
+		return
+	}
 	respondWithEmpty(w)
 }
 
+func findPartitionKey(keys []*cluster.PartitionKey, db, msmt string) *cluster.PartitionKey {
+	for _, pk := range keys {
+		if pk.Database == db && pk.Measurement == msmt {
+			return pk
+		}
+	}
+	return nil
+}
+
+func handleSetReplicationFactor(stmt clusterql.SetReplicationFactorStatement, settings cluster.ReplicationFactorStorage, w http.ResponseWriter) {
+	// A factor of 0 removes the factor of a database or measurement, so that it uses the default again.
+	if stmt.Value < 0 || (stmt.Value == 0 && stmt.Database == "") {
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("invalid replication factor %d", stmt.Value))
+		return
+	}
+	err := settings.SetReplicationFactor(cluster.ReplicationScope{Database: stmt.Database, Measurement: stmt.Measurement}, stmt.Value)
+	if err != nil {
+		handleInternalError(w, err)
+		return
+	}
+	respondWithEmpty(w)
+}
+
+func handleShowReplicationFactors(stmt clusterql.ShowReplicationFactorsStatement, settings cluster.ReplicationFactorStorage, w http.ResponseWriter) {
+	defaultFactor, err := settings.GetDefaultReplicationFactor(cluster.DefaultReplicationFactor)
+	if err != nil {
+		handleInternalError(w, err)
+		return
+	}
+	factors, err := settings.GetReplicationFactors()
+	if err != nil {
+		handleInternalError(w, err)
+		return
+	}
+	filter := cluster.ReplicationScope{Database: stmt.Database, Measurement: stmt.Measurement}
+	values := [][]interface{}{}
+	if stmt.Database == "" {
+		values = append(values, []interface{}{"", "", defaultFactor})
+	}
+	scopes := make([]cluster.ReplicationScope, 0, len(factors))
+	for scope := range factors {
+		if filter.Includes(scope.Database, scope.Measurement) {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Slice(scopes, func(i, j int) bool { return scopes[i].String() < scopes[j].String() })
+	for _, scope := range scopes {
+		values = append(values, []interface{}{scope.Database, scope.Measurement, factors[scope]})
+	}
+	respondWithResults(w, createListResults("replication factors", []string{"database", "measurement", "factor"}, values))
+}
+
 func handleRemoveNode(stmt clusterql.RemoveNodeStatement, storage cluster.NodeStorage, w http.ResponseWriter) {
 	name := stmt.Name
 	ok, err := storage.Remove(name)
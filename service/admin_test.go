@@ -29,7 +29,7 @@ func TestUpdateReplicationFactor(t *testing.T) {
 func TestShowPartitionKeys(t *testing.T) {
 	t.Parallel()
 	pks, ch := setupAdminTest()
-	pks.Save(&cluster.PartitionKey{"test_db", "cpu", []string{"server_id"}})
+	pks.Save(&cluster.PartitionKey{Database: "test_db", Measurement: "cpu", Tags: []string{"server_id"}})
 
 	result := mustQueryClusterAuth(t, ch, "SHOW PARTITION KEYS", "admin:secret")
 	assert.Len(t, result[0].Series[0].Values, 1)
@@ -54,10 +54,137 @@ func TestCreatePartitionKey(t *testing.T) {
 	assert.Equal(t, 409, statusCode)
 }
 
+// setupExistingData lets the handler inspect two nodes that have series of cpu and mem in test_db.
+func setupExistingData(t *testing.T, ch *ClusterHandler) func() {
+	respond := func(q string) string {
+		switch {
+		case strings.HasPrefix(q, "SHOW TAG KEYS"):
+			return `{"results":[{"statement_id":0,"series":[` +
+				`{"name":"cpu","columns":["tagKey"],"values":[["host"],["region"]]},` +
+				`{"name":"mem","columns":["tagKey"],"values":[["host"]]}]}]}`
+		case strings.HasPrefix(q, "SHOW FIELD KEYS"):
+			return `{"results":[{"statement_id":0,"series":[` +
+				`{"name":"cpu","columns":["fieldKey","fieldType"],"values":[["value","float"]]},` +
+				`{"name":"mem","columns":["fieldKey","fieldType"],"values":[["free","integer"]]}]}]}`
+		case strings.HasPrefix(q, "SHOW SERIES"):
+			if !strings.HasSuffix(q, fmt.Sprintf("LIMIT %d", partitionKeySampleSize)) {
+				t.Errorf("series are not sampled: %s", q)
+			}
+			return `{"results":[{"statement_id":0,"series":[{"columns":["key"],"values":[` +
+				`["cpu,host=a,region=eu"],["cpu,host=b,region=eu"],["cpu,host=c,region=eu"],["mem,host=a"]]}]}]}`
+		}
+		t.Errorf("unexpected query %s", q)
+		return `{"results":[{"statement_id":0}]}`
+	}
+	one, two := newFakeNodeFunc(t, respond), newFakeNodeFunc(t, respond)
+	ch.resolver = newFakeCluster(one, two)
+	ch.client = &http.Client{}
+	return func() {
+		one.Close()
+		two.Close()
+	}
+}
+
+func TestCreatePartitionKeyValidation(t *testing.T) {
+	t.Parallel()
+	pks, ch := setupAdminTest()
+	defer setupExistingData(t, ch)()
+
+	statusCode, body := mustNotQueryClusterAuth(t, ch, "CREATE PARTITION KEY value ON test_db.cpu", "admin:secret")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, body, "value is a field of measurement cpu")
+
+	statusCode, body = mustNotQueryClusterAuth(t, ch, "CREATE PARTITION KEY zone ON test_db.cpu", "admin:secret")
+	assert.Equal(t, 400, statusCode)
+	assert.Contains(t, body, "zone is not a tag of cpu")
+
+	statusCode, _ = mustNotQueryClusterAuth(t, ch, "CREATE PARTITION KEY host, host ON test_db.cpu", "admin:secret")
+	assert.Equal(t, 400, statusCode)
+
+	keys, _ := pks.GetAll()
+	assert.Len(t, keys, 0)
+
+	result := mustQueryClusterAuth(t, ch, "CREATE PARTITION KEY region ON test_db.cpu", "admin:secret")
+	if assert.Len(t, result[0].Messages, 1) {
+		assert.Equal(t, "warning", result[0].Messages[0].Level)
+		assert.Contains(t, result[0].Messages[0].Text, "has only 1 distinct values")
+	}
+	keys, _ = pks.GetAll()
+	assert.Len(t, keys, 1)
+}
+
+func TestExplainCreatePartitionKey(t *testing.T) {
+	t.Parallel()
+	pks, ch := setupAdminTest()
+	defer setupExistingData(t, ch)()
+
+	result := mustQueryClusterAuth(t, ch, "EXPLAIN CREATE PARTITION KEY host ON test_db", "admin:secret")
+	keys, _ := pks.GetAll()
+	assert.Len(t, keys, 0)
+
+	tags, nodes := result[0].Series[0], result[0].Series[1]
+	assert.Equal(t, [][]interface{}{{"host", float64(3)}}, tags.Values)
+	assert.Len(t, nodes.Values, 2)
+	total := 0.0
+	for _, v := range nodes.Values {
+		total += v[1].(float64)
+	}
+	// The series of cpu and mem with host a are counted separately.
+	assert.Equal(t, float64(4), total)
+
+	result = mustQueryClusterAuth(t, ch, "EXPLAIN CREATE PARTITION KEY region ON test_db", "admin:secret")
+	if assert.NotEmpty(t, result[0].Messages) {
+		assert.Contains(t, result[0].Messages[0].Text, "points of mem would be rejected")
+	}
+
+	// Only the series of measurements that would use the key are counted.
+	pks.Save(&cluster.PartitionKey{Database: "test_db", Measurement: "mem", Tags: []string{"host"}})
+	result = mustQueryClusterAuth(t, ch, "EXPLAIN CREATE PARTITION KEY region ON test_db", "admin:secret")
+	total = 0.0
+	for _, v := range result[0].Series[1].Values {
+		total += v[1].(float64)
+	}
+	assert.Equal(t, float64(3), total)
+	if assert.Len(t, result[0].Messages, 1) {
+		assert.Contains(t, result[0].Messages[0].Text, "has only 1 distinct values")
+	}
+}
+
+func TestCreatePartitionKeyWithoutData(t *testing.T) {
+	t.Parallel()
+	pks, ch := setupAdminTest()
+	node := newFakeNodeFunc(t, func(q string) string {
+		return `{"results":[{"statement_id":0,"error":"database not found: test_db"}]}`
+	})
+	defer node.Close()
+	unreachable := newFakeNodeFunc(t, func(q string) string { return "" })
+	unreachable.Close()
+	ch.resolver = newFakeCluster(node)
+	ch.client = &http.Client{}
+
+	// A database that does not exist yet has no data that the key could be invalid for.
+	result := mustQueryClusterAuth(t, ch, "CREATE PARTITION KEY host ON test_db", "admin:secret")
+	for _, res := range result {
+		assert.Empty(t, res.Messages)
+	}
+	keys, _ := pks.GetAll()
+	assert.Len(t, keys, 1)
+
+	// A node that can not be reached is left out of the report.
+	ch.resolver = newFakeCluster(node, unreachable)
+	result = mustQueryClusterAuth(t, ch, "CREATE PARTITION KEY host ON test_db.cpu", "admin:secret")
+	if assert.Len(t, result, 1) && assert.Len(t, result[0].Messages, 1) {
+		assert.Equal(t, "warning", result[0].Messages[0].Level)
+		assert.Contains(t, result[0].Messages[0].Text, "failed to inspect data at "+strings.TrimPrefix(unreachable.URL, "http://"))
+	}
+	keys, _ = pks.GetAll()
+	assert.Len(t, keys, 2)
+}
+
 func TestDropPartitionKey(t *testing.T) {
 	t.Parallel()
 	pks, ch := setupAdminTest()
-	pks.Save(&cluster.PartitionKey{"test_db", "cpu", []string{"server_id"}})
+	pks.Save(&cluster.PartitionKey{Database: "test_db", Measurement: "cpu", Tags: []string{"server_id"}})
 
 	mustQueryClusterAuth(t, ch, "DROP PARTITION KEY ON test_db.cpu", "admin:secret")
 
@@ -65,6 +192,110 @@ func TestDropPartitionKey(t *testing.T) {
 	assert.Len(t, keys, 0)
 }
 
+func TestDropPartitionKeyDraining(t *testing.T) {
+	t.Parallel()
+	pks, ch := setupAdminTest()
+	drainer := &MockedPartitionKeyDrainer{}
+	ch.drainer = drainer
+	pks.Save(&cluster.PartitionKey{Database: "test_db", Measurement: "cpu", Tags: []string{"server_id"}})
+
+	mustQueryClusterAuth(t, ch, "DROP PARTITION KEY ON test_db.cpu", "admin:secret")
+
+	keys, _ := pks.GetAll()
+	assert.Len(t, keys, 1)
+	assert.True(t, keys[0].Draining())
+	assert.Len(t, drainer.keys, 1)
+	assert.Equal(t, "test_db.cpu", drainer.keys[0].Identifier())
+
+	result := mustQueryClusterAuth(t, ch, "SHOW PARTITION KEYS", "admin:secret")
+	assert.Equal(t, "draining", result[0].Series[0].Values[0][3])
+
+	statusCode, _ := mustNotQueryClusterAuth(t, ch, "DROP PARTITION KEY ON test_db.cpu", "admin:secret")
+	assert.Equal(t, 409, statusCode)
+	statusCode, _ = mustNotQueryClusterAuth(t, ch, "DROP PARTITION KEY ON test_db.mem", "admin:secret")
+	assert.Equal(t, 404, statusCode)
+	assert.Len(t, drainer.keys, 1)
+}
+
+func TestUpdatePartitionKey(t *testing.T) {
+	t.Parallel()
+	pks, ch := setupAdminTest()
+	pks.Save(&cluster.PartitionKey{Database: "test_db", Measurement: "cpu", Tags: []string{"server_id"}})
+
+	mustQueryClusterAuth(t, ch, "UPDATE PARTITION KEY server_id.region ON test_db.cpu", "admin:secret")
+
+	keys, _ := pks.GetAll()
+	assert.Equal(t, []string{"server_id", "region"}, keys[0].Tags)
+
+	statusCode, _ := mustNotQueryClusterAuth(t, ch, "UPDATE PARTITION KEY server_id.region ON test_db.cpu", "admin:secret")
+	assert.Equal(t, 400, statusCode)
+	statusCode, _ = mustNotQueryClusterAuth(t, ch, "UPDATE PARTITION KEY server_id ON test_db.mem", "admin:secret")
+	assert.Equal(t, 404, statusCode)
+}
+
+func TestUpdatePartitionKeyResharding(t *testing.T) {
+	t.Parallel()
+	pks, ch := setupAdminTest()
+	resharder := &MockedPartitionKeyResharder{}
+	ch.resharder = resharder
+	ch.drainer = &MockedPartitionKeyDrainer{}
+	pks.Save(&cluster.PartitionKey{Database: "test_db", Measurement: "cpu", Tags: []string{"server_id"}})
+
+	mustQueryClusterAuth(t, ch, "UPDATE PARTITION KEY server_id.region ON test_db.cpu", "admin:secret")
+
+	keys, _ := pks.GetAll()
+	assert.True(t, keys[0].Resharding())
+	assert.Equal(t, []string{"server_id"}, keys[0].Tags)
+	assert.Equal(t, []string{"server_id", "region"}, keys[0].MigrationTags)
+	assert.Len(t, resharder.keys, 1)
+
+	result := mustQueryClusterAuth(t, ch, "SHOW PARTITION KEYS", "admin:secret")
+	assert.Equal(t, "resharding with server_id.region", result[0].Series[0].Values[0][3])
+
+	statusCode, _ := mustNotQueryClusterAuth(t, ch, "UPDATE PARTITION KEY region ON test_db.cpu", "admin:secret")
+	assert.Equal(t, 409, statusCode)
+	statusCode, _ = mustNotQueryClusterAuth(t, ch, "DROP PARTITION KEY ON test_db.cpu", "admin:secret")
+	assert.Equal(t, 409, statusCode)
+}
+
+func TestSetReplicationFactor(t *testing.T) {
+	t.Parallel()
+	_, ch := setupAdminTest()
+	settings := ch.settingsStorage.(*MockedSettingsStorage)
+
+	mustQueryClusterAuth(t, ch, "SET REPLICATION FACTOR 3", "admin:secret")
+	mustQueryClusterAuth(t, ch, "SET REPLICATION FACTOR 1 ON test_db", "admin:secret")
+	mustQueryClusterAuth(t, ch, "SET REPLICATION FACTOR 5 ON test_db.cpu", "admin:secret")
+	assert.Equal(t, 3, settings.defaultFactor)
+	assert.Equal(t, map[cluster.ReplicationScope]int{{Database: "test_db"}: 1, {Database: "test_db", Measurement: "cpu"}: 5}, settings.factors)
+
+	mustQueryClusterAuth(t, ch, "SET REPLICATION FACTOR 0 ON test_db", "admin:secret")
+	assert.Equal(t, map[cluster.ReplicationScope]int{{Database: "test_db", Measurement: "cpu"}: 5}, settings.factors)
+
+	statusCode, _ := mustNotQueryClusterAuth(t, ch, "SET REPLICATION FACTOR 0", "admin:secret")
+	assert.Equal(t, 400, statusCode)
+}
+
+func TestShowReplicationFactors(t *testing.T) {
+	t.Parallel()
+	_, ch := setupAdminTest()
+	settings := ch.settingsStorage.(*MockedSettingsStorage)
+	settings.SetReplicationFactor(cluster.ReplicationScope{Database: "test_db"}, 1)
+	settings.SetReplicationFactor(cluster.ReplicationScope{Database: "test_db", Measurement: "cpu"}, 5)
+	settings.SetReplicationFactor(cluster.ReplicationScope{Database: "other_db"}, 3)
+
+	results := mustQueryClusterAuth(t, ch, "SHOW REPLICATION FACTORS", "admin:secret")
+	assert.Equal(t, [][]interface{}{
+		{"", "", float64(2)},
+		{"other_db", "", float64(3)},
+		{"test_db", "", float64(1)},
+		{"test_db", "cpu", float64(5)},
+	}, results[0].Series[0].Values)
+
+	results = mustQueryClusterAuth(t, ch, "SHOW REPLICATION FACTORS ON test_db", "admin:secret")
+	assert.Len(t, results[0].Series[0].Values, 2)
+}
+
 func TestShowNodes(t *testing.T) {
 	t.Parallel()
 	_, ch := setupAdminTest()
@@ -98,7 +329,7 @@ func setupAdminTest() (cluster.PartitionKeyStorage, *ClusterHandler) {
 	authService.CreateUser(cluster.UserInfo{Name: "admin", Hash: cluster.HashUserPassword("secret"), Admin: true})
 	authService.Save()
 
-	ch := &ClusterHandler{partitionKeyStorage: pks, nodeStorage: ns, authService: authService}
+	ch := &ClusterHandler{partitionKeyStorage: pks, nodeStorage: ns, settingsStorage: NewMockedSettingsStorage(), authService: authService}
 	return pks, ch
 }
 
@@ -129,7 +360,9 @@ func mustQueryClusterAuth(t *testing.T, handler http.Handler, cmd string, auth s
 		}
 		assert.Equal(t, 200, resp.StatusCode, "Body: %s", string(body))
 	}
-	return parseResp(resp.Body, false).Results
+	parsed, err := parseResp(resp.Body, false)
+	assert.NoError(t, err)
+	return parsed.Results
 }
 
 func _execClusterCommand(handler http.Handler, cmd string, auth string) *http.Response {
@@ -197,6 +430,12 @@ func (s *MockedPartitionKeyStorage) Watch() clientv3.WatchChan {
 }
 
 func (s *MockedPartitionKeyStorage) Save(partitionKey *cluster.PartitionKey) error {
+	for i, pk := range s.storage {
+		if pk.Identifier() == partitionKey.Identifier() {
+			s.storage[i] = partitionKey
+			return nil
+		}
+	}
 	s.storage = append(s.storage, partitionKey)
 	return nil
 }
@@ -214,3 +453,55 @@ func (s *MockedPartitionKeyStorage) Drop(db, msmt string) error {
 func (s *MockedPartitionKeyStorage) GetAll() ([]*cluster.PartitionKey, error) {
 	return s.storage, nil
 }
+
+type MockedPartitionKeyDrainer struct {
+	keys []cluster.PartitionKey
+}
+
+func (d *MockedPartitionKeyDrainer) Drain(key cluster.PartitionKey) error {
+	d.keys = append(d.keys, key)
+	return nil
+}
+
+type MockedPartitionKeyResharder struct {
+	keys []cluster.PartitionKey
+}
+
+func (r *MockedPartitionKeyResharder) Reshard(key cluster.PartitionKey) error {
+	r.keys = append(r.keys, key)
+	return nil
+}
+
+type MockedSettingsStorage struct {
+	defaultFactor int
+	factors       map[cluster.ReplicationScope]int
+}
+
+func NewMockedSettingsStorage() *MockedSettingsStorage {
+	return &MockedSettingsStorage{cluster.DefaultReplicationFactor, map[cluster.ReplicationScope]int{}}
+}
+
+func (s *MockedSettingsStorage) GetDefaultReplicationFactor(fallback int) (int, error) {
+	return s.defaultFactor, nil
+}
+
+func (s *MockedSettingsStorage) SetDefaultReplicationFactor(factor int) error {
+	s.defaultFactor = factor
+	return nil
+}
+
+func (s *MockedSettingsStorage) GetReplicationFactors() (map[cluster.ReplicationScope]int, error) {
+	return s.factors, nil
+}
+
+func (s *MockedSettingsStorage) SetReplicationFactor(scope cluster.ReplicationScope, factor int) error {
+	if scope.Database == "" {
+		return s.SetDefaultReplicationFactor(factor)
+	}
+	if factor == 0 {
+		delete(s.factors, scope)
+	} else {
+		s.factors[scope] = factor
+	}
+	return nil
+}
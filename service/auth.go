@@ -6,6 +6,7 @@ import (
 	"github.com/influxdata/influxql"
 	"golang.org/x/crypto/bcrypt"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -25,7 +26,7 @@ type AuthService interface {
 // nil for the user and nil for the error as it is a valid state.
 func authenticate(r *http.Request, authService AuthService) (*cluster.UserInfo, error) {
 	if authService.HasAdmin() {
-		if username, password, ok := r.BasicAuth(); ok {
+		if username, password, ok := requestCredentials(r); ok {
 			user := authService.User(username)
 			if user == nil {
 				return nil, meta.ErrAuthenticate
@@ -40,6 +41,23 @@ func authenticate(r *http.Request, authService AuthService) (*cluster.UserInfo,
 	return nil, nil
 }
 
+// requestCredentials returns the username and password of basic authentication, or of a token
+// in the form "username:password" which is used by clients of the InfluxDB 2.0 API.
+func requestCredentials(r *http.Request) (string, string, bool) {
+	if username, password, ok := r.BasicAuth(); ok {
+		return username, password, true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Token ") {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(auth, "Token "), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
 func isAllowed(privileges influxql.ExecutionPrivileges, user cluster.UserInfo, db string) bool {
 	for _, p := range privileges {
 		if p.Admin && !user.Admin {
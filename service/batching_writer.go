@@ -0,0 +1,133 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxdb/models"
+)
+
+const (
+	DefaultWriteBatchSize     = 5000
+	DefaultWriteBatchInterval = 10 * time.Millisecond
+)
+
+// BatchingPointsWriter collects points that are written to the same node, database and retention policy,
+// so that points of many partitions and concurrent requests are sent in one request. A batch is written
+// when it is full or when the interval has passed since its first points were added. Every write waits
+// until the batches that its points were added to have been written.
+type BatchingPointsWriter struct {
+	writer   PointsWriter
+	size     int
+	interval time.Duration
+
+	mu      sync.Mutex
+	batches map[batchKey]*pointBatch
+}
+
+// batchKey leaves out the precision, as points keep their time regardless of it and are written in nanoseconds.
+type batchKey struct {
+	node string
+	db   string
+	rp   string
+}
+
+type pointBatch struct {
+	node   *cluster.Node
+	wc     WriteContext
+	points []models.Point
+	writes []*batchedWrite
+	timer  *time.Timer
+	done   chan struct{}
+}
+
+// batchedWrite is the points of one write in a batch, and the error of writing them.
+type batchedWrite struct {
+	points []models.Point
+	err    error
+}
+
+// NewBatchingPointsWriter creates a writer that writes batches of points to each node using the given writer.
+func NewBatchingPointsWriter(writer PointsWriter, size int, interval time.Duration) *BatchingPointsWriter {
+	return &BatchingPointsWriter{
+		writer:   writer,
+		size:     size,
+		interval: interval,
+		batches:  map[batchKey]*pointBatch{},
+	}
+}
+
+func (w *BatchingPointsWriter) WritePoints(points []models.Point, locations []*cluster.Node, wc WriteContext) error {
+	batches := make([]*pointBatch, len(locations))
+	writes := make([]*batchedWrite, len(locations))
+	for i, node := range locations {
+		batches[i], writes[i] = w.add(node, points, wc)
+	}
+	var err error
+	for i, b := range batches {
+		<-b.done
+		if writes[i].err != nil {
+			err = writes[i].err
+		}
+	}
+	return err
+}
+
+func (w *BatchingPointsWriter) add(node *cluster.Node, points []models.Point, wc WriteContext) (*pointBatch, *batchedWrite) {
+	key := batchKey{node.Name, wc.db, wc.rp}
+	write := &batchedWrite{points: points}
+	w.mu.Lock()
+	b, ok := w.batches[key]
+	if !ok {
+		b = &pointBatch{node: node, wc: wc, done: make(chan struct{})}
+		b.timer = time.AfterFunc(w.interval, func() { w.flushBatch(key, b) })
+		w.batches[key] = b
+	}
+	b.points = append(b.points, points...)
+	b.writes = append(b.writes, write)
+	full := len(b.points) >= w.size
+	if full {
+		delete(w.batches, key)
+	}
+	w.mu.Unlock()
+
+	if full {
+		// The batch was removed while holding the lock, so the timer will not write it as well.
+		b.timer.Stop()
+		go w.write(b)
+	}
+	return b, write
+}
+
+// flushBatch writes the batch when its interval has passed, unless it has already been written because it was full.
+func (w *BatchingPointsWriter) flushBatch(key batchKey, b *pointBatch) {
+	w.mu.Lock()
+	current, ok := w.batches[key]
+	if !ok || current != b {
+		w.mu.Unlock()
+		return
+	}
+	delete(w.batches, key)
+	w.mu.Unlock()
+	w.write(b)
+}
+
+// write writes the batch. Other errors than a rejection of the points are of the node, and are returned to
+// every write in the batch. If the points are rejected, it may only be because of the points of some of the
+// writes, so each write is written again on its own to return the error to those only. Points that the node
+// accepted the first time are then overwritten with the same values.
+func (w *BatchingPointsWriter) write(b *pointBatch) {
+	nodes := []*cluster.Node{b.node}
+	err := w.writer.WritePoints(b.points, nodes, b.wc)
+	if _, rejected := err.(rejectedWriteError); rejected && len(b.writes) > 1 {
+		for _, write := range b.writes {
+			write.err = w.writer.WritePoints(write.points, nodes, b.wc)
+		}
+	} else {
+		for _, write := range b.writes {
+			write.err = err
+		}
+	}
+	close(b.done)
+}
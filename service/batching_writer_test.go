@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxdb/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchingPointsWriter_CombinesWrites(t *testing.T) {
+	mock := NewMockPointsWriter()
+	writer := NewBatchingPointsWriter(mock, 4, time.Hour)
+	node := &cluster.Node{Name: "a"}
+	wc := WriteContext{db: testDB}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			points := []models.Point{newModelPoint(1), newModelPoint(2)}
+			assert.NoError(t, writer.WritePoints(points, []*cluster.Node{node}, wc))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, mock.writes)
+	assert.Len(t, mock.writtenPoints["a"], 4)
+}
+
+func TestBatchingPointsWriter_FlushesAfterInterval(t *testing.T) {
+	mock := NewMockPointsWriter()
+	writer := NewBatchingPointsWriter(mock, 100, 10*time.Millisecond)
+	nodes := []*cluster.Node{{Name: "a"}, {Name: "b"}}
+
+	wg := sync.WaitGroup{}
+	for _, db := range []string{"first", "second"} {
+		wg.Add(1)
+		go func(db string) {
+			defer wg.Done()
+			points := []models.Point{newModelPoint(1)}
+			assert.NoError(t, writer.WritePoints(points, nodes, WriteContext{db: db}))
+		}(db)
+	}
+	wg.Wait()
+	// Points of different databases are written separately to each node.
+	assert.Equal(t, 4, mock.writes)
+	assert.Len(t, mock.writtenPoints["a"], 2)
+	assert.Len(t, mock.writtenPoints["b"], 2)
+}
+
+func TestBatchingPointsWriter_ReportsErrors(t *testing.T) {
+	writer := NewBatchingPointsWriter(&FailingPointsWriter{}, 100, time.Millisecond)
+	points := []models.Point{newModelPoint(1)}
+	err := writer.WritePoints(points, []*cluster.Node{{Name: "a"}}, WriteContext{db: testDB})
+	assert.EqualError(t, err, "write failed")
+}
+
+// rejectingPointsWriter rejects writes with negative values, like a node rejects points with fields of another type.
+type rejectingPointsWriter struct {
+	mu     sync.Mutex
+	writes int
+}
+
+func (w *rejectingPointsWriter) WritePoints(points []models.Point, locations []*cluster.Node, wc WriteContext) error {
+	w.mu.Lock()
+	w.writes++
+	w.mu.Unlock()
+	for _, p := range points {
+		fields, _ := p.Fields()
+		if fields["value"].(float64) < 0 {
+			return rejectedWriteError{errors.New("partial write: field type conflict")}
+		}
+	}
+	return nil
+}
+
+func TestBatchingPointsWriter_ReportsRejectionsPerWrite(t *testing.T) {
+	rejecting := &rejectingPointsWriter{}
+	writer := NewBatchingPointsWriter(rejecting, 2, time.Hour)
+	node := &cluster.Node{Name: "a"}
+
+	errs := make(chan error, 2)
+	for _, value := range []float64{1, -1} {
+		go func(value float64) {
+			errs <- writer.WritePoints([]models.Point{newModelPoint(value)}, []*cluster.Node{node}, WriteContext{db: testDB})
+		}(value)
+	}
+	failed := 0
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			failed++
+		}
+	}
+	// Only the write with the rejected point fails, after the batch was written again write by write.
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, 3, rejecting.writes)
+}
+
+func TestBatchingPointsWriter_CombinesPrecisions(t *testing.T) {
+	mock := NewMockPointsWriter()
+	writer := NewBatchingPointsWriter(mock, 2, time.Hour)
+	node := &cluster.Node{Name: "a"}
+
+	wg := sync.WaitGroup{}
+	for _, precision := range []string{"s", "ms"} {
+		wg.Add(1)
+		go func(precision string) {
+			defer wg.Done()
+			wc := WriteContext{precision: precision, db: testDB}
+			assert.NoError(t, writer.WritePoints([]models.Point{newModelPoint(1)}, []*cluster.Node{node}, wc))
+		}(precision)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, mock.writes)
+}
+
+func newModelPoint(value float64) models.Point {
+	return models.MustNewPoint("treasures", models.NewTags(map[string]string{"type": "gold"}),
+		models.Fields{"value": value}, time.Now())
+}
@@ -1,6 +1,9 @@
 package clusterql
 
-import "strings"
+import (
+	"bytes"
+	"strconv"
+)
 
 func CreateLanguage() *Language {
 	lang := NewLanguage()
@@ -8,59 +11,68 @@ func CreateLanguage() *Language {
 		return ShowPartitionKeysStatement{}
 	})
 	lang.Spec(SHOW, PARTITION, KEYS, ON, STR).Handle(func(params Params) Statement {
-		return ShowPartitionKeysStatement{Database: params[0]}
+		return ShowPartitionKeysStatement{Database: unquote(params[0])}
 	})
 	// TODO Consider having/requiring database as a default parameter
-	// TODO Add validation
-	lang.Spec(CREATE, PARTITION, KEY, ON, STR, WITH, STR).Handle(func(params Params) Statement {
-		parts := strings.Split(params[0], ".")
-		tags := strings.Split(params[1], ".")
-		switch len(parts) {
-		case 1:
-			return CreatePartitionKeyStatement{parts[0], "", tags}
-		case 2:
-			return CreatePartitionKeyStatement{parts[0], parts[1], tags}
-		}
-		return nil
+	// Tags are separated by commas. Tags that are not quoted are also split at dots, which is how
+	// they were separated before.
+	lang.Spec(CREATE, PARTITION, KEY, ON, STR, WITH, LIST).Handle(func(params Params) Statement {
+		db, msmt := splitScope(params[0])
+		return CreatePartitionKeyStatement{db, msmt, splitTags(params[1])}
 	})
-	lang.Spec(CREATE, PARTITION, KEY, STR, ON, STR).Handle(func(params Params) Statement {
-		parts := strings.Split(params[1], ".")
-		tags := strings.Split(params[0], ".")
-		switch len(parts) {
-		case 1:
-			return CreatePartitionKeyStatement{parts[0], "", tags}
-		case 2:
-			return CreatePartitionKeyStatement{parts[0], parts[1], tags}
-		}
-		return nil
+	lang.Spec(CREATE, PARTITION, KEY, LIST, ON, STR).Handle(func(params Params) Statement {
+		db, msmt := splitScope(params[1])
+		return CreatePartitionKeyStatement{db, msmt, splitTags(params[0])}
+	})
+	// Explaining a partition key validates it and reports how the existing data would be distributed
+	// without creating it.
+	lang.Spec(EXPLAIN, CREATE, PARTITION, KEY, ON, STR, WITH, LIST).Handle(func(params Params) Statement {
+		db, msmt := splitScope(params[0])
+		return ExplainCreatePartitionKeyStatement{CreatePartitionKeyStatement{db, msmt, splitTags(params[1])}}
+	})
+	lang.Spec(EXPLAIN, CREATE, PARTITION, KEY, LIST, ON, STR).Handle(func(params Params) Statement {
+		db, msmt := splitScope(params[1])
+		return ExplainCreatePartitionKeyStatement{CreatePartitionKeyStatement{db, msmt, splitTags(params[0])}}
 	})
+	// Dropping a partition key does not immediately remove it. The key is marked as draining and a task
+	// imports its data to the nodes of the database hash after a delay of about a minute, before the key
+	// is removed and the copies that are no longer needed are deleted.
 	lang.Spec(DROP, PARTITION, KEY, ON, STR).Handle(func(params Params) Statement {
-		parts := strings.Split(params[0], ".")
-		switch len(parts) {
-		case 1:
-			return DropPartitionKeyStatement{parts[0], ""}
-		case 2:
-			return DropPartitionKeyStatement{parts[0], parts[1]}
-		}
-		// dropping a partitiion key will not immidiately remove it. a task will be created for first importing the
-		// data to all nodes that should have the data based on the new token assigned to the measurement.
-		// there is a delay of about a minute before starting the import in case te user changes its mind
-		return nil
+		db, msmt := splitScope(params[0])
+		return DropPartitionKeyStatement{db, msmt}
 	})
 	lang.Spec(SHOW, NODES).Handle(func(params Params) Statement {
 		return ShowNodesStatement{}
 	})
 	lang.Spec(REMOVE, NODE, STR).Handle(func(params Params) Statement {
-		return RemoveNodeStatement{params[0]}
+		return RemoveNodeStatement{unquote(params[0])}
 	})
 
-	// UPDATE PARTITION KEY ON
-	// UPDATE PARTITION KEY tags ON db.msmt AS new-msmt
-	// This is practically what drop partition key does as well. All data need to be downloaded and the partition
-	// key tag updated before saveing the data in its new position.
+	// Updating a partition key moves the data to the nodes of its new tags while points are written by
+	// both, after which the key is switched and the copies that are no longer needed are deleted.
+	// Copying the data to another measurement with AS is not supported.
+	lang.Spec(UPDATE, PARTITION, KEY, LIST, ON, STR).Handle(func(params Params) Statement {
+		db, msmt := splitScope(params[1])
+		return UpdatePartitionKeyStatement{db, msmt, splitTags(params[0])}
+	})
+
+	lang.Spec(SET, REPLICATION, FACTOR, NUM).Handle(func(params Params) Statement {
+		factor, _ := strconv.Atoi(params[0])
+		return SetReplicationFactorStatement{Value: factor}
+	})
+	lang.Spec(SET, REPLICATION, FACTOR, NUM, ON, STR).Handle(func(params Params) Statement {
+		factor, _ := strconv.Atoi(params[0])
+		db, msmt := splitScope(params[1])
+		return SetReplicationFactorStatement{db, msmt, factor}
+	})
+	lang.Spec(SHOW, REPLICATION, FACTORS).Handle(func(params Params) Statement {
+		return ShowReplicationFactorsStatement{}
+	})
+	lang.Spec(SHOW, REPLICATION, FACTORS, ON, STR).Handle(func(params Params) Statement {
+		db, msmt := splitScope(params[0])
+		return ShowReplicationFactorsStatement{db, msmt}
+	})
 
-	// SET REPLICATION FACTOR 3 ON "mydb.mymeasurement" lang.Spec(SET, REPLICATION, FACTOR, NUM, ON, STR)
-	// SHOW REPLICATIONS FACTORS
 	// Removing a node will redistribute tokens. Those nodes should then start to importing data just as
 	// if they joined the cluster initially.
 
@@ -68,6 +80,68 @@ func CreateLanguage() *Language {
 	return lang
 }
 
+// splitScope splits "db.msmt" at the first dot that is not quoted, as measurement names may contain dots.
+func splitScope(s string) (string, string) {
+	parts := splitUnquoted(s, '.', 2)
+	if len(parts) == 1 {
+		return unquote(parts[0]), ""
+	}
+	return unquote(parts[0]), unquote(parts[1])
+}
+
+// splitTags returns the tags of a comma separated list.
+func splitTags(list string) []string {
+	tags := []string{}
+	for _, item := range splitUnquoted(list, ',', -1) {
+		for _, tag := range splitUnquoted(item, '.', -1) {
+			tags = append(tags, unquote(tag))
+		}
+	}
+	return tags
+}
+
+// splitUnquoted splits the string at the separators that are not within quotes into at most n parts,
+// or all parts if n is negative.
+func splitUnquoted(s string, sep rune, n int) []string {
+	parts := []string{}
+	quoted, escaped := false, false
+	start := 0
+	for i, ch := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && ch == '\\':
+			escaped = true
+		case ch == '"':
+			quoted = !quoted
+		case !quoted && ch == sep && (n < 0 || len(parts) < n-1):
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes of an identifier, which may consist of both quoted and unquoted parts.
+func unquote(s string) string {
+	var buf bytes.Buffer
+	quoted, escaped := false, false
+	for _, ch := range s {
+		switch {
+		case escaped:
+			buf.WriteRune(ch)
+			escaped = false
+		case quoted && ch == '\\':
+			escaped = true
+		case ch == '"':
+			quoted = !quoted
+		default:
+			buf.WriteRune(ch)
+		}
+	}
+	return buf.String()
+}
+
 type Params []string
 type Handler func(Params) Statement
 
@@ -51,6 +51,15 @@ func (p *Parser) Parse() (Statement, error) {
 			}
 			break
 		}
+		if _, ok := tree.Children[LIST]; ok && tok == STR {
+			list, err := p.scanList(lit)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, list)
+			tree = tree.Children[LIST]
+			continue
+		}
 		if _, ok := tree.Children[tok]; !ok {
 			return nil, fmt.Errorf("found %q, expected %s", lit, tokensToString(tree.Children))
 		}
@@ -62,6 +71,24 @@ func (p *Parser) Parse() (Statement, error) {
 	return nil, nil
 }
 
+// scanList reads the parameters that follow the first one when separated by commas. The parameters
+// are returned as they were written, separated by commas, which can be split with splitTags.
+func (p *Parser) scanList(first string) (string, error) {
+	list := []string{first}
+	for {
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok != COMMA {
+			p.unscan()
+			return strings.Join(list, ","), nil
+		}
+		tok, lit = p.scanIgnoreWhitespace()
+		if tok != STR {
+			return "", fmt.Errorf("found %q, expected %s", lit, STR.Repr())
+		}
+		list = append(list, lit)
+	}
+}
+
 // scan returns the next token from the underlying scanner.
 // If a token has been unscanned then read that instead.
 func (p *Parser) scan() (tok Token, lit string) {
@@ -22,4 +22,58 @@ func TestParserErrorOnMissingParameter(t *testing.T) {
 	stmt, err := NewParser(strings.NewReader(`create partition key on consumption`), lang).Parse()
 	assert.Nil(t, stmt)
 	assert.Equal(t, "unexpected end of statement, expecting WITH", err.Error())
-}
\ No newline at end of file
+}
+func TestParser_ParseReplicationFactor(t *testing.T) {
+	lang := CreateLanguage()
+
+	stmt, err := NewParser(strings.NewReader(`SET REPLICATION FACTOR 3 ON mydb.cpu`), lang).Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, SetReplicationFactorStatement{"mydb", "cpu", 3}, stmt)
+
+	stmt, err = NewParser(strings.NewReader(`set replication factor 1`), lang).Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, SetReplicationFactorStatement{Value: 1}, stmt)
+
+	stmt, err = NewParser(strings.NewReader(`SHOW REPLICATION FACTORS ON mydb`), lang).Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, ShowReplicationFactorsStatement{Database: "mydb"}, stmt)
+
+	_, err = NewParser(strings.NewReader(`SET REPLICATION FACTOR ON mydb`), lang).Parse()
+	assert.Error(t, err)
+}
+
+func TestParser_ParseUpdatePartitionKey(t *testing.T) {
+	lang := CreateLanguage()
+
+	stmt, err := NewParser(strings.NewReader(`UPDATE PARTITION KEY type.captain ON sharded.treasures`), lang).Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, UpdatePartitionKeyStatement{"sharded", "treasures", []string{"type", "captain"}}, stmt)
+
+	stmt, err = NewParser(strings.NewReader(`update partition key type on sharded`), lang).Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, UpdatePartitionKeyStatement{"sharded", "", []string{"type"}}, stmt)
+}
+
+func TestParser_ParseCreatePartitionKey(t *testing.T) {
+	lang := CreateLanguage()
+
+	stmt, err := NewParser(strings.NewReader(`CREATE PARTITION KEY meter_id, region ON mydb.mymeasurement`), lang).Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, CreatePartitionKeyStatement{"mydb", "mymeasurement", []string{"meter_id", "region"}}, stmt)
+
+	stmt, err = NewParser(strings.NewReader(`CREATE PARTITION KEY ON "my db"."cpu.load" WITH "host name","data.center"`), lang).Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, CreatePartitionKeyStatement{"my db", "cpu.load", []string{"host name", "data.center"}}, stmt)
+
+	// Tags that are not quoted are split at dots as well.
+	stmt, err = NewParser(strings.NewReader(`CREATE PARTITION KEY type.captain ON sharded`), lang).Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, CreatePartitionKeyStatement{"sharded", "", []string{"type", "captain"}}, stmt)
+
+	stmt, err = NewParser(strings.NewReader(`EXPLAIN CREATE PARTITION KEY "on" ON mydb`), lang).Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, ExplainCreatePartitionKeyStatement{CreatePartitionKeyStatement{"mydb", "", []string{"on"}}}, stmt)
+
+	_, err = NewParser(strings.NewReader(`CREATE PARTITION KEY meter_id, ON mydb`), lang).Parse()
+	assert.EqualError(t, err, `found "ON", expected string parameter`)
+}
@@ -38,6 +38,8 @@ const (
 	DROP
 	CREATE
 	REMOVE
+	UPDATE
+	EXPLAIN
 
 	PARTITION
 	KEY
@@ -46,6 +48,7 @@ const (
 	WITH
 	REPLICATION
 	FACTOR
+	FACTORS
 	NODE
 	NODES
 )
@@ -71,7 +74,7 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 	if isWhitespace(ch) {
 		s.unread()
 		return s.scanWhitespace()
-	} else if isLetter(ch) {
+	} else if isLetter(ch) || isDigit(ch) {
 		s.unread()
 		return s.scanIdent()
 	}
@@ -113,17 +116,27 @@ func (s *Scanner) scanWhitespace() (tok Token, lit string) {
 	return WS, buf.String()
 }
 
-// scanIdent consumes the current rune and all contiguous ident runes.
+// scanIdent consumes the current rune and all contiguous ident runes. Parts of the ident may be quoted,
+// in which case they can contain any character, and the quotes are kept in the literal.
 func (s *Scanner) scanIdent() (tok Token, lit string) {
-	// Create a buffer and read the current character into it.
 	var buf bytes.Buffer
-	buf.WriteRune(s.read())
 
 	// Read every subsequent ident character into the buffer.
 	// Non-ident characters and EOF will cause the loop to exit.
+	quoted := false
 	for {
 		if ch := s.read(); ch == eof {
 			break
+		} else if ch == '"' {
+			quoted = !quoted
+			_, _ = buf.WriteRune(ch)
+		} else if quoted {
+			_, _ = buf.WriteRune(ch)
+			if ch == '\\' {
+				if next := s.read(); next != eof {
+					_, _ = buf.WriteRune(next)
+				}
+			}
 		} else if !isLetter(ch) && !isDigit(ch) && ch != '_' && ch != '.' && ch != '-' {
 			s.unread()
 			break
@@ -160,8 +173,14 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 		return REPLICATION, buf.String()
 	case "FACTOR":
 		return FACTOR, buf.String()
+	case "FACTORS":
+		return FACTORS, buf.String()
 	case "REMOVE":
 		return REMOVE, buf.String()
+	case "EXPLAIN":
+		return EXPLAIN, buf.String()
+	case "UPDATE":
+		return UPDATE, buf.String()
 	case "NODE":
 		return NODE, buf.String()
 	case "NODES":
@@ -171,12 +190,8 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 	str := buf.String()
 	if isInt(str) {
 		return NUM, str
-	} else if isString(str) {
-		return STR, strings.Trim(str, "\"")
 	}
-
-	// Otherwise return as a regular identifier.
-	return IDENT, strings.Trim(buf.String(), "\"")
+	return STR, str
 }
 
 // read reads the next rune from the buffered reader.
@@ -206,13 +221,6 @@ func isInt(s string) bool {
 	return err == nil
 }
 
-func isString(s string) bool {
-	if strings.HasPrefix(s, "\"") && strings.HasSuffix(s, "\"") {
-		return true
-	}
-	return true
-}
-
 // eof represents a marker rune for the end of the reader.
 var eof = rune(0)
 
@@ -242,8 +250,14 @@ func (t Token) Repr() string {
 		return "REPLICATION"
 	case FACTOR:
 		return "FACTOR"
+	case FACTORS:
+		return "FACTORS"
 	case REMOVE:
 		return "REMOVE"
+	case UPDATE:
+		return "UPDATE"
+	case EXPLAIN:
+		return "EXPLAIN"
 	case ON:
 		return "ON"
 	case STR:
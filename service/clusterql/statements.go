@@ -20,6 +20,16 @@ type CreatePartitionKeyStatement struct {
 	Tags        []string
 }
 
+type ExplainCreatePartitionKeyStatement struct {
+	CreatePartitionKeyStatement
+}
+
+type UpdatePartitionKeyStatement struct {
+	Database    string
+	Measurement string
+	Tags        []string
+}
+
 type ShowReplicationFactorsStatement struct {
 	Database    string
 	Measurement string
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"regexp/syntax"
+	"sort"
+
+	"github.com/influxdata/influxql"
+)
+
+// maxRegexValues limits how many values a regular expression is expanded to. Expressions that
+// match more values than this are treated as if they could match anything.
+const maxRegexValues = 100
+
+// getTagValues returns the values that each tag must have for a point to match the conditions of
+// the statement and its subqueries. Tags that are missing may have any value.
+func getTagValues(stmt *influxql.SelectStatement) map[string][]string {
+	values := map[string][]string{}
+	for _, condition := range findConditions(stmt) {
+		values = intersectTagValues(values, findTagValues(condition))
+	}
+	return values
+}
+
+// findTagValues finds the values of tags that are required by the condition. Conditions that
+// can not be analyzed do not constrain any tag, which makes the query go to all partitions.
+func findTagValues(cond influxql.Expr) map[string][]string {
+	switch expr := cond.(type) {
+	case *influxql.ParenExpr:
+		return findTagValues(expr.Expr)
+	case *influxql.BinaryExpr:
+		switch expr.Op {
+		case influxql.AND:
+			return intersectTagValues(findTagValues(expr.LHS), findTagValues(expr.RHS))
+		case influxql.OR:
+			return unionTagValues(findTagValues(expr.LHS), findTagValues(expr.RHS))
+		case influxql.EQ:
+			if key, value, ok := tagComparison(expr); ok {
+				if s, ok := stringValue(value); ok {
+					return map[string][]string{key: {s}}
+				}
+			}
+		case influxql.EQREGEX:
+			if key, value, ok := tagComparison(expr); ok {
+				if re, ok := value.(*influxql.RegexLiteral); ok {
+					if values, ok := regexValues(re.Val.String()); ok {
+						return map[string][]string{key: values}
+					}
+				}
+			}
+		}
+	}
+	return map[string][]string{}
+}
+
+// tagComparison returns the tag and the value that it is compared with, regardless of which side of
+// the operator they are on.
+func tagComparison(expr *influxql.BinaryExpr) (string, influxql.Expr, bool) {
+	// A double quoted value is parsed as a reference, so tag = "value" compares with a string.
+	if ref, ok := expr.LHS.(*influxql.VarRef); ok {
+		return ref.Val, expr.RHS, true
+	}
+	if ref, ok := expr.RHS.(*influxql.VarRef); ok {
+		switch expr.LHS.(type) {
+		case *influxql.StringLiteral, *influxql.RegexLiteral:
+			return ref.Val, expr.LHS, true
+		}
+	}
+	return "", nil, false
+}
+
+func stringValue(expr influxql.Expr) (string, bool) {
+	switch v := expr.(type) {
+	case *influxql.StringLiteral:
+		return v.Val, true
+	case *influxql.VarRef:
+		return v.Val, true
+	}
+	return "", false
+}
+
+// intersectTagValues combines the values of conditions that must all be true. A tag that is only
+// constrained by one of them keeps its values.
+func intersectTagValues(a, b map[string][]string) map[string][]string {
+	result := map[string][]string{}
+	for key, values := range a {
+		result[key] = values
+	}
+	for key, values := range b {
+		existing, ok := result[key]
+		if !ok {
+			result[key] = values
+			continue
+		}
+		in := map[string]bool{}
+		for _, v := range existing {
+			in[v] = true
+		}
+		common := []string{}
+		for _, v := range values {
+			if in[v] {
+				common = append(common, v)
+			}
+		}
+		result[key] = common
+	}
+	return result
+}
+
+// unionTagValues combines the values of conditions where either may be true. A tag is only
+// constrained if both conditions constrain it.
+func unionTagValues(a, b map[string][]string) map[string][]string {
+	result := map[string][]string{}
+	for key, values := range a {
+		other, ok := b[key]
+		if !ok {
+			continue
+		}
+		result[key] = uniqueStrings(append(append([]string{}, values...), other...))
+	}
+	return result
+}
+
+func uniqueStrings(values []string) []string {
+	sort.Strings(values)
+	unique := values[:0]
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// regexValues returns all strings that the pattern matches if it is anchored at both ends and only
+// matches a few literal strings, such as /^(a|b|c)$/ which is generated for template variables.
+func regexValues(pattern string) ([]string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 {
+		return nil, false
+	}
+	first, last := re.Sub[0], re.Sub[len(re.Sub)-1]
+	if !isBeginAnchor(first) || !isEndAnchor(last) {
+		return nil, false
+	}
+	values, ok := expandRegex(&syntax.Regexp{Op: syntax.OpConcat, Sub: re.Sub[1 : len(re.Sub)-1]})
+	if !ok {
+		return nil, false
+	}
+	return uniqueStrings(values), true
+}
+
+func isBeginAnchor(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpBeginText || (re.Op == syntax.OpBeginLine && re.Flags&syntax.OneLine != 0)
+}
+
+func isEndAnchor(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpEndText || (re.Op == syntax.OpEndLine && re.Flags&syntax.OneLine != 0)
+}
+
+// expandRegex enumerates the strings matched by a regular expression without repetitions.
+func expandRegex(re *syntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return []string{""}, true
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(re.Rune)}, true
+	case syntax.OpCharClass:
+		values := []string{}
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				if len(values) == maxRegexValues {
+					return nil, false
+				}
+				values = append(values, string(r))
+			}
+		}
+		return values, true
+	case syntax.OpCapture:
+		return expandRegex(re.Sub[0])
+	case syntax.OpQuest:
+		values, ok := expandRegex(re.Sub[0])
+		return append(values, ""), ok && len(values) < maxRegexValues
+	case syntax.OpAlternate:
+		values := []string{}
+		for _, sub := range re.Sub {
+			alternatives, ok := expandRegex(sub)
+			if !ok || len(values)+len(alternatives) > maxRegexValues {
+				return nil, false
+			}
+			values = append(values, alternatives...)
+		}
+		return values, true
+	case syntax.OpConcat:
+		values := []string{""}
+		for _, sub := range re.Sub {
+			suffixes, ok := expandRegex(sub)
+			if !ok || len(values)*len(suffixes) > maxRegexValues {
+				return nil, false
+			}
+			combined := make([]string, 0, len(values)*len(suffixes))
+			for _, prefix := range values {
+				for _, suffix := range suffixes {
+					combined = append(combined, prefix+suffix)
+				}
+			}
+			values = combined
+		}
+		return values, true
+	}
+	return nil, false
+}
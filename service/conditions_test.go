@@ -0,0 +1,58 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTagValues(t *testing.T) {
+	tests := []struct {
+		cond     string
+		expected map[string][]string
+	}{
+		{`type = 'gold'`, map[string][]string{"type": {"gold"}}},
+		{`'gold' = type`, map[string][]string{"type": {"gold"}}},
+		{`type = "gold"`, map[string][]string{"type": {"gold"}}},
+		{`type = 'gold' OR type = 'silver'`, map[string][]string{"type": {"gold", "silver"}}},
+		{`(type = 'gold' OR type = 'silver') AND time > now() - 1h`, map[string][]string{"type": {"gold", "silver"}}},
+		{`type = 'gold' OR value > 1`, map[string][]string{}},
+		{`type = 'gold' AND type = 'silver'`, map[string][]string{"type": {}}},
+		{`(type = 'gold' OR type = 'silver') AND type = 'gold'`, map[string][]string{"type": {"gold"}}},
+		{`type = 'gold' AND place = 'cave' OR type = 'silver'`, map[string][]string{"type": {"gold", "silver"}}},
+		{`type != 'gold'`, map[string][]string{}},
+		{`type > 'gold'`, map[string][]string{}},
+		{`type = 1`, map[string][]string{}},
+		{`1 = 1`, map[string][]string{}},
+		{`type =~ /^(gold|silver)$/`, map[string][]string{"type": {"gold", "silver"}}},
+		{`type =~ /^gold$/`, map[string][]string{"type": {"gold"}}},
+		{`type =~ /^(gold|silver|bronze)$/ AND type = 'gold'`, map[string][]string{"type": {"gold"}}},
+		{`type =~ /^type-[ab]$/`, map[string][]string{"type": {"type-a", "type-b"}}},
+		{`type =~ /^gold/`, map[string][]string{}},
+		{`type =~ /^gold.*$/`, map[string][]string{}},
+		{`type =~ /^(?i)gold$/`, map[string][]string{}},
+		{`type !~ /^gold$/`, map[string][]string{}},
+	}
+	for _, test := range tests {
+		stmt := mustGetSelect("SELECT * FROM treasures WHERE " + test.cond)
+		assert.Equal(t, test.expected, getTagValues(stmt), test.cond)
+	}
+}
+
+func TestGetTagValues_SubQuery(t *testing.T) {
+	stmt := mustGetSelect(`SELECT mean(value) FROM (SELECT value FROM treasures WHERE type =~ /^(gold|silver)$/) WHERE type = 'gold'`)
+	assert.Equal(t, map[string][]string{"type": {"gold"}}, getTagValues(stmt))
+
+	stmt = mustGetSelect(`SELECT value FROM treasures`)
+	assert.Equal(t, map[string][]string{}, getTagValues(stmt))
+}
+
+func TestCoordinator_ResolveHashesWithRegex(t *testing.T) {
+	partitioner := newPartitioner()
+	c := NewCoordinator(nil, partitioner)
+	key, _ := partitioner.GetKeyByMeasurement(testDB, "treasures")
+
+	stmt := mustGetSelect(`SELECT value FROM treasures WHERE type =~ /^(gold|silver)$/`)
+	hashes, _ := c.resolveHashes(stmt, testDB)
+	assert.ElementsMatch(t, partitioner.GetHashes(key, map[string][]string{"type": {"gold", "silver"}}), hashes)
+}
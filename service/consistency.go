@@ -0,0 +1,142 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxdb/models"
+)
+
+// ConsistencyLevel is the number of replicas that have to acknowledge a write before it is successful.
+type ConsistencyLevel int
+
+const (
+	// ConsistencyAny requires one replica to write the points or to save them for recovery.
+	ConsistencyAny ConsistencyLevel = iota
+	// ConsistencyOne requires one replica to write the points.
+	ConsistencyOne
+	// ConsistencyQuorum requires a majority of the replicas to write the points.
+	ConsistencyQuorum
+	// ConsistencyAll requires all replicas to write the points.
+	ConsistencyAll
+)
+
+const DefaultConsistencyLevel = ConsistencyOne
+
+func ParseConsistencyLevel(level string) (ConsistencyLevel, error) {
+	switch strings.ToLower(level) {
+	case "any":
+		return ConsistencyAny, nil
+	case "one":
+		return ConsistencyOne, nil
+	case "quorum":
+		return ConsistencyQuorum, nil
+	case "all":
+		return ConsistencyAll, nil
+	}
+	return 0, fmt.Errorf("invalid consistency level: %s", level)
+}
+
+func (l ConsistencyLevel) String() string {
+	switch l {
+	case ConsistencyAny:
+		return "any"
+	case ConsistencyOne:
+		return "one"
+	case ConsistencyQuorum:
+		return "quorum"
+	case ConsistencyAll:
+		return "all"
+	}
+	return "unknown"
+}
+
+// satisfied returns true if enough of the replicas have written the points or saved them for recovery.
+func (l ConsistencyLevel) satisfied(acks, hints, replicas int) bool {
+	switch l {
+	case ConsistencyAny:
+		return acks+hints >= 1
+	case ConsistencyOne:
+		return acks >= 1
+	case ConsistencyQuorum:
+		return acks >= replicas/2+1
+	}
+	return acks >= replicas
+}
+
+// DefaultConsistency is the consistency level of writes that do not specify one. It can be changed
+// while the cluster is running.
+type DefaultConsistency struct {
+	mu    sync.RWMutex
+	level ConsistencyLevel
+}
+
+func NewDefaultConsistency(level ConsistencyLevel) *DefaultConsistency {
+	return &DefaultConsistency{level: level}
+}
+
+func (c *DefaultConsistency) Get() ConsistencyLevel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.level
+}
+
+func (c *DefaultConsistency) Set(level ConsistencyLevel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.level = level
+}
+
+// hintedWriteError is returned when points could not be written to a node but were saved to be
+// written when it recovers.
+type hintedWriteError struct {
+	error
+}
+
+// rejectedWriteError is returned when a node rejects the points, such as when the database does not
+// exist or a field has another type. Writing the same points again would fail the same way.
+type rejectedWriteError struct {
+	error
+}
+
+// writeWithConsistency writes the points to all replicas in parallel and returns as soon as the consistency
+// level is satisfied. Writes to the remaining replicas continue in the background.
+func writeWithConsistency(writer PointsWriter, points []models.Point, locations []*cluster.Node, wc WriteContext, level ConsistencyLevel) error {
+	if len(locations) == 0 {
+		return errors.New("there are no nodes available to write to")
+	}
+	results := make(chan error, len(locations))
+	for _, node := range locations {
+		go func(node *cluster.Node) {
+			results <- writer.WritePoints(points, []*cluster.Node{node}, wc)
+		}(node)
+	}
+	acks, hints, rejections := 0, 0, 0
+	var lastErr error
+	for range locations {
+		err := <-results
+		switch err.(type) {
+		case nil:
+			acks++
+		case hintedWriteError:
+			hints++
+			lastErr = err
+		case rejectedWriteError:
+			rejections++
+			lastErr = err
+		default:
+			lastErr = err
+		}
+		if level.satisfied(acks, hints, len(locations)) {
+			return nil
+		}
+	}
+	err := fmt.Errorf("consistency level %s not met, %d of %d replicas wrote the points: %s", level, acks, len(locations), lastErr)
+	if rejections == len(locations) {
+		return rejectedWriteError{err}
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxdb/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// nodeFailingPointsWriter fails to write to some of the nodes.
+type nodeFailingPointsWriter struct {
+	errs map[string]error
+}
+
+func (w *nodeFailingPointsWriter) WritePoints(points []models.Point, locations []*cluster.Node, writeContext WriteContext) error {
+	for _, node := range locations {
+		if err, ok := w.errs[node.Name]; ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestParseConsistencyLevel(t *testing.T) {
+	for _, level := range []ConsistencyLevel{ConsistencyAny, ConsistencyOne, ConsistencyQuorum, ConsistencyAll} {
+		parsed, err := ParseConsistencyLevel(strings.ToUpper(level.String()))
+		assert.NoError(t, err)
+		assert.Equal(t, level, parsed)
+	}
+	_, err := ParseConsistencyLevel("some")
+	assert.Error(t, err)
+}
+
+func TestWriteHandler_Consistency(t *testing.T) {
+	tests := []struct {
+		consistency string
+		errs        map[string]error
+		code        int
+	}{
+		{"", map[string]error{"influx-1": errors.New("failed")}, 204},
+		{"all", map[string]error{"influx-1": errors.New("failed")}, 500},
+		{"quorum", map[string]error{"influx-1": errors.New("failed")}, 500},
+		{"quorum", map[string]error{}, 204},
+		{"one", map[string]error{"influx-1": errors.New("failed")}, 204},
+		{"one", map[string]error{"influx-1": hintedWriteError{errors.New("failed")}, "influx-2": hintedWriteError{errors.New("failed")}}, 500},
+		{"any", map[string]error{"influx-1": hintedWriteError{errors.New("failed")}, "influx-2": hintedWriteError{errors.New("failed")}}, 204},
+		{"any", map[string]error{"influx-1": errors.New("failed"), "influx-2": errors.New("failed")}, 500},
+		{"none", map[string]error{}, 400},
+	}
+	for _, test := range tests {
+		handler, resolver, _, _ := newTestWriteHandler(&nodeFailingPointsWriter{test.errs})
+		resolver.ReplicationFactor = 2
+
+		writeUrl := fmt.Sprintf("http://localhost/write?db=%s&consistency=%s", testDB, test.consistency)
+		req := httptest.NewRequest("POST", writeUrl, strings.NewReader("treasures,type=gold value=29 1439856000"))
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, "consistency %s with %d failing nodes", test.consistency, len(test.errs))
+	}
+}
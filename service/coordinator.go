@@ -1,10 +1,14 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/adamringhede/influxdb-ha/cluster"
@@ -25,6 +29,52 @@ type Coordinator struct {
 	resolver *cluster.Resolver
 	//partitionKeys map[string]cluster.PartitionKey
 	partitioner cluster.Partitioner
+	// timeout is the deadline for the entire query, including all requests to nodes.
+	timeout time.Duration
+	// concurrency is the maximum number of nodes that are requested at the same time.
+	concurrency int
+	// hedgeDelay is how long to wait for a replica before the next one is requested as well.
+	hedgeDelay time.Duration
+	fieldKeys  *FieldKeyCache
+}
+
+const (
+	DefaultQueryTimeout     = 60 * time.Second
+	DefaultQueryConcurrency = 16
+	DefaultQueryHedgeDelay  = 500 * time.Millisecond
+)
+
+// MaxRawPoints is the maximum number of points that are fetched from the nodes for functions that can not
+// be merged from partial aggregates, such as median and percentile.
+var MaxRawPoints = 1000000
+
+// QueryOptions configure how queries are sent to the data nodes. Fields that are not set use the defaults.
+type QueryOptions struct {
+	Timeout     time.Duration
+	Concurrency int
+	HedgeDelay  time.Duration
+}
+
+func (o QueryOptions) withDefaults() QueryOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultQueryTimeout
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = DefaultQueryConcurrency
+	}
+	if o.HedgeDelay <= 0 {
+		o.HedgeDelay = DefaultQueryHedgeDelay
+	}
+	return o
+}
+
+func NewCoordinator(resolver *cluster.Resolver, partitioner cluster.Partitioner) *Coordinator {
+	return NewCoordinatorWithOptions(resolver, partitioner, QueryOptions{})
+}
+
+func NewCoordinatorWithOptions(resolver *cluster.Resolver, partitioner cluster.Partitioner, options QueryOptions) *Coordinator {
+	options = options.withDefaults()
+	return &Coordinator{resolver, partitioner, options.Timeout, options.Concurrency, options.HedgeDelay, NewFieldKeyCache()}
 }
 
 type compareLess func(a, b interface{}) bool
@@ -47,35 +97,73 @@ func lessFloat(a, b interface{}) bool {
 
 func groupResultsByTags(allResults [][]Result) map[string][]Result {
 	groupedResults := map[string][]Result{}
-	// Group allResults by combination of tags
+	// Group allResults by combination of tags. Every series is put in a result of its own
+	// as a single response may contain series for several groups.
 	for _, results := range allResults {
 		for _, res := range results {
-			// Don't include empty results
-			if len(res.Series) == 0 || len(res.Series[0].Values) == 0 {
-				continue
-			}
-			tagsKey := ""
-			if len(res.Series) > 0 {
-				for key, value := range res.Series[0].Tags {
-					tagsKey += key + "=" + value + ","
+			for _, series := range res.Series {
+				// Don't include empty results
+				if len(series.Values) == 0 {
+					continue
 				}
+				tagsKey := seriesKey(series)
+				groupedResults[tagsKey] = append(groupedResults[tagsKey], Result{StatementID: res.StatementID, Series: []*models.Row{series}})
 			}
-			if _, ok := groupedResults[tagsKey]; !ok {
-				groupedResults[tagsKey] = []Result{}
-			}
-			groupedResults[tagsKey] = append(groupedResults[tagsKey], res)
 		}
 	}
 	return groupedResults
 }
 
+// seriesKey creates a key from the name and tags of a series that is
+// the same regardless of the order in which tags were decoded.
+func seriesKey(series *models.Row) string {
+	keys := make([]string, 0, len(series.Tags))
+	for key := range series.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(series.Name)
+	for _, key := range keys {
+		b.WriteString("," + key + "=" + series.Tags[key])
+	}
+	return b.String()
+}
+
+// sortedGroupKeys returns the keys of grouped results in the order that series should be returned.
+func sortedGroupKeys(groupedResults map[string][]Result) []string {
+	keys := make([]string, 0, len(groupedResults))
+	for key := range groupedResults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// combineResults puts all series of the given results in a single result
+// as they originate from the same statement.
+func combineResults(results []Result) []Result {
+	if len(results) == 0 {
+		return results
+	}
+	combined := Result{StatementID: results[0].StatementID}
+	for _, res := range results {
+		combined.Series = append(combined.Series, res.Series...)
+		combined.Messages = append(combined.Messages, res.Messages...)
+	}
+	return []Result{combined}
+}
+
 // mergeSortResults assuems that there is only one series
 func mergeSortResults(groupedResults map[string][]Result, less compareLess) []Result {
 	mergedResults := []Result{}
-	for _, group := range groupedResults {
+	for _, key := range sortedGroupKeys(groupedResults) {
+		group := groupedResults[key]
 		uniquePoints := make(map[string]bool, sumResultCounts(group))
 		merged := Result{}
 		merged.Series = []*models.Row{{
+			Name:    group[0].Series[0].Name,
+			Tags:    group[0].Series[0].Tags,
 			Columns: group[0].Series[0].Columns,
 			Values:  [][]interface{}{}, // TODO preallocate memory for the values
 		}}
@@ -126,11 +214,30 @@ func sumResultCounts(results []Result) int {
 }
 
 func mergeQueryResults(groupedResults map[string][]Result, tree *merge.QueryTree) []Result {
+	mergedResults, _ := mergeQueryResultsWithRaw(groupedResults, nil, timeWindow{}, tree)
+	return mergedResults
+}
+
+// mergeQueryResultsWithRaw merges the results like mergeQueryResults, and also makes the points of
+// the raw results in the same group available to the functions that need them.
+func mergeQueryResultsWithRaw(groupedResults map[string][]Result, rawResults map[string][]Result, window timeWindow, tree *merge.QueryTree) ([]Result, error) {
 	mergedResults := []Result{}
-	for _, group := range groupedResults {
+	for _, key := range sortedGroupKeys(groupedResults) {
+		group := groupedResults[key]
 		src := NewResultSource(group)
+		src.epoch = window.epoch
+		if err := src.AddRaw(rawResults[key], window); err != nil {
+			return nil, err
+		}
+		if tree.HasTransformations() {
+			// Points from different nodes are not in order when they have not been aggregated.
+			src.sortByTime()
+		}
+		tree.Reset()
 		merged := Result{}
 		merged.Series = []*models.Row{{
+			Name:    group[0].Series[0].Name,
+			Tags:    group[0].Series[0].Tags,
 			Columns: []string{"time"}, // TODO make sure to add the columns from the results and that they are in the same order
 			Values:  [][]interface{}{},
 		}}
@@ -140,11 +247,6 @@ func mergeQueryResults(groupedResults map[string][]Result, tree *merge.QueryTree
 		for src.Reset(); !src.Done(); src.Step() {
 			value := []interface{}{src.Time()}
 			for _, f := range tree.Fields {
-				switch f.Root.(type) {
-				case *merge.Top, *merge.Bottom:
-					// TODO Add support for adding multiple values. Note that in 1.3 is is no longe possible to use
-					// both aggregations function and top/bottom which makes this easier.
-				}
 				calculatedValues := f.Root.Next(src)
 				if len(calculatedValues) > 0 {
 					value = append(value, calculatedValues[0])
@@ -153,11 +255,25 @@ func mergeQueryResults(groupedResults map[string][]Result, tree *merge.QueryTree
 				}
 
 			}
+			if tree.HasTransformations() && allNil(value[1:]) {
+				// Like in InfluxDB, transformations do not return anything for
+				// rows without a previous value.
+				continue
+			}
 			merged.Series[0].Values = append(merged.Series[0].Values, value)
 		}
 		mergedResults = append(mergedResults, merged)
 	}
-	return mergedResults
+	return mergedResults, nil
+}
+
+func allNil(values []interface{}) bool {
+	for _, v := range values {
+		if v != nil {
+			return false
+		}
+	}
+	return true
 }
 
 func hasCall(stmt *influxql.SelectStatement) bool {
@@ -169,196 +285,438 @@ func hasCall(stmt *influxql.SelectStatement) bool {
 	return false
 }
 
-func (c *Coordinator) Handle(stmt *influxql.SelectStatement, r *http.Request, db string) ([]Result, error, *http.Response) {
-	client := &http.Client{Timeout: 10 * time.Second}
+// lessForRequest returns the function for comparing times in the format requested with the epoch parameter.
+func lessForRequest(r *http.Request) compareLess {
+	precision := r.URL.Query().Get("epoch")
+	if precision == "" || strings.ToUpper(precision) == "RFC3339" {
+		return lessRfc
+	}
+	return lessFloat
+}
+
+// readScope returns the replication scope of the measurement that the statement selects from, which decides
+// the nodes that have its data. Without a single measurement, nodes are only used if they have all data of the database.
+func readScope(stmt *influxql.SelectStatement, db string) cluster.ReplicationScope {
+	measurements := findMeasurements(stmt.Sources)
+	if len(measurements) != 1 || measurements[0].Regex != nil {
+		return cluster.ReplicationScope{Database: db}
+	}
+	if measurements[0].Database != "" {
+		db = measurements[0].Database
+	}
+	return cluster.ReplicationScope{Database: db, Measurement: measurements[0].Name}
+}
 
+// resolveHashes returns the hashes of the partitions that have to be queried for the statement. If the measurement
+// is not partitioned, the hash of the database is returned together with an empty partition key.
+func (c *Coordinator) resolveHashes(stmt *influxql.SelectStatement, db string) ([]int, cluster.PartitionKey) {
 	measurements := findMeasurements(stmt.Sources)
-	// First assumption: one measurement, one query
+	// Statements with several measurements are split in Handle, so there is only one at this point.
 	msmt := measurements[0]
 	if msmt.Database == "" {
 		msmt.Database = db
 	}
-	var locations []string
 	pKey, ok := c.partitioner.GetKeyByMeasurement(msmt.Database, msmt.Name)
-	if ok {
-		tagValues := getTagValues(stmt)
-		if !c.partitioner.FulfillsKey(pKey, tagValues) {
-			/*
-				TODO
-				If the key is not fulfilled, ideally n / rf nodes need to be queried as the data
-				is partitioned and the query will need to reach all servers.
-				However, finding those as well as the replicas if one fails is trickier.
-			*/
-			return []Result{}, fmt.Errorf("the partition key is not fulfilled given the tags in the query"), nil
+	if !ok {
+		// Resolve based on database name
+		key := hash.String(cluster.CreatePartitionKeyIdentifier(db, ""))
+		return []int{int(key)}, pKey
+	}
+
+	tagValues := getTagValues(stmt)
+	if c.partitioner.FulfillsKey(pKey, tagValues) {
+		return c.partitioner.GetHashes(pKey, tagValues), pKey
+	}
+	// The data may be on any partition if the key is not fulfilled. Resolving the first
+	// token of every range reaches at least one replica of all data in the cluster.
+	return c.resolver.FindAllTokens(), pKey
+}
+
+func (c *Coordinator) Handle(stmt *influxql.SelectStatement, r *http.Request, db string) ([]Result, error, *http.Response) {
+	// The requests end at the deadline of the query rather than at a timeout of their own.
+	client := cluster.NewDataClient(0)
+	ctx, cancel := context.WithTimeout(r.Context(), c.timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	if sub, ok := subQuery(stmt); ok {
+		return c.handleSubQuery(stmt, sub, r, db, client)
+	}
+	if hasMultipleSources(stmt) {
+		return c.handleSources(stmt, r, db, client)
+	}
+
+	hashes, pKey := c.resolveHashes(stmt, db)
+	scope := readScope(stmt, db)
+	switch len(hashes) {
+	case 0:
+		return []Result{}, fmt.Errorf("there are no nodes available to handle the query"), nil
+	case 1:
+		// Request single nodes
+		locations := c.resolver.FindByKey(hashes[0], scope.Database, scope.Measurement, cluster.READ)
+		return c.requestReplicas(stmt.String(), locations, client, r)
+	}
+
+	interval, err := stmt.GroupByInterval()
+	if err != nil {
+		return []Result{}, err, nil
+	}
+	// A selection with a call need to be handled differently as results from calls from different
+	// nodes need to be merged. However if there is no aggregation, it is enough to just merge
+	// the result and maintain sort.
+	if interval == 0 && !hasCall(stmt) {
+		allResults, _, err, response := c.performQuery(stmt.String(), r, hashes, scope, client)
+		if err != nil {
+			return []Result{}, err, nil
 		}
-		hashes := c.partitioner.GetHashes(pKey, tagValues)
+		groupedResults := groupResultsByTags(resultsOf(allResults))
+		mergedResults := mergeSortResults(groupedResults, lessForRequest(r))
+		return combineResults(mergedResults), nil, response
+	}
 
-		if len(hashes) > 1 {
-			interval, err := stmt.GroupByInterval()
-			if err != nil {
-				return []Result{}, err, nil
-			}
-			// A selection with a call need to be handled differently as results from calls from different
-			// nodes need to be merged. However if there is no aggregation, it is enough to just merge
-			// the result and maintain sort.
-			if interval == 0 && !hasCall(stmt) {
-				allResults, err, response := performQuery(stmt.String(), r, hashes, c.resolver, client)
-				if err != nil {
-					return []Result{}, err, nil
-				}
-				groupedResults := groupResultsByTags(allResults)
-				precision := r.URL.Query().Get("epoch")
-				var less compareLess
-				if precision == "" || strings.ToUpper(precision) == "RFC3339" {
-					less = lessRfc
-				} else {
-					less = lessFloat
-				}
-				mergedResults := mergeSortResults(groupedResults, less)
-				return mergedResults, nil, response
-			} else {
-				// Divide the query and merge the results
-				// TODO To support wildcards, the stmt needs to be changed to include all matching fields; that is, the field that includes a wildcard needs to be copied for all matching fields.
-				// TODO this requires finding all field names that exist on the selected measurement. For performance resasons, this should preferably be cached.
-				tree, qb, err := merge.NewQueryTree(stmt)
-				if err != nil {
-					return []Result{}, err, nil
-				}
-				s := qb.CreateStatement(stmt)
+	if call, column := selectorCall(stmt); call != nil {
+		return c.handleSelector(stmt, call, column, r, hashes, scope, pKey, client)
+	}
 
-				allResults, err, response := performQuery(s, r, hashes, c.resolver, client)
-				if err != nil {
-					return []Result{}, err, nil
-				}
+	// Divide the query and merge the results
+	if stmt.HasFieldWildcard() {
+		// Each field that is matched by a wildcard needs to be merged on its own.
+		stmt, err = c.expandFields(stmt, r, db, hashes, client)
+		if err != nil {
+			return []Result{}, err, nil
+		}
+	}
+	tree, qb, err := merge.NewQueryTree(stmt)
+	if err != nil {
+		return []Result{}, err, nil
+	}
+	// Replicas of a partition also hold data for neighbouring partitions, so results
+	// would be counted more than once if they were merged as is. Grouping by the
+	// partition key makes it possible to only use series from the node that was
+	// asked on behalf of the partition they belong to.
+	partitioned := withPartitionDimensions(stmt, pKey)
+	s := qb.CreateStatement(partitioned)
 
-				groupedResults := groupResultsByTags(allResults)
-				mergedResults := mergeQueryResults(groupedResults, tree)
-				return mergedResults, nil, response
-			}
-		} else {
-			// Request single nodes
-			locations = c.resolver.FindByKey(hashes[0], cluster.READ)
-			return requestMultipleLocations(stmt.String(), locations, client, r)
+	allResults, owners, err, response := c.performQuery(s, r, hashes, scope, client)
+	if err != nil {
+		return []Result{}, err, nil
+	}
+	groupedResults := groupResultsByTags(c.ownedResults(allResults, owners, pKey, stmt))
+
+	var rawResults map[string][]Result
+	if raw := qb.CreateRawStatement(partitioned, MaxRawPoints+1); raw != "" {
+		// Replicas may not be the same as for the first statement, which is fine
+		// as each series is only used from the node that owns it.
+		allRaw, rawOwners, err, _ := c.performQuery(raw, withoutEpoch(r), hashes, scope, client)
+		if err != nil {
+			return []Result{}, err, nil
 		}
-	} else {
-		// Resolve based on database name
-		key := hash.String(cluster.CreatePartitionKeyIdentifier(db, ""))
-		locations := c.resolver.FindByKey(int(key), cluster.READ)
-		return requestMultipleLocations(stmt.String(), locations, client, r)
+		if countPoints(allRaw) > MaxRawPoints {
+			return []Result{}, fmt.Errorf("more than %d points are needed to merge the functions of the query, "+
+				"use a shorter time range", MaxRawPoints), nil
+		}
+		rawResults = groupResultsByTags(c.ownedResults(allRaw, rawOwners, pKey, stmt))
+	}
+	window, err := newTimeWindow(stmt, r.URL.Query().Get("epoch"))
+	if err != nil {
+		return []Result{}, err, nil
+	}
+	mergedResults, err := mergeQueryResultsWithRaw(groupedResults, rawResults, window, tree)
+	if err != nil {
+		return []Result{}, err, nil
 	}
+	return combineResults(mergedResults), nil, response
 }
 
-func requestMultipleLocations(stmt string, locations []string, client *http.Client, r *http.Request) ([]Result, error, *http.Response) {
-	for _, location := range locations {
-		results, err, res := request(stmt, location, client, r)
-		if err == nil {
-			return results, err, res
+// withPartitionDimensions returns a copy of the statement that is also grouped by the tags in the partition key.
+func withPartitionDimensions(stmt *influxql.SelectStatement, pKey cluster.PartitionKey) *influxql.SelectStatement {
+	if stmt.HasDimensionWildcard() {
+		return stmt
+	}
+	grouped := tagDimensions(stmt)
+	clone := stmt.Clone()
+	for _, tag := range pKey.Tags {
+		if !grouped[tag] {
+			clone.Dimensions = append(clone.Dimensions, &influxql.Dimension{Expr: &influxql.VarRef{Val: tag}})
 		}
 	}
-	return []Result{}, nil, nil
+	return clone
 }
 
-func performQuery(stmt string, r *http.Request, hashes []int, resolver *cluster.Resolver, client *http.Client) ([][]Result, error, *http.Response) {
-	locationsAsked := map[string]bool{}
-	// TODO replace allResults with a channel and make requests in parallel.
-	allResults := [][]Result{}
-	var response *http.Response
-hashLoop:
-	for _, hash := range hashes {
-		// If none of the locations for a certain hash can respond, then no Result
-		// should be returned as it would be partial. This could be configured with an allowPartialResponses parameter.
-		var err error
-		locations := resolver.FindByKey(hash, cluster.READ)
-		for _, location := range locations {
-			// See if any node with data for this has has already been requested. If so, then this hash can be skipped.
-			if locationsAsked[location] {
-				continue hashLoop
-			}
+// tagDimensions returns the names of the tags that the statement is grouped by.
+func tagDimensions(stmt *influxql.SelectStatement) map[string]bool {
+	tags := map[string]bool{}
+	for _, dimension := range stmt.Dimensions {
+		if ref, ok := dimension.Expr.(*influxql.VarRef); ok {
+			tags[ref.Val] = true
 		}
-		// TODO Improve load balancing of nodes. Eg. prioritize based on certain attributes.
-		for _, location := range locations {
-			if !locationsAsked[location] {
-				results, qErr, res := request(stmt, location, client, r)
-				response = res
-				if qErr != nil {
-					err = qErr
-				} else {
-					locationsAsked[location] = true
-					allResults = append(allResults, results)
-					err = nil
-					break
+	}
+	return tags
+}
+
+// ownedResults filters out series that were returned by a node which was not asked on behalf of
+// the partition they belong to. Tags that were only added to group by the partition key are removed.
+func (c *Coordinator) ownedResults(allResults []nodeResults, owners map[int]string, pKey cluster.PartitionKey, stmt *influxql.SelectStatement) [][]Result {
+	grouped := tagDimensions(stmt)
+	wildcard := stmt.HasDimensionWildcard()
+	owned := [][]Result{}
+	for _, node := range allResults {
+		results := []Result{}
+		for _, res := range node.results {
+			filtered := Result{StatementID: res.StatementID, Messages: res.Messages}
+			for _, series := range res.Series {
+				if c.ownerOf(series.Tags, pKey, owners) != node.location {
+					continue
 				}
-			} else {
-				// If one node that has data for this hash has already been requested,
-				// then there is no point in trying any location, which is the reason for breaking.
-				break
+				row := *series
+				if !wildcard {
+					row.Tags = map[string]string{}
+					for key, value := range series.Tags {
+						if grouped[key] {
+							row.Tags[key] = value
+						}
+					}
+				}
+				filtered.Series = append(filtered.Series, &row)
 			}
+			results = append(results, filtered)
 		}
-		if err != nil {
-			return allResults, err, response
+		owned = append(owned, results)
+	}
+	return owned
+}
+
+// ownerOf returns the location that was asked for the partition that the given tags belong to.
+func (c *Coordinator) ownerOf(tags map[string]string, pKey cluster.PartitionKey, owners map[int]string) string {
+	values := map[string][]string{}
+	for _, tag := range pKey.Tags {
+		values[tag] = []string{tags[tag]}
+	}
+	hashes := c.partitioner.GetHashes(pKey, values)
+	if len(hashes) != 1 {
+		return ""
+	}
+	token, ok := c.resolver.FindTokenByKey(hashes[0])
+	if !ok {
+		return ""
+	}
+	return owners[token]
+}
+
+func (c *Coordinator) requestReplicas(stmt string, locations []string, client *http.Client, r *http.Request) ([]Result, error, *http.Response) {
+	if len(locations) == 0 {
+		return []Result{}, fmt.Errorf("there are no nodes available to handle the query"), nil
+	}
+	answer := c.askReplicas(stmt, locations, client, r)
+	return answer.results, answer.err, answer.response
+}
+
+// nodeResults holds the results from a single location.
+type nodeResults struct {
+	location string
+	results  []Result
+}
+
+func countPoints(allResults []nodeResults) int {
+	count := 0
+	for _, node := range allResults {
+		for _, res := range node.results {
+			for _, series := range res.Series {
+				count += len(series.Values)
+			}
 		}
 	}
-	return allResults, nil, response
+	return count
 }
 
-type tagFinder struct {
-	tags   map[string]bool
-	values map[string][]string // it could be interface to allow for numerical values as well
+func resultsOf(allResults []nodeResults) [][]Result {
+	results := make([][]Result, len(allResults))
+	for i, node := range allResults {
+		results[i] = node.results
+	}
+	return results
 }
 
-func newTagFinder() *tagFinder {
-	return &tagFinder{make(map[string]bool), make(map[string][]string)}
+// replicaTask is a request for the data in a token range, which can be answered by any of the locations.
+type replicaTask struct {
+	token     int
+	locations []string
 }
 
-func getTagValues(stmt *influxql.SelectStatement) map[string][]string {
-	finder := newTagFinder()
-	for _, condition := range findConditions(stmt) {
-		finder.findTags(condition)
+type replicaAnswer struct {
+	location string
+	results  []Result
+	err      error
+	response *http.Response
+	// failed are the locations that returned an error before one could answer.
+	failed []string
+}
+
+// performQuery requests one replica for each of the hashes, using the replicas of the measurements in the
+// scope. It returns the results from every
+// location that was asked, together with the location that was used for the token of each hash.
+// Requests are sent in parallel. A hash is not requested if a location that holds its data
+// is already being asked on behalf of another hash.
+func (c *Coordinator) performQuery(stmt string, r *http.Request, hashes []int, scope cluster.ReplicationScope, client *http.Client) ([]nodeResults, map[int]string, error, *http.Response) {
+	asked := map[string]bool{}
+	failed := map[string]bool{}
+	owners := map[int]string{}
+	allResults := []nodeResults{}
+	var response *http.Response
+	pending := hashes
+	// Hashes that share a replica with one which is being requested have to wait until it is known
+	// which replica answered, as it may not have the data for both if it was not the first one.
+	for len(pending) > 0 {
+		planned := map[string]bool{}
+		tasks := []replicaTask{}
+		deferred := []int{}
+	hashLoop:
+		for _, hash := range pending {
+			token, _ := c.resolver.FindTokenByKey(hash)
+			candidates := []string{}
+			for _, location := range c.resolver.FindByKey(hash, scope.Database, scope.Measurement, cluster.READ) {
+				// See if any node with data for this has has already been requested. If so, then this hash can be skipped.
+				if asked[location] {
+					owners[token] = location
+					continue hashLoop
+				}
+				if !failed[location] {
+					candidates = append(candidates, location)
+				}
+			}
+			// If none of the locations for a certain hash can respond, then no Result
+			// should be returned as it would be partial. This could be configured with an allowPartialResponses parameter.
+			if len(candidates) == 0 {
+				return allResults, owners, fmt.Errorf("no replica is available for token %d", token), response
+			}
+			for _, location := range candidates {
+				if planned[location] {
+					deferred = append(deferred, hash)
+					continue hashLoop
+				}
+			}
+			// TODO Improve load balancing of nodes. Eg. prioritize based on certain attributes.
+			// Any of the candidates may answer after a failover or a hedged request, so all of them are
+			// planned. Otherwise the same node could answer for two tasks and its results would be counted twice.
+			for _, location := range candidates {
+				planned[location] = true
+			}
+			tasks = append(tasks, replicaTask{token, candidates})
+		}
+
+		answers, err := c.askAll(stmt, tasks, client, r)
+		for i, answer := range answers {
+			for _, location := range answer.failed {
+				failed[location] = true
+			}
+			if answer.response != nil {
+				response = answer.response
+			}
+			if answer.err == nil && answer.location != "" {
+				asked[answer.location] = true
+				owners[tasks[i].token] = answer.location
+				allResults = append(allResults, nodeResults{answer.location, answer.results})
+			}
+		}
+		if err != nil {
+			return allResults, owners, err, response
+		}
+		pending = deferred
 	}
-	return finder.values
+	return allResults, owners, nil, response
 }
 
-func (f *tagFinder) putValue(key string, value interface{}) {
-	_, ok := f.values[key]
-	if !ok {
-		f.values[key] = []string{}
+// askAll performs the tasks with a bounded number of workers. The first error
+// cancels the remaining tasks and is returned.
+func (c *Coordinator) askAll(stmt string, tasks []replicaTask, client *http.Client, r *http.Request) ([]replicaAnswer, error) {
+	answers := make([]replicaAnswer, len(tasks))
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var firstErr error
+	var errOnce sync.Once
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := c.concurrency
+	if workers > len(tasks) {
+		workers = len(tasks)
 	}
-	switch v := value.(type) {
-	case *influxql.VarRef:
-		f.values[key] = append(f.values[key], v.Val)
-	case *influxql.StringLiteral:
-		f.values[key] = append(f.values[key], v.Val)
-	default:
-		panic("Could not find a place for " + key)
-	}
-
-}
-
-func (f *tagFinder) findTags(cond influxql.Expr) {
-	// if op isn't 22 or 23, then it is some equality operation.
-	switch expr := cond.(type) {
-	case *influxql.ParenExpr:
-		f.findTags(expr.Expr)
-	case *influxql.BinaryExpr:
-		if expr.Op == influxql.AND || expr.Op == influxql.OR {
-			f.findTags(expr.LHS)
-			f.findTags(expr.RHS)
-		} else {
-			tagKey := expr.LHS.(*influxql.VarRef).Val
-			// Greater than and less than operators are not supported.
-			// Instead, the application should define buckets to evenly
-			// distribute tags values.
-			if expr.Op == influxql.EQ {
-				current, ok := f.tags[tagKey]
-				if !ok || current != false {
-					f.tags[tagKey] = true
-					f.putValue(tagKey, expr.RHS)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				answers[i] = c.askReplicas(stmt, tasks[i].locations, client, r)
+				if answers[i].err != nil {
+					errOnce.Do(func() {
+						firstErr = answers[i].err
+						cancel()
+					})
 				}
-			} else {
-				f.tags[tagKey] = false
+			}
+		}()
+	}
+	for i := range tasks {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return answers, firstErr
+}
+
+// askReplicas requests the first location and continues with the next one if it fails.
+// If a location has not answered within the hedge delay, the next one is requested as well
+// and whichever answers first is used. Requests that are still running are then cancelled.
+func (c *Coordinator) askReplicas(stmt string, locations []string, client *http.Client, r *http.Request) replicaAnswer {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	attempts := make(chan replicaAnswer, len(locations))
+	next, running := 0, 0
+	start := func() {
+		location := locations[next]
+		next++
+		running++
+		go func() {
+			results, err, res := request(stmt, location, client, r)
+			attempts <- replicaAnswer{location: location, results: results, err: err, response: res}
+		}()
+	}
+	start()
+	hedge := time.NewTimer(c.hedgeDelay)
+	defer hedge.Stop()
+
+	var last replicaAnswer
+	failed := []string{}
+	for running > 0 {
+		select {
+		case answer := <-attempts:
+			running--
+			if answer.err == nil {
+				answer.failed = failed
+				return answer
+			}
+			if ctx.Err() == nil {
+				failed = append(failed, answer.location)
+			}
+			last = answer
+			if next < len(locations) {
+				start()
+			}
+		case <-hedge.C:
+			if next < len(locations) {
+				start()
+				hedge.Reset(c.hedgeDelay)
 			}
 		}
 	}
+	if ctx.Err() != nil {
+		last.err = fmt.Errorf("query aborted: %s", ctx.Err())
+	}
+	last.failed = failed
+	return last
 }
 
 func findConditions(stmt *influxql.SelectStatement) []influxql.Expr {
@@ -390,6 +748,9 @@ type ResultSource struct {
 	data         []resultGroup
 	fieldIndices map[string]int
 	i            int
+	raw          map[interface{}]map[string][]float64
+	rawAll       bool
+	epoch        string
 }
 
 type resultGroup struct {
@@ -454,6 +815,172 @@ func (s *ResultSource) Next(fieldKey string) []float64 {
 	return res
 }
 
+func (s *ResultSource) NextRows(fieldKeys ...string) [][]float64 {
+	var res [][]float64
+	if s.Done() {
+		return res
+	}
+	for _, v := range s.data[s.i].values {
+		data, ok := v.([]interface{})
+		if !ok {
+			continue
+		}
+		row := make([]float64, len(fieldKeys))
+		for i, fieldKey := range fieldKeys {
+			row[i] = math.NaN()
+			if fieldIndex, ok := s.fieldIndices[fieldKey]; ok {
+				if value, ok := toFloat(data[fieldIndex]); ok {
+					row[i] = value
+				}
+			}
+		}
+		res = append(res, row)
+	}
+	return res
+}
+
+// AddRaw adds the points of results from a statement created with CreateRawStatement. The points are
+// assigned to the interval they are in, or to every group if the statement is not grouped by time.
+func (s *ResultSource) AddRaw(results []Result, window timeWindow) error {
+	if s.raw == nil {
+		s.raw = map[interface{}]map[string][]float64{}
+	}
+	s.rawAll = window.interval == 0
+	for _, res := range results {
+		for _, series := range res.Series {
+			for _, v := range series.Values {
+				var key interface{}
+				if !s.rawAll {
+					var err error
+					if key, err = window.start(v[0]); err != nil {
+						return err
+					}
+				}
+				if s.raw[key] == nil {
+					s.raw[key] = map[string][]float64{}
+				}
+				for i, col := range series.Columns[1:] {
+					if value, ok := toFloat(v[i+1]); ok {
+						s.raw[key][col] = append(s.raw[key][col], value)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ResultSource) RawValues(fieldKey string) []float64 {
+	if s.Done() {
+		return nil
+	}
+	var key interface{}
+	if !s.rawAll {
+		key = s.data[s.i].time
+	}
+	// A copy is returned as the points may be sorted.
+	return append([]float64(nil), s.raw[key][fieldKey]...)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// timeWindow finds the start of the interval that a point belongs to in a statement grouped by time.
+type timeWindow struct {
+	interval time.Duration
+	offset   time.Duration
+	epoch    string
+}
+
+func newTimeWindow(stmt *influxql.SelectStatement, epoch string) (timeWindow, error) {
+	interval, err := stmt.GroupByInterval()
+	if err != nil {
+		return timeWindow{}, err
+	}
+	offset, err := stmt.GroupByOffset()
+	if err != nil {
+		return timeWindow{}, err
+	}
+	return timeWindow{interval, offset, epoch}, nil
+}
+
+// start returns the start of the interval of the time given in RFC3339 in the same format as
+// the times of the aggregated results, which depends on the requested epoch.
+func (w timeWindow) start(value interface{}) (interface{}, error) {
+	t, err := parseTime(value)
+	if err != nil {
+		return nil, err
+	}
+	return w.format(w.startOf(t)), nil
+}
+
+// startOf returns the start of the interval that the time is in.
+func (w timeWindow) startOf(t time.Time) time.Time {
+	if w.interval == 0 {
+		return time.Time{}
+	}
+	ns := t.UnixNano()
+	rest := (ns - int64(w.offset)) % int64(w.interval)
+	if rest < 0 {
+		rest += int64(w.interval)
+	}
+	return time.Unix(0, ns-rest).UTC()
+}
+
+// format returns the time in the format of the requested epoch.
+func (w timeWindow) format(t time.Time) interface{} {
+	if w.epoch == "" {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return float64(t.UnixNano() / int64(epochUnit(w.epoch)))
+}
+
+// epochUnit returns the duration of the unit for times in the given epoch.
+func epochUnit(epoch string) time.Duration {
+	switch epoch {
+	case "u", "µ":
+		return time.Microsecond
+	case "ms":
+		return time.Millisecond
+	case "s":
+		return time.Second
+	case "m":
+		return time.Minute
+	case "h":
+		return time.Hour
+	}
+	return time.Nanosecond
+}
+
+func parseTime(value interface{}) (time.Time, error) {
+	str, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unexpected time %v", value)
+	}
+	return time.Parse(time.RFC3339Nano, str)
+}
+
+// withoutEpoch returns a copy of the request for receiving times in RFC3339,
+// which can be parsed without losing precision.
+func withoutEpoch(r *http.Request) *http.Request {
+	u := *r.URL
+	values := u.Query()
+	values.Del("epoch")
+	u.RawQuery = values.Encode()
+	clone := r.WithContext(r.Context())
+	clone.URL = &u
+	return clone
+}
+
 func (s *ResultSource) Time() interface{} {
 	if s.Done() {
 		return ""
@@ -461,6 +988,42 @@ func (s *ResultSource) Time() interface{} {
 	return s.data[s.i].time
 }
 
+// UnixNano returns the time of the current group as nanoseconds since the epoch.
+func (s *ResultSource) UnixNano() int64 {
+	switch t := s.Time().(type) {
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return 0
+		}
+		return parsed.UnixNano()
+	case float64:
+		return int64(t) * int64(epochUnit(s.epoch))
+	}
+	return 0
+}
+
+func (s *ResultSource) sortByTime() {
+	times := make([]int64, len(s.data))
+	for s.Reset(); !s.Done(); s.Step() {
+		times[s.i] = s.UnixNano()
+	}
+	s.Reset()
+	sort.Stable(byTime{s.data, times})
+}
+
+type byTime struct {
+	data  []resultGroup
+	times []int64
+}
+
+func (b byTime) Len() int           { return len(b.data) }
+func (b byTime) Less(i, j int) bool { return b.times[i] < b.times[j] }
+func (b byTime) Swap(i, j int) {
+	b.data[i], b.data[j] = b.data[j], b.data[i]
+	b.times[i], b.times[j] = b.times[j], b.times[i]
+}
+
 func (s *ResultSource) Step() {
 	s.i += 1
 }
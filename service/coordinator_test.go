@@ -1,9 +1,17 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/adamringhede/influxdb-ha/cluster"
 	"github.com/adamringhede/influxdb-ha/service/merge"
 	"github.com/influxdata/influxdb/models"
 	"github.com/influxdata/influxql"
@@ -16,6 +24,12 @@ func Test_buildTree(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func Test_buildTreeHistogram(t *testing.T) {
+	stmt := mustGetSelect(`SELECT histogram(value) FROM sales`)
+	_, _, err := merge.NewQueryTree(stmt)
+	assert.EqualError(t, err, "InfluxQL function 'histogram' is not supported, as InfluxDB can not compute it on the nodes")
+}
+
 func createTestResult(dates [][]interface{}) Result {
 	return Result{Series: []*models.Row{{
 		Columns: []string{"time"},
@@ -196,6 +210,169 @@ func TestMergingEmptyResults(t *testing.T) {
 	assert.Equal(t,2.,  merged[0].Series[0].Values[0][1])
 }
 
+// newFakeNode starts a server that responds to queries as an InfluxDB node would with the given body.
+func newFakeNode(t *testing.T, body string, queries chan<- string) *httptest.Server {
+	return newFakeNodeFunc(t, func(q string) string {
+		if queries != nil {
+			queries <- q
+		}
+		return body
+	})
+}
+
+// newFakeNodeFunc starts a server that responds with the body returned for each query.
+func newFakeNodeFunc(t *testing.T, fn func(q string) string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, fn(r.URL.Query().Get("q")))
+	}))
+}
+
+func newFakeCluster(servers ...*httptest.Server) *cluster.Resolver {
+	resolver := cluster.NewResolver()
+	resolver.ReplicationFactor = 1
+	for i, server := range servers {
+		location := strings.TrimPrefix(server.URL, "http://")
+		resolver.AddToken(i*3000000000, &cluster.Node{Status: cluster.NodeStatusUp, DataLocation: location, Name: location})
+	}
+	return resolver
+}
+
+func newQueryRequest(q string) *http.Request {
+	return httptest.NewRequest("GET", "/query?db="+testDB+"&q="+url.QueryEscape(q), nil)
+}
+
+func TestCoordinator_ScatterWithoutPartitionKey(t *testing.T) {
+	queries := make(chan string, 2)
+	// trash belongs to the first node, gold and silver to the second. The first node also
+	// has a copy of gold which must not be counted twice.
+	one := newFakeNode(t, `{"results":[{"statement_id":0,"series":[`+
+		`{"name":"treasures","tags":{"type":"gold"},"columns":["time","sum_value_"],"values":[["1970-01-01T00:00:00Z",100]]},`+
+		`{"name":"treasures","tags":{"type":"trash"},"columns":["time","sum_value_"],"values":[["1970-01-01T00:00:00Z",1]]}]}]}`, queries)
+	defer one.Close()
+	two := newFakeNode(t, `{"results":[{"statement_id":0,"series":[`+
+		`{"name":"treasures","tags":{"type":"gold"},"columns":["time","sum_value_"],"values":[["1970-01-01T00:00:00Z",100]]},`+
+		`{"name":"treasures","tags":{"type":"silver"},"columns":["time","sum_value_"],"values":[["1970-01-01T00:00:00Z",50]]}]}]}`, queries)
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT sum(value) FROM treasures WHERE time <= now()`)
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Len(t, results[0].Series, 1)
+	assert.Equal(t, "treasures", results[0].Series[0].Name)
+	assert.Equal(t, 151., results[0].Series[0].Values[0][1])
+
+	close(queries)
+	for q := range queries {
+		assert.Contains(t, q, "GROUP BY type")
+	}
+}
+
+func TestCoordinator_ScatterKeepsGroups(t *testing.T) {
+	one := newFakeNode(t, `{"results":[{"statement_id":0,"series":[`+
+		`{"name":"treasures","tags":{"type":"trash"},"columns":["time","value"],"values":[["1970-01-01T00:00:02Z",1]]}]}]}`, nil)
+	defer one.Close()
+	two := newFakeNode(t, `{"results":[{"statement_id":0,"series":[`+
+		`{"name":"treasures","tags":{"type":"gold"},"columns":["time","value"],"values":[["1970-01-01T00:00:01Z",100]]}]}]}`, nil)
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT value FROM treasures GROUP BY type`)
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Len(t, results[0].Series, 2)
+	assert.Equal(t, map[string]string{"type": "gold"}, results[0].Series[0].Tags)
+	assert.Equal(t, map[string]string{"type": "trash"}, results[0].Series[1].Tags)
+}
+
+func newReplicatedCluster(servers ...*httptest.Server) *cluster.Resolver {
+	resolver := newFakeCluster(servers...)
+	resolver.ReplicationFactor = len(servers)
+	return resolver
+}
+
+func TestCoordinator_HedgesSlowReplica(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	fast := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","value"],"values":[["1970-01-01T00:00:00Z",1]]}]}]}`, nil)
+	defer fast.Close()
+
+	c := NewCoordinator(newReplicatedCluster(slow, fast), newPartitioner())
+	c.hedgeDelay = 10 * time.Millisecond
+	// trash belongs to the first token, which is on the slow node.
+	stmt := mustGetSelect(`SELECT value FROM treasures WHERE type = 'trash'`)
+	started := time.Now()
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(started) < time.Second)
+	assert.Equal(t, 1., results[0].Series[0].Values[0][1])
+}
+
+func TestCoordinator_FailsOverToReplica(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonError(w, http.StatusInternalServerError, "unavailable")
+	}))
+	defer failing.Close()
+	working := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","value"],"values":[["1970-01-01T00:00:00Z",1]]}]}]}`, nil)
+	defer working.Close()
+
+	c := NewCoordinator(newReplicatedCluster(failing, working), newPartitioner())
+	c.hedgeDelay = time.Minute
+	stmt := mustGetSelect(`SELECT value FROM treasures WHERE type = 'trash'`)
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.Equal(t, 1., results[0].Series[0].Values[0][1])
+}
+
+func TestCoordinator_FailoverCountsNodesOnce(t *testing.T) {
+	// Every node has a copy of all treasures, and two of the three replicate each range.
+	body := `{"results":[{"statement_id":0,"series":[` +
+		`{"name":"treasures","tags":{"type":"gold"},"columns":["time","sum_value_"],"values":[["1970-01-01T00:00:00Z",100]]},` +
+		`{"name":"treasures","tags":{"type":"silver"},"columns":["time","sum_value_"],"values":[["1970-01-01T00:00:00Z",10]]},` +
+		`{"name":"treasures","tags":{"type":"trash"},"columns":["time","sum_value_"],"values":[["1970-01-01T00:00:00Z",1]]}]}]}`
+	for down := 0; down < 3; down++ {
+		servers := []*httptest.Server{newFakeNode(t, body, nil), newFakeNode(t, body, nil), newFakeNode(t, body, nil)}
+		servers[down].Close()
+
+		resolver := newFakeCluster(servers...)
+		resolver.ReplicationFactor = 2
+		c := NewCoordinator(resolver, newPartitioner())
+		c.hedgeDelay = time.Minute
+		stmt := mustGetSelect(`SELECT sum(value) FROM treasures WHERE time <= now()`)
+		results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+		assert.NoError(t, err)
+		assert.Equal(t, 111., results[0].Series[0].Values[0][1], "node %d is down", down)
+
+		for _, server := range servers {
+			server.Close()
+		}
+	}
+}
+
+func TestCoordinator_QueryDeadline(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	c := NewCoordinator(newFakeCluster(slow), newPartitioner())
+	c.timeout = 50 * time.Millisecond
+	stmt := mustGetSelect(`SELECT value FROM treasures WHERE type = 'trash'`)
+	_, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.Error(t, err)
+}
+
 func mustGetSelect(q string) *influxql.SelectStatement {
 	query, err := influxql.ParseQuery(q)
 	if err != nil {
@@ -224,3 +401,189 @@ Need two or three instances,
 Test when an instance is unreachable (the randomness need to be seeded)
 	For instance, the resolver should be able to select one and it should have a parameter for a seed.
 */
+
+// fakeSeries responds with a series that has the columns in the same order as the fields in the query.
+func fakeSeries(t *testing.T, q string, tag string, times []string, columns map[string][]interface{}) string {
+	stmt := mustGetSelect(q)
+	row := &models.Row{Name: "treasures", Tags: map[string]string{"type": tag}, Columns: []string{"time"}}
+	for i, ts := range times {
+		values := []interface{}{ts}
+		for _, field := range stmt.Fields {
+			if i == 0 {
+				row.Columns = append(row.Columns, field.Name())
+			}
+			column, ok := columns[field.Name()]
+			assert.True(t, ok, "unexpected field %s", field.Name())
+			values = append(values, column[i])
+		}
+		row.Values = append(row.Values, values)
+	}
+	data, err := json.Marshal(response{[]Result{{Series: []*models.Row{row}}}})
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestCoordinator_MergesPartialAggregates(t *testing.T) {
+	intervals := []string{"1970-01-01T00:00:00Z", "1970-01-01T00:01:00Z"}
+	// trash has the points 1, 2 and 3 in the first interval.
+	one := newFakeNodeFunc(t, func(q string) string {
+		if strings.Contains(q, "raw_value") {
+			return fakeSeries(t, q, "trash", []string{"1970-01-01T00:00:10Z", "1970-01-01T00:00:20Z", "1970-01-01T00:00:30Z"},
+				map[string][]interface{}{"raw_value": {1, 2, 3}})
+		}
+		return fakeSeries(t, q, "trash", intervals, map[string][]interface{}{
+			"count_value_":  {3, 0},
+			"sum_value_":    {6, nil},
+			"mean_value_":   {2, nil},
+			"stddev_value_": {1, nil},
+		})
+	})
+	defer one.Close()
+	// gold has 10 in the first interval, and 4 and 6 in the second.
+	two := newFakeNodeFunc(t, func(q string) string {
+		if strings.Contains(q, "raw_value") {
+			return fakeSeries(t, q, "gold", []string{"1970-01-01T00:00:30Z", "1970-01-01T00:01:05Z", "1970-01-01T00:01:20Z"},
+				map[string][]interface{}{"raw_value": {10, 4, 6}})
+		}
+		return fakeSeries(t, q, "gold", intervals, map[string][]interface{}{
+			"count_value_":  {1, 2},
+			"sum_value_":    {10, 10},
+			"mean_value_":   {10, 5},
+			"stddev_value_": {nil, math.Sqrt2},
+		})
+	})
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT mean(value), stddev(value), median(value), percentile(value, 90) FROM treasures WHERE time >= 0 AND time < 2m GROUP BY time(1m)`)
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.Len(t, results[0].Series, 1)
+	values := results[0].Series[0].Values
+	assert.Len(t, values, 2)
+
+	assert.Equal(t, 4., values[0][1])
+	assert.InDelta(t, math.Sqrt(50./3), values[0][2], 1e-9)
+	assert.Equal(t, 2.5, values[0][3])
+	assert.Equal(t, 10., values[0][4])
+
+	assert.Equal(t, 5., values[1][1])
+	assert.InDelta(t, math.Sqrt2, values[1][2], 1e-9)
+	assert.Equal(t, 5., values[1][3])
+	assert.Equal(t, 6., values[1][4])
+}
+
+func TestCoordinator_RawPointsLimit(t *testing.T) {
+	defer func(max int) { MaxRawPoints = max }(MaxRawPoints)
+	MaxRawPoints = 2
+	rawQueries := make(chan string, 2)
+	newNode := func(tag string) *httptest.Server {
+		return newFakeNodeFunc(t, func(q string) string {
+			if strings.Contains(q, "raw_value") {
+				rawQueries <- q
+				return fakeSeries(t, q, tag, []string{"1970-01-01T00:00:10Z", "1970-01-01T00:00:20Z"},
+					map[string][]interface{}{"raw_value": {1, 2}})
+			}
+			return fakeSeries(t, q, tag, []string{"1970-01-01T00:00:00Z"}, map[string][]interface{}{
+				"count_value_": {2},
+				"sum_value_":   {3},
+				"mean_value_":  {1.5},
+			})
+		})
+	}
+	one, two := newNode("trash"), newNode("gold")
+	defer one.Close()
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT mean(value), median(value) FROM treasures WHERE time >= 0 AND time < 1m`)
+	_, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.EqualError(t, err, "more than 2 points are needed to merge the functions of the query, use a shorter time range")
+	assert.Contains(t, <-rawQueries, "LIMIT 3")
+}
+
+func TestTimeWindow_Start(t *testing.T) {
+	w := timeWindow{interval: time.Minute, offset: 10 * time.Second}
+	start, err := w.start("1970-01-01T00:01:05Z")
+	assert.NoError(t, err)
+	assert.Equal(t, "1970-01-01T00:00:10Z", start)
+
+	w.epoch = "s"
+	start, err = w.start("1970-01-01T00:01:15.5Z")
+	assert.NoError(t, err)
+	assert.Equal(t, 70., start)
+}
+
+func TestCoordinator_TransformsMergedAggregates(t *testing.T) {
+	intervals := []string{"1970-01-01T00:00:00Z", "1970-01-01T00:01:00Z", "1970-01-01T00:02:00Z"}
+	one := newFakeNodeFunc(t, func(q string) string {
+		return fakeSeries(t, q, "trash", intervals, map[string][]interface{}{
+			"sum_value_":   {2, 4, nil},
+			"count_value_": {1, 1, 0},
+		})
+	})
+	defer one.Close()
+	two := newFakeNodeFunc(t, func(q string) string {
+		return fakeSeries(t, q, "gold", intervals, map[string][]interface{}{
+			"sum_value_":   {nil, 4, 8},
+			"count_value_": {0, 1, 2},
+		})
+	})
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	// The means are 2, 4 and 4.
+	stmt := mustGetSelect(`SELECT derivative(mean(value), 30s) FROM treasures WHERE time >= 0 AND time < 3m GROUP BY time(1m)`)
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]interface{}{
+		{"1970-01-01T00:01:00Z", 1.},
+		{"1970-01-01T00:02:00Z", 0.},
+	}, results[0].Series[0].Values)
+
+	stmt = mustGetSelect(`SELECT cumulative_sum(sum(value)) FROM treasures WHERE time >= 0 AND time < 3m GROUP BY time(1m)`)
+	results, err, _ = c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]interface{}{
+		{"1970-01-01T00:00:00Z", 2.},
+		{"1970-01-01T00:01:00Z", 10.},
+		{"1970-01-01T00:02:00Z", 18.},
+	}, results[0].Series[0].Values)
+}
+
+func TestCoordinator_TransformsMergedPoints(t *testing.T) {
+	queries := make(chan string, 2)
+	one := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","tags":{"type":"trash"},"columns":["time","value"],"values":[`+
+		`["1970-01-01T00:00:10Z",5],["1970-01-01T00:00:30Z",3]]}]}]}`, queries)
+	defer one.Close()
+	two := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","tags":{"type":"gold"},"columns":["time","value"],"values":[`+
+		`["1970-01-01T00:00:20Z",4],["1970-01-01T00:00:40Z",10]]}]}]}`, queries)
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT non_negative_difference(value) FROM treasures`)
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{"1970-01-01T00:00:40Z", 7.}}, results[0].Series[0].Values)
+
+	close(queries)
+	for q := range queries {
+		assert.Contains(t, q, "SELECT value AS value")
+	}
+}
+
+func TestReadScope(t *testing.T) {
+	cases := []struct {
+		query string
+		scope cluster.ReplicationScope
+	}{
+		{"SELECT * FROM cpu", cluster.ReplicationScope{Database: "db", Measurement: "cpu"}},
+		{"SELECT * FROM other.autogen.cpu", cluster.ReplicationScope{Database: "other", Measurement: "cpu"}},
+		{"SELECT mean(v) FROM (SELECT * FROM cpu)", cluster.ReplicationScope{Database: "db", Measurement: "cpu"}},
+		{"SELECT * FROM /c.*/", cluster.ReplicationScope{Database: "db"}},
+		{"SELECT * FROM cpu, mem", cluster.ReplicationScope{Database: "db"}},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.scope, readScope(mustGetSelect(c.query), "db"), c.query)
+	}
+}
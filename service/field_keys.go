@@ -0,0 +1,168 @@
+package service
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxql"
+)
+
+// fieldKeysTTL is how long the keys of a measurement are cached. Fields that are added
+// to a measurement are not included when expanding wildcards until the cache has expired.
+const fieldKeysTTL = time.Minute
+
+// FieldKeyCache holds the field and tag keys of measurements so that wildcards and regular
+// expressions in distributed queries can be expanded without asking the nodes for every query.
+type FieldKeyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]measurementKeys
+}
+
+type measurementKeys struct {
+	fields  map[string]influxql.DataType
+	tags    map[string]struct{}
+	fetched time.Time
+}
+
+func NewFieldKeyCache() *FieldKeyCache {
+	return &FieldKeyCache{ttl: fieldKeysTTL, entries: map[string]measurementKeys{}}
+}
+
+func (c *FieldKeyCache) get(key string) (measurementKeys, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys, ok := c.entries[key]
+	if !ok || time.Since(keys.fetched) > c.ttl {
+		return measurementKeys{}, false
+	}
+	return keys, true
+}
+
+func (c *FieldKeyCache) put(key string, keys measurementKeys) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys.fetched = time.Now()
+	c.entries[key] = keys
+}
+
+// fieldMapper implements influxql.FieldMapper by asking the nodes that a query is sent to for the
+// keys of the measurement. As a measurement may not have the same fields on every partition, the
+// keys from all nodes are combined.
+type fieldMapper struct {
+	c      *Coordinator
+	r      *http.Request
+	db     string
+	hashes []int
+	client *http.Client
+}
+
+func (m *fieldMapper) FieldDimensions(msmt *influxql.Measurement) (map[string]influxql.DataType, map[string]struct{}, error) {
+	keys, err := m.keys(msmt)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := make(map[string]influxql.DataType, len(keys.fields))
+	for k, v := range keys.fields {
+		fields[k] = v
+	}
+	tags := make(map[string]struct{}, len(keys.tags))
+	for k := range keys.tags {
+		tags[k] = struct{}{}
+	}
+	return fields, tags, nil
+}
+
+func (m *fieldMapper) MapType(msmt *influxql.Measurement, field string) influxql.DataType {
+	keys, err := m.keys(msmt)
+	if err != nil {
+		return influxql.Unknown
+	}
+	if typ, ok := keys.fields[field]; ok {
+		return typ
+	}
+	if _, ok := keys.tags[field]; ok {
+		return influxql.Tag
+	}
+	return influxql.Unknown
+}
+
+func (m *fieldMapper) keys(msmt *influxql.Measurement) (measurementKeys, error) {
+	db := msmt.Database
+	if db == "" {
+		db = m.db
+	}
+	cacheKey := db + "." + msmt.RetentionPolicy + "." + msmt.String()
+	if keys, ok := m.c.fieldKeys.get(cacheKey); ok {
+		return keys, nil
+	}
+
+	keys := measurementKeys{fields: map[string]influxql.DataType{}, tags: map[string]struct{}{}}
+	sources := influxql.Sources{msmt}
+	scope := cluster.ReplicationScope{Database: db, Measurement: msmt.Name}
+	if msmt.Regex != nil {
+		scope.Measurement = ""
+	}
+	fieldResults, _, err, _ := m.c.performQuery((&influxql.ShowFieldKeysStatement{Sources: sources}).String(), m.r, m.hashes, scope, m.client)
+	if err != nil {
+		return keys, err
+	}
+	forEachRow(fieldResults, func(row []interface{}) {
+		if len(row) < 2 {
+			return
+		}
+		name, _ := row[0].(string)
+		typeName, _ := row[1].(string)
+		typ := influxql.DataTypeFromString(typeName)
+		if keys.fields[name].LessThan(typ) {
+			keys.fields[name] = typ
+		}
+	})
+	tagResults, _, err, _ := m.c.performQuery((&influxql.ShowTagKeysStatement{Sources: sources}).String(), m.r, m.hashes, scope, m.client)
+	if err != nil {
+		return keys, err
+	}
+	forEachRow(tagResults, func(row []interface{}) {
+		if len(row) > 0 {
+			if name, ok := row[0].(string); ok {
+				keys.tags[name] = struct{}{}
+			}
+		}
+	})
+
+	m.c.fieldKeys.put(cacheKey, keys)
+	return keys, nil
+}
+
+func forEachRow(allResults []nodeResults, fn func(row []interface{})) {
+	for _, node := range allResults {
+		for _, res := range node.results {
+			for _, series := range res.Series {
+				for _, row := range series.Values {
+					fn(row)
+				}
+			}
+		}
+	}
+}
+
+// expandFields replaces wildcards and regular expressions in the fields of the statement with
+// the matching fields of the measurement, in the same way as InfluxDB does.
+func (c *Coordinator) expandFields(stmt *influxql.SelectStatement, r *http.Request, db string, hashes []int, client *http.Client) (*influxql.SelectStatement, error) {
+	rewritten, err := stmt.RewriteFields(&fieldMapper{c, r, db, hashes, client})
+	if err != nil {
+		return nil, err
+	}
+	// Types are added to all references when rewriting, which are not needed when
+	// the fields are sent to the nodes.
+	influxql.WalkFunc(rewritten.Fields, func(n influxql.Node) {
+		if ref, ok := n.(*influxql.VarRef); ok {
+			ref.Type = influxql.Unknown
+		}
+	})
+	expanded := stmt.Clone()
+	expanded.Fields = rewritten.Fields
+	return expanded, nil
+}
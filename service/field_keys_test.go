@@ -0,0 +1,66 @@
+package service
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFieldKeysNode(t *testing.T, fieldKeyQueries *int32) func(q string) string {
+	return func(q string) string {
+		switch {
+		case strings.HasPrefix(q, "SHOW FIELD KEYS"):
+			atomic.AddInt32(fieldKeyQueries, 1)
+			return `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["fieldKey","fieldType"],"values":[["value","float"],["weight","integer"],["note","string"]]}]}]}`
+		case strings.HasPrefix(q, "SHOW TAG KEYS"):
+			return `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["tagKey"],"values":[["type"]]}]}]}`
+		}
+		assert.NotContains(t, q, "note")
+		assert.Contains(t, q, "sum(value)")
+		assert.Contains(t, q, "sum(weight)")
+		return `{"results":[{"statement_id":0,"series":[{"name":"treasures","tags":{"type":"trash"},"columns":["time","sum_value_","sum_weight_"],"values":[["1970-01-01T00:00:00Z",1,2]]}]}]}`
+	}
+}
+
+func TestCoordinator_ExpandsWildcardInAggregate(t *testing.T) {
+	var fieldKeyQueries int32
+	one := newFakeNodeFunc(t, newFieldKeysNode(t, &fieldKeyQueries))
+	defer one.Close()
+	two := newFakeNodeFunc(t, newFieldKeysNode(t, &fieldKeyQueries))
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	for i := 0; i < 2; i++ {
+		stmt := mustGetSelect(`SELECT sum(*) FROM treasures WHERE time <= now()`)
+		results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"time", "sum_value", "sum_weight"}, results[0].Series[0].Columns)
+	}
+	// The field keys are cached after the first query.
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fieldKeyQueries))
+}
+
+func TestCoordinator_ExpandsRegexInAggregate(t *testing.T) {
+	var fieldKeyQueries int32
+	one := newFakeNodeFunc(t, func(q string) string {
+		if strings.HasPrefix(q, "SHOW") {
+			return newFieldKeysNode(t, &fieldKeyQueries)(q)
+		}
+		// max is merged by pushing down top with a count of one.
+		assert.Contains(t, q, "top(weight, 1)")
+		assert.NotContains(t, q, "value")
+		return `{"results":[{"statement_id":0,"series":[{"name":"treasures","tags":{"type":"trash"},"columns":["time","top_weight__1_"],"values":[["1970-01-01T00:00:00Z",3]]}]}]}`
+	})
+	defer one.Close()
+	two := newFakeNode(t, `{"results":[{"statement_id":0}]}`, nil)
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT max(/^wei/) FROM treasures WHERE time <= now()`)
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"time", "max_weight"}, results[0].Series[0].Columns)
+	assert.Equal(t, 3., results[0].Series[0].Values[0][1])
+}
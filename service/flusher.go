@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const defaultChunkSize = 10000
+
+// ResultFlusher writes the results of statements to the client. Results are either
+// kept in memory and written at once when flushed, or written as soon as they are received.
+type ResultFlusher interface {
+	// SetStatementID sets the id of the statement that following results belong to.
+	SetStatementID(id int)
+	// Write adds results of the current statement.
+	Write(results ...Result) error
+	// Flush writes anything that has not yet been sent to the client.
+	Flush() error
+	// Started returns true if the response has been started, after which errors can only be sent as results.
+	Started() bool
+}
+
+// NewResultFlusher creates a flusher that streams results if the client requested a chunked response.
+func NewResultFlusher(w http.ResponseWriter, r *http.Request) ResultFlusher {
+	if isChunked(r) {
+		return &chunkedFlusher{w: w}
+	}
+	return &bufferedFlusher{w: w}
+}
+
+func isChunked(r *http.Request) bool {
+	return r.URL.Query().Get("chunked") == "true"
+}
+
+// chunkSize returns the maximum number of rows per chunk requested by the client.
+func chunkSize(r *http.Request) int {
+	if size, err := strconv.Atoi(r.URL.Query().Get("chunk_size")); err == nil && size > 0 {
+		return size
+	}
+	return defaultChunkSize
+}
+
+type bufferedFlusher struct {
+	w           http.ResponseWriter
+	statementID int
+	results     []Result
+}
+
+func (f *bufferedFlusher) SetStatementID(id int) {
+	f.statementID = id
+}
+
+func (f *bufferedFlusher) Write(results ...Result) error {
+	for _, res := range results {
+		res.StatementID = f.statementID
+		f.results = append(f.results, res)
+	}
+	return nil
+}
+
+func (f *bufferedFlusher) Started() bool {
+	return false
+}
+
+func (f *bufferedFlusher) Flush() error {
+	respondWithResults(f.w, f.results)
+	f.results = nil
+	return nil
+}
+
+// chunkedFlusher writes every result on a line of its own like InfluxDB does for chunked responses.
+type chunkedFlusher struct {
+	w           http.ResponseWriter
+	statementID int
+	started     bool
+}
+
+func (f *chunkedFlusher) SetStatementID(id int) {
+	f.statementID = id
+}
+
+func (f *chunkedFlusher) Write(results ...Result) error {
+	if !f.started {
+		f.w.Header().Set("Content-Type", "application/json")
+		f.w.Header().Add("X-InfluxDB-Version", "relay")
+		f.w.WriteHeader(http.StatusOK)
+		f.started = true
+	}
+	encoder := json.NewEncoder(f.w)
+	for _, res := range results {
+		res.StatementID = f.statementID
+		if err := encoder.Encode(response{[]Result{res}}); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (f *chunkedFlusher) Started() bool {
+	return f.started
+}
+
+func (f *chunkedFlusher) Flush() error {
+	if !f.started {
+		// Influx always returns at least one result.
+		return f.Write(Result{})
+	}
+	return nil
+}
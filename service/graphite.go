@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// GraphiteConfig configures a listener for the Graphite plaintext protocol. Metric paths are turned
+// into measurements, tags and fields by the templates, which work like in InfluxDB.
+type GraphiteConfig struct {
+	BindAddress     string   `toml:"bind-address"`
+	Database        string   `toml:"database"`
+	RetentionPolicy string   `toml:"retention-policy"`
+	Protocol        string   `toml:"protocol"`
+	Separator       string   `toml:"separator"`
+	Templates       []string `toml:"templates"`
+	Tags            []string `toml:"tags"`
+}
+
+func (c GraphiteConfig) parser() (*graphiteParser, error) {
+	tags, err := parseGraphiteTags(c.Tags)
+	if err != nil {
+		return nil, err
+	}
+	separator := c.Separator
+	if separator == "" {
+		separator = "."
+	}
+	return newGraphiteParser(c.Templates, separator, tags)
+}
+
+// graphiteBatchSize is the maximum number of lines from a TCP connection that are written together.
+const graphiteBatchSize = 5000
+
+// GraphiteListener accepts metrics over TCP or UDP. The lines of a TCP connection are written in
+// batches, while packets are written like those of the UDP listener. Lines that can not be parsed
+// or written are only logged.
+type GraphiteListener struct {
+	config   GraphiteConfig
+	ingest   ingestFunc
+	parser   *graphiteParser
+	listener net.Listener
+	conn     net.PacketConn
+	queue    *pointsQueue
+}
+
+func NewGraphiteListener(config GraphiteConfig, ingest ingestFunc) (*GraphiteListener, error) {
+	parser, err := config.parser()
+	if err != nil {
+		return nil, err
+	}
+	return &GraphiteListener{config: config, ingest: ingest, parser: parser}, nil
+}
+
+func (l *GraphiteListener) Open() error {
+	var err error
+	switch l.config.Protocol {
+	case "", "tcp":
+		l.listener, err = net.Listen("tcp", l.config.BindAddress)
+	case "udp":
+		l.conn, err = net.ListenPacket("udp", l.config.BindAddress)
+		l.queue = newPointsQueue("Graphite", listenerWorkers, listenerQueueSize, l.write)
+	default:
+		return fmt.Errorf("unsupported graphite protocol %q", l.config.Protocol)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening for Graphite on %s", l.Addr())
+	return nil
+}
+
+func (l *GraphiteListener) Addr() net.Addr {
+	if l.listener != nil {
+		return l.listener.Addr()
+	}
+	return l.conn.LocalAddr()
+}
+
+// Serve accepts connections or reads packets until the listener is closed.
+func (l *GraphiteListener) Serve() {
+	if l.conn != nil {
+		l.serveUDP()
+		return
+	}
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if isClosedError(err) {
+				return
+			}
+			log.Printf("Failed to accept Graphite connection: %s", err)
+			continue
+		}
+		go l.serveConn(conn)
+	}
+}
+
+// Dropped returns the number of packets that have been dropped because the write queue was full.
+// Lines received over TCP are never dropped, as the connection is not read while they are written.
+func (l *GraphiteListener) Dropped() uint64 {
+	if l.queue == nil {
+		return 0
+	}
+	return l.queue.Dropped()
+}
+
+// serveConn reads lines from the connection and writes them in batches. A batch is written when it
+// is full or when it has all lines that have been received so far.
+func (l *GraphiteListener) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	lines := []string{}
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			lines = append(lines, line)
+		}
+		if err != nil {
+			l.write(l.parseLines(lines))
+			if err != io.EOF && !isClosedError(err) {
+				log.Printf("Failed to read Graphite connection: %s", err)
+			}
+			return
+		}
+		if len(lines) >= graphiteBatchSize || reader.Buffered() == 0 {
+			l.write(l.parseLines(lines))
+			lines = lines[:0]
+		}
+	}
+}
+
+func (l *GraphiteListener) serveUDP() {
+	defer l.queue.close()
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if isClosedError(err) {
+				return
+			}
+			log.Printf("Failed to read Graphite packet: %s", err)
+			continue
+		}
+		points := l.parseLines(strings.Split(string(buf[:n]), "\n"))
+		if len(points) > 0 {
+			l.queue.add(points)
+		}
+	}
+}
+
+func (l *GraphiteListener) parseLines(lines []string) []models.Point {
+	points := []models.Point{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		point, err := l.parser.parse(line)
+		if err != nil {
+			log.Printf("Failed to parse Graphite line %q: %s", line, err)
+			continue
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+func (l *GraphiteListener) write(points []models.Point) {
+	if len(points) == 0 {
+		return
+	}
+	if err := l.ingest(points, l.config.Database, l.config.RetentionPolicy); err != nil {
+		log.Printf("Failed to write points from Graphite: %s", err)
+	}
+}
+
+func (l *GraphiteListener) Close() error {
+	if l.listener != nil {
+		return l.listener.Close()
+	}
+	return l.conn.Close()
+}
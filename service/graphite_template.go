@@ -0,0 +1,210 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+const defaultGraphiteField = "value"
+
+// graphiteTemplate turns the nodes of a metric path into a measurement, tags and a field. Every part
+// of the template is measurement, field, a tag name or empty to skip the node. A measurement* or
+// field* part uses the rest of the path.
+type graphiteTemplate struct {
+	filter []string
+	parts  []string
+	tags   map[string]string
+}
+
+// parseGraphiteTemplate parses a template of the form "[filter] template [tag=value,...]".
+func parseGraphiteTemplate(s string) (*graphiteTemplate, error) {
+	fields := strings.Fields(s)
+	t := &graphiteTemplate{tags: map[string]string{}}
+	var tags string
+	switch len(fields) {
+	case 1:
+		t.parts = strings.Split(fields[0], ".")
+	case 2:
+		if strings.Contains(fields[1], "=") {
+			t.parts, tags = strings.Split(fields[0], "."), fields[1]
+		} else {
+			t.filter, t.parts = strings.Split(fields[0], "."), strings.Split(fields[1], ".")
+		}
+	case 3:
+		t.filter, t.parts, tags = strings.Split(fields[0], "."), strings.Split(fields[1], "."), fields[2]
+	default:
+		return nil, fmt.Errorf("invalid graphite template %q", s)
+	}
+	if tags != "" {
+		var err error
+		if t.tags, err = parseGraphiteTags(strings.Split(tags, ",")); err != nil {
+			return nil, err
+		}
+	}
+	hasMeasurement := false
+	for _, part := range t.parts {
+		if part == "measurement" || part == "measurement*" {
+			hasMeasurement = true
+		}
+	}
+	if !hasMeasurement {
+		return nil, fmt.Errorf("graphite template %q has no measurement", s)
+	}
+	return t, nil
+}
+
+func parseGraphiteTags(tags []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid graphite tag %q, expected key=value", tag)
+		}
+		parsed[parts[0]] = parts[1]
+	}
+	return parsed, nil
+}
+
+// matches returns true if every node of the filter is a wildcard or equal to the node of the path.
+func (t *graphiteTemplate) matches(nodes []string) bool {
+	if len(t.filter) > len(nodes) {
+		return false
+	}
+	for i, f := range t.filter {
+		if f != "*" && f != nodes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// moreSpecific returns true if the filter of the template matches fewer paths than the other,
+// which is the case if it is longer or has a name where the other has a wildcard.
+func (t *graphiteTemplate) moreSpecific(other *graphiteTemplate) bool {
+	if len(t.filter) != len(other.filter) {
+		return len(t.filter) > len(other.filter)
+	}
+	for i := range t.filter {
+		if (t.filter[i] == "*") != (other.filter[i] == "*") {
+			return other.filter[i] == "*"
+		}
+	}
+	return false
+}
+
+func (t *graphiteTemplate) apply(nodes []string, separator string) (string, map[string]string, string) {
+	var measurement, field []string
+	tags := map[string][]string{}
+	for i, part := range t.parts {
+		if i >= len(nodes) {
+			break
+		}
+		switch part {
+		case "":
+		case "measurement":
+			measurement = append(measurement, nodes[i])
+		case "measurement*":
+			measurement = append(measurement, nodes[i:]...)
+		case "field":
+			field = append(field, nodes[i])
+		case "field*":
+			field = append(field, nodes[i:]...)
+		default:
+			tags[part] = append(tags[part], nodes[i])
+		}
+		if part == "measurement*" || part == "field*" {
+			break
+		}
+	}
+	joined := map[string]string{}
+	for k, v := range tags {
+		joined[k] = strings.Join(v, separator)
+	}
+	return strings.Join(measurement, separator), joined, strings.Join(field, separator)
+}
+
+// graphiteParser parses lines of the plaintext protocol, which have a path, a value and an optional
+// timestamp in seconds. Paths are matched against the most specific template that has a filter
+// matching it, and the last template without a filter is used for the others.
+type graphiteParser struct {
+	templates []*graphiteTemplate
+	fallback  *graphiteTemplate
+	separator string
+	tags      map[string]string
+}
+
+func newGraphiteParser(templates []string, separator string, tags map[string]string) (*graphiteParser, error) {
+	p := &graphiteParser{separator: separator, tags: tags}
+	for _, s := range templates {
+		t, err := parseGraphiteTemplate(s)
+		if err != nil {
+			return nil, err
+		}
+		if t.filter == nil {
+			p.fallback = t
+		} else {
+			p.templates = append(p.templates, t)
+		}
+	}
+	if p.fallback == nil {
+		p.fallback = &graphiteTemplate{parts: []string{"measurement*"}}
+	}
+	return p, nil
+}
+
+func (p *graphiteParser) match(nodes []string) *graphiteTemplate {
+	var matched *graphiteTemplate
+	for _, t := range p.templates {
+		if t.matches(nodes) && (matched == nil || t.moreSpecific(matched)) {
+			matched = t
+		}
+	}
+	if matched == nil {
+		return p.fallback
+	}
+	return matched
+}
+
+func (p *graphiteParser) parse(line string) (models.Point, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, fmt.Errorf("received %q which does not have the fields path, value and timestamp", line)
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("invalid value %q", fields[1])
+	}
+	timestamp := time.Now().UTC()
+	if len(fields) == 3 {
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+		// Graphite clients send -1 when they want the time of the server to be used.
+		if seconds != -1 {
+			timestamp = time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+		}
+	}
+
+	nodes := strings.Split(fields[0], ".")
+	t := p.match(nodes)
+	measurement, pathTags, field := t.apply(nodes, p.separator)
+	if measurement == "" {
+		measurement = fields[0]
+	}
+	if field == "" {
+		field = defaultGraphiteField
+	}
+	tags := map[string]string{}
+	for _, defaults := range []map[string]string{p.tags, t.tags, pathTags} {
+		for k, v := range defaults {
+			tags[k] = v
+		}
+	}
+	return models.NewPoint(measurement, models.NewTags(tags), models.Fields{field: value}, timestamp)
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphiteConfig(t *testing.T) {
+	config := GraphiteConfig{
+		Templates: []string{"treasures.* measurement.type.field", "measurement*"},
+		Tags:      []string{"source=graphite"},
+	}
+	parser, err := config.parser()
+	require.NoError(t, err)
+
+	point, err := parser.parse("treasures.gold.value 29 1439856000")
+	require.NoError(t, err)
+	assert.Equal(t, "treasures", string(point.Name()))
+	assert.Equal(t, "gold", point.Tags().GetString("type"))
+	assert.Equal(t, "graphite", point.Tags().GetString("source"))
+	assert.Equal(t, int64(1439856000), point.Time().Unix())
+
+	point, err = parser.parse("cpu.load 0.5 1439856000")
+	require.NoError(t, err)
+	assert.Equal(t, "cpu.load", string(point.Name()))
+
+	_, err = GraphiteConfig{Tags: []string{"source"}}.parser()
+	assert.Error(t, err)
+	_, err = GraphiteConfig{Templates: []string{"type.field"}}.parser()
+	assert.Error(t, err)
+}
+
+func TestGraphiteTemplates(t *testing.T) {
+	parser, err := newGraphiteParser([]string{
+		"servers.* .host.measurement*",
+		"servers.*.cpu .host.measurement.field* region=eu",
+		"measurement.field",
+	}, "_", nil)
+	require.NoError(t, err)
+
+	cases := []struct {
+		line        string
+		measurement string
+		tags        map[string]string
+		field       string
+	}{
+		{"servers.a.disk.used 1", "disk_used", map[string]string{"host": "a"}, "value"},
+		{"servers.a.cpu.user.total 1", "cpu", map[string]string{"host": "a", "region": "eu"}, "user_total"},
+		{"treasures.gold 1", "treasures", map[string]string{}, "gold"},
+	}
+	for _, c := range cases {
+		point, err := parser.parse(c.line)
+		require.NoError(t, err, c.line)
+		assert.Equal(t, c.measurement, string(point.Name()), c.line)
+		assert.Equal(t, c.tags, point.Tags().Map(), c.line)
+		fields, err := point.Fields()
+		require.NoError(t, err)
+		assert.Contains(t, fields, c.field, c.line)
+	}
+
+	for _, line := range []string{"treasures", "treasures.gold x", "treasures.gold 1 x", "treasures.gold 1 2 3"} {
+		_, err := parser.parse(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestGraphiteListener(t *testing.T) {
+	pointsWriter := NewMockPointsWriter()
+	handler, _, _, _ := newTestWriteHandler(pointsWriter)
+
+	config := GraphiteConfig{
+		BindAddress: "127.0.0.1:0",
+		Database:    testDB,
+		Templates:   []string{"measurement.type.field"},
+	}
+	listener, err := NewGraphiteListener(config, handler.ingest)
+	require.NoError(t, err)
+	require.NoError(t, listener.Open())
+	defer listener.Close()
+	go listener.Serve()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("treasures.gold.value 29 1439856000\ntreasures.silver.value 30 1439856000\n"))
+	require.NoError(t, err)
+	conn.Close()
+
+	waitForPoints(t, pointsWriter, 2)
+}
+
+func TestGraphiteListener_BatchesLines(t *testing.T) {
+	batches := make(chan int, 10)
+	ingest := func(points []models.Point, db, rp string) error {
+		batches <- len(points)
+		return nil
+	}
+	listener, err := NewGraphiteListener(GraphiteConfig{BindAddress: "127.0.0.1:0", Templates: []string{"measurement.type.field"}}, ingest)
+	require.NoError(t, err)
+	require.NoError(t, listener.Open())
+	defer listener.Close()
+	go listener.Serve()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("treasures.gold.value 29 1439856000\ntreasures.silver.value 30 1439856000\ntreasures.trash.value 1 1439856000\n"))
+	require.NoError(t, err)
+	conn.Close()
+
+	select {
+	case n := <-batches:
+		// The lines that were received together are written together.
+		assert.Equal(t, 3, n)
+	case <-time.After(5 * time.Second):
+		t.Fatal("points were not written")
+	}
+}
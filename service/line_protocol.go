@@ -0,0 +1,71 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// parseLines parses the points of each line separately, so that a line that can not be parsed does
+// not prevent the other points from being written. The line number of every point is returned
+// together with errors for the lines that could not be parsed.
+func parseLines(data []byte, precision string) ([]models.Point, []int, []string) {
+	now := time.Now().UTC()
+	points := []models.Point{}
+	lines := []int{}
+	errs := []string{}
+	next := 1
+	for _, line := range splitLines(data) {
+		number := next
+		next += bytes.Count(line, []byte("\n")) + 1
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 || trimmed[0] == '#' {
+			continue
+		}
+		parsed, err := models.ParsePointsWithPrecision(trimmed, now, precision)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s at line %d", err, number))
+			continue
+		}
+		for _, point := range parsed {
+			points = append(points, point)
+			lines = append(lines, number)
+		}
+	}
+	return points, lines, errs
+}
+
+// splitLines splits line protocol into lines. Newlines within quoted string fields do not end a line.
+func splitLines(data []byte) [][]byte {
+	lines := [][]byte{}
+	start := 0
+	started, inFields, quoted := false, false, false
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+			started = true
+		case ' ', '\t':
+			// Fields start after the first space that follows the measurement and tags.
+			inFields = inFields || started
+		case '"':
+			if inFields {
+				quoted = !quoted
+			}
+		case '\n':
+			if !quoted {
+				lines = append(lines, data[start:i])
+				start = i + 1
+				started, inFields = false, false
+			}
+		default:
+			started = true
+		}
+	}
+	if start < len(data) {
+		lines = append(lines, data[start:])
+	}
+	return lines
+}
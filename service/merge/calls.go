@@ -1,8 +1,8 @@
 package merge
 
 import (
-	"fmt"
 	"math"
+	"sort"
 	"strconv"
 )
 
@@ -43,96 +43,123 @@ func (n *MovingAverage) Next(source ResultSource) []float64 {
 	return []float64{totalSum / totalCount}
 }
 
+type RawValues struct {
+	Field string
+}
+
+func (n *RawValues) Next(source ResultSource) []float64 {
+	return source.RawValues(n.Field)
+}
+
+// Percentile can not be merged from the percentiles of each node, so all points are
+// requested instead. The count is requested so that there is a value for every interval.
 type Percentile struct {
-	percentiles *Values
+	points *RawValues
 	counts *Values
+	p      float64
 }
 
 func NewPercentile(fieldKey string, p float64, qb *QueryBuilder) *Percentile {
 	return &Percentile{
-		qb.Get(fmt.Sprintf("percentile(%s, %f.5)", fieldKey, p)),
+		qb.Raw(fieldKey),
 		qb.Get("count(" + fieldKey + ")"),
+		p,
 	}
 }
 
 func (n *Percentile) Next(source ResultSource) []float64 {
-	values := n.percentiles.Next(source)
-	counts := n.counts.Next(source)
-	if len(values) == 0 {
+	n.counts.Next(source)
+	return PercentileOf(n.points.Next(source), n.p)
+}
+
+// PercentileOf returns the p-th percentile of the points by the nearest rank, like InfluxDB, or
+// nil if there is none. The points are sorted.
+func PercentileOf(points []float64, p float64) []float64 {
+	if len(points) == 0 {
 		return nil
 	}
-	var totalSum float64
-	var totalCount float64
-	for i, value := range values {
-		if counts[i] > 0 {
-			totalSum += value * counts[i]
-			totalCount += counts[i]
-		}
+	sort.Float64s(points)
+	i := int(math.Floor(float64(len(points))*p/100.0+0.5)) - 1
+	if i < 0 || i >= len(points) {
+		return nil
 	}
-	return []float64{totalSum / totalCount}
+	return []float64{points[i]}
 }
 
 type Median struct {
-	medians *Values
+	points *RawValues
 	counts *Values
 }
 
-
-func NewMedian(fieldKey string, qb *QueryBuilder) *Percentile {
-	return &Percentile{
-		qb.Get(fmt.Sprintf("median(%s)", fieldKey)),
+func NewMedian(fieldKey string, qb *QueryBuilder) *Median {
+	return &Median{
+		qb.Raw(fieldKey),
 		qb.Get("count(" + fieldKey + ")"),
 	}
 }
 
 func (n *Median) Next(source ResultSource) []float64 {
-	values := n.medians.Next(source)
-	counts := n.counts.Next(source)
-	if len(values) == 0 {
+	n.counts.Next(source)
+	return MedianOf(n.points.Next(source))
+}
+
+// MedianOf returns the median of the points, or nil if there are none. The points are sorted.
+func MedianOf(points []float64) []float64 {
+	if len(points) == 0 {
 		return nil
 	}
-	var totalSum float64
-	var totalCount float64
-	for i, value := range values {
-		if counts[i] > 0 {
-			totalSum += value * counts[i]
-			totalCount += counts[i]
-		}
+	sort.Float64s(points)
+	middle := len(points) / 2
+	if len(points)%2 == 0 {
+		return []float64{(points[middle-1] + points[middle]) / 2}
 	}
-	return []float64{totalSum / totalCount}
+	return []float64{points[middle]}
 }
 
+// Stddev combines the count, mean and standard deviation of each node in the same way as
+// when computing the variance in parallel, which gives the same result as for all points.
 type Stddev struct {
-	stds *Values
 	counts *Values
+	means  *Values
+	stds   *Values
 }
 
-
 func NewStddev(fieldKey string, qb *QueryBuilder) *Stddev {
 	return &Stddev{
-		qb.Get(fmt.Sprintf("stddev(%s)", fieldKey)),
 		qb.Get("count(" + fieldKey + ")"),
+		qb.Get("mean(" + fieldKey + ")"),
+		qb.Get("stddev(" + fieldKey + ")"),
 	}
 }
 
 func (n *Stddev) Next(source ResultSource) []float64 {
-	values := n.stds.Next(source)
-	counts := n.counts.Next(source)
-	if len(values) == 0 {
+	rows := [][]float64{}
+	var count, sum float64
+	for _, row := range source.NextRows(n.counts.Field, n.means.Field, n.stds.Field) {
+		if math.IsNaN(row[0]) || math.IsNaN(row[1]) || row[0] == 0 {
+			continue
+		}
+		count += row[0]
+		sum += row[0] * row[1]
+		rows = append(rows, row)
+	}
+	// Like InfluxDB, there is no standard deviation of a single point.
+	if count < 2 {
 		return nil
 	}
-	var totalSum float64
-	var totalCount float64
-	for i, value := range values {
-		if counts[i] > 0 {
-			totalSum += value * counts[i]
-			totalCount += counts[i]
+	mean := sum / count
+	var squares float64
+	for _, row := range rows {
+		// The standard deviation is missing if the node only has one point.
+		if !math.IsNaN(row[2]) {
+			squares += row[2] * row[2] * (row[0] - 1)
 		}
+		d := row[1] - mean
+		squares += row[0] * d * d
 	}
-	return []float64{totalSum / totalCount}
+	return []float64{math.Sqrt(squares / (count - 1))}
 }
 
-
 type Sample struct {
 	values *Values
 	count int
@@ -290,14 +317,18 @@ func NewMean(fieldKey string, qb *QueryBuilder) *Mean {
 func (n *Mean) Next(source ResultSource) []float64 {
 	sums := n.sums.Next(source)
 	counts := n.counts.Next(source)
-	if len(counts) == 0 {
-		return nil
-	}
+	// Nodes without points in the interval have a count of zero but no sum,
+	// so the totals are summed separately.
 	var totalSum float64
 	var totalCount float64
-	for i, sum := range sums {
+	for _, sum := range sums {
 		totalSum += sum
-		totalCount += counts[i]
+	}
+	for _, count := range counts {
+		totalCount += count
+	}
+	if totalCount == 0 {
+		return nil
 	}
 	return []float64{totalSum / totalCount}
 }
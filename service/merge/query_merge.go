@@ -6,12 +6,21 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/influxdata/influxql"
 )
 
 type ResultSource interface {
 	Next(string) []float64
+	// NextRows returns the values of the fields from each of the results in the current
+	// group. A value that is missing in a result is NaN.
+	NextRows(...string) [][]float64
+	// RawValues returns the points of a field, that were queried without any aggregation,
+	// which are in the current group.
+	RawValues(string) []float64
+	// UnixNano returns the time of the current group.
+	UnixNano() int64
 }
 
 type QueryField struct {
@@ -25,33 +34,43 @@ type QueryNode interface {
 
 type QueryBuilder struct {
 	Fields map[string]string
+	// RawFields are fields that need all points to be merged, such as for percentiles.
+	RawFields map[string]string
 }
 
 func NewQueryBuilder() *QueryBuilder {
-	return &QueryBuilder{map[string]string{}}
+	return &QueryBuilder{map[string]string{}, map[string]string{}}
 }
 
-func (qb *QueryBuilder) Get(expr string) *Values {
+func fieldName(expr string) string {
 	name := expr
 	for _, t := range []string{"\"", "'", "(", ")", ",", ".", " ", "\n"} {
 		name = strings.Replace(name, t, "_", -1)
 	}
+	return name
+}
+
+func (qb *QueryBuilder) Get(expr string) *Values {
 	if _, ok := qb.Fields[expr]; !ok {
-		qb.Fields[expr] = name
+		qb.Fields[expr] = fieldName(expr)
 	}
 	return &Values{qb.Fields[expr]}
 }
 
+// Raw adds a field to the statement that is created by CreateRawStatement.
+func (qb *QueryBuilder) Raw(fieldKey string) *RawValues {
+	if _, ok := qb.RawFields[fieldKey]; !ok {
+		qb.RawFields[fieldKey] = "raw_" + fieldName(fieldKey)
+	}
+	return &RawValues{qb.RawFields[fieldKey]}
+}
+
 // CreateStatement ...
 // Implementation is taken from influxdb source.
 func (qb *QueryBuilder) CreateStatement(s *influxql.SelectStatement) string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("SELECT ")
-	for field, name := range qb.Fields {
-		_, _ = buf.WriteString(field + " AS " + name + ",")
-	}
-	// Remove comma
-	buf.Truncate(buf.Len() - 1)
+	writeFields(&buf, qb.Fields)
 
 	if s.Target != nil {
 		_, _ = buf.WriteString(" ")
@@ -100,19 +119,26 @@ func (qb *QueryBuilder) CreateStatement(s *influxql.SelectStatement) string {
 }
 
 type QueryTree struct {
-	Fields []QueryField
+	Fields   []QueryField
+	interval time.Duration
+	stateful []Stateful
 }
 
 func NewQueryTree(stmt *influxql.SelectStatement) (*QueryTree, *QueryBuilder, error) {
 	tree := &QueryTree{}
 	tree.Fields = []QueryField{}
+	interval, err := stmt.GroupByInterval()
+	if err != nil {
+		return nil, nil, err
+	}
+	tree.interval = interval
 	qb := NewQueryBuilder()
 	for _, field := range stmt.Fields {
 		if _, isVarRef := field.Expr.(*influxql.VarRef); isVarRef {
 			continue
 		}
 		qField := QueryField{ResponseField: field.Name()}
-		node, err := createQueryNode(field.Expr, qb)
+		node, err := createQueryNode(field.Expr, qb, tree)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -122,12 +148,46 @@ func NewQueryTree(stmt *influxql.SelectStatement) (*QueryTree, *QueryBuilder, er
 	return tree, qb, nil
 }
 
-func createQueryNode(expr influxql.Expr, qb *QueryBuilder) (QueryNode, error) {
+// Reset clears the state of transformations before merging another series.
+func (t *QueryTree) Reset() {
+	for _, n := range t.stateful {
+		n.Reset()
+	}
+}
+
+// HasTransformations returns true if any field depends on previous rows, in which case
+// rows without any values should not be included.
+func (t *QueryTree) HasTransformations() bool {
+	return len(t.stateful) > 0
+}
+
+func (t *QueryTree) addStateful(n Stateful) {
+	t.stateful = append(t.stateful, n)
+}
+
+// durationArg returns the duration in the argument at index i of the call, or def if it is not set.
+func durationArg(call *influxql.Call, i int, def time.Duration) (time.Duration, error) {
+	if len(call.Args) <= i {
+		return def, nil
+	}
+	d, ok := call.Args[i].(*influxql.DurationLiteral)
+	if !ok {
+		return 0, fmt.Errorf("second argument to %s must be a duration, got %T", call.Name, call.Args[i])
+	}
+	return d.Val, nil
+}
+
+func createQueryNode(expr influxql.Expr, qb *QueryBuilder, tree *QueryTree) (QueryNode, error) {
 	var n QueryNode
 	switch f := expr.(type) {
-	// TODO add support for wildcards and regular expressions
+	case *influxql.Wildcard, *influxql.RegexLiteral:
+		// The fields that are matched need to be known to merge them one by one.
+		return nil, fmt.Errorf("wildcards and regular expressions have to be expanded before merging '%s'", f.String())
 	case *influxql.StringLiteral:
 		n = qb.Get(expr.String())
+	case *influxql.VarRef:
+		// Fields are only selected as they are when used in transformations.
+		n = qb.Get(expr.String())
 	case *influxql.Call:
 		switch f.Name {
 		case "mean":
@@ -162,8 +222,66 @@ func createQueryNode(expr influxql.Expr, qb *QueryBuilder) (QueryNode, error) {
 			n = NewMedian(f.Args[0].String(), qb)
 		case "stddev":
 			n = NewStddev(f.Args[0].String(), qb)
-		case "abs", "acos", "asin", "atan", "ceil", "cos", "floor", "log", "log2", "log10", "round", "sin", "sqrt", "tan":
-			qn, err := createQueryNode(f.Args[0], qb)
+		case "derivative", "non_negative_derivative":
+			// The unit is the interval when grouping by time, and otherwise one second.
+			def := tree.interval
+			if def == 0 {
+				def = time.Second
+			}
+			unit, err := durationArg(f, 1, def)
+			if err != nil {
+				return nil, err
+			}
+			qn, err := createQueryNode(f.Args[0], qb, tree)
+			if err != nil {
+				return nil, err
+			}
+			d := NewDerivative(qn, unit, f.Name == "non_negative_derivative")
+			tree.addStateful(d)
+			n = d
+		case "difference", "non_negative_difference":
+			qn, err := createQueryNode(f.Args[0], qb, tree)
+			if err != nil {
+				return nil, err
+			}
+			d := NewDifference(qn, f.Name == "non_negative_difference")
+			tree.addStateful(d)
+			n = d
+		case "cumulative_sum":
+			qn, err := createQueryNode(f.Args[0], qb, tree)
+			if err != nil {
+				return nil, err
+			}
+			c := NewCumulativeSum(qn)
+			tree.addStateful(c)
+			n = c
+		case "elapsed":
+			unit, err := durationArg(f, 1, time.Nanosecond)
+			if err != nil {
+				return nil, err
+			}
+			qn, err := createQueryNode(f.Args[0], qb, tree)
+			if err != nil {
+				return nil, err
+			}
+			e := NewElapsed(qn, unit)
+			tree.addStateful(e)
+			n = e
+		case "pow", "atan2":
+			lhs, err := createQueryNode(f.Args[0], qb, tree)
+			if err != nil {
+				return nil, err
+			}
+			rhs, err := createQueryNode(f.Args[1], qb, tree)
+			if err != nil {
+				return nil, err
+			}
+			n = NewBinaryFunc(lhs, rhs, getBinaryFunc(f.Name))
+		case "histogram":
+			// InfluxDB 1.x has no histogram function, so there are no partial results to merge.
+			return nil, fmt.Errorf("InfluxQL function '%s' is not supported, as InfluxDB can not compute it on the nodes", f.Name)
+		case "abs", "acos", "asin", "atan", "ceil", "cos", "exp", "floor", "ln", "log", "log2", "log10", "round", "sin", "sqrt", "tan":
+			qn, err := createQueryNode(f.Args[0], qb, tree)
 			if err != nil {
 				return nil, err
 			}
@@ -171,8 +289,7 @@ func createQueryNode(expr influxql.Expr, qb *QueryBuilder) (QueryNode, error) {
 		default:
 			/*
 				Not supported:
-				integral, sample, first, last,
-				as well as all some transformations.
+				integral, sample, first, last.
 
 				First and Last can not be supported as ResultSource.Next only return values and
 				not the timestamps of those value. Need a different function than next that also
@@ -183,11 +300,11 @@ func createQueryNode(expr influxql.Expr, qb *QueryBuilder) (QueryNode, error) {
 			return nil, fmt.Errorf("InfluxQL function '%s' is not supported when merging results from multiple hosts.", f.Name)
 		}
 	case *influxql.BinaryExpr:
-		lhs, err := createQueryNode(f.LHS, qb)
+		lhs, err := createQueryNode(f.LHS, qb, tree)
 		if err != nil {
 			return nil, err
 		}
-		rhs, err := createQueryNode(f.RHS, qb)
+		rhs, err := createQueryNode(f.RHS, qb, tree)
 		if err != nil {
 			return nil, err
 		}
@@ -197,7 +314,7 @@ func createQueryNode(expr influxql.Expr, qb *QueryBuilder) (QueryNode, error) {
 	case *influxql.NumberLiteral:
 		n = NewFloatLit(f.Val)
 	case *influxql.ParenExpr:
-		expr, err := createQueryNode(f.Expr, qb)
+		expr, err := createQueryNode(f.Expr, qb, tree)
 		if err != nil {
 			return nil, err
 		}
@@ -218,40 +335,22 @@ func getUnaryOp(name string) func (float64) float64 {
 		return math.Asin
 	case "atan":
 		return math.Atan
-	//case "atan2":
-	//	return math.Atan2
 	case "ceil":
 		return math.Ceil
 	case "cos":
 		return math.Cos
-	//case "cumulative_sum":
-	//	return math.cumulative_sum
-	//case "derivative":
-	//	return math.derivative
-	//case "difference":
-	//	return math.difference
-	//case "elapsed":
-	//	return math.elapsed
-	//case "exp":
-	//	return math.exp
+	case "exp":
+		return math.Exp
 	case "floor":
 		return math.Floor
-	//case "histogram":
-	//	return math.histogram
-	//case "ln":
-	//	return math.Ln10
+	case "ln":
+		return math.Log
 	case "log":
 		return math.Log
 	case "log2":
 		return math.Log2
 	case "log10":
 		return math.Log10
-	//case "non_negative_derivative":
-	//	return math.non_negative_derivative
-	//case "non_negative_difference":
-	//	return math.non_negative_difference
-	//case "pow":
-	//	return math.pow
 	case "round":
 		return math.Round
 	case "sin":
@@ -266,6 +365,38 @@ func getUnaryOp(name string) func (float64) float64 {
 	}
 }
 
+func getBinaryFunc(name string) func(float64, float64) float64 {
+	switch name {
+	case "pow":
+		return math.Pow
+	case "atan2":
+		return math.Atan2
+	}
+	return func(lhs, rhs float64) float64 {
+		return lhs
+	}
+}
+
+// BinaryFunc applies a function with two arguments, such as pow and atan2.
+type BinaryFunc struct {
+	lhs QueryNode
+	rhs QueryNode
+	fn  func(float64, float64) float64
+}
+
+func NewBinaryFunc(lhs, rhs QueryNode, fn func(float64, float64) float64) *BinaryFunc {
+	return &BinaryFunc{lhs, rhs, fn}
+}
+
+func (n *BinaryFunc) Next(source ResultSource) []float64 {
+	lhs := n.lhs.Next(source)
+	rhs := n.rhs.Next(source)
+	if len(lhs) != 1 || len(rhs) != 1 {
+		return nil
+	}
+	return []float64{n.fn(lhs[0], rhs[0])}
+}
+
 type UnaryOp struct {
 	values QueryNode
 	fn func (float64) float64
@@ -333,3 +464,53 @@ func (n *BinaryOp) Next(source ResultSource) []float64 {
 	}
 	return []float64{res}
 }
+
+// CreateRawStatement creates a statement that selects the points of all raw fields in the same series as
+// the statement created by CreateStatement. The points are not grouped by time so that they can be assigned
+// to the intervals when merging. The number of points of each series is limited unless limit is 0. An empty
+// string is returned if there are no raw fields.
+func (qb *QueryBuilder) CreateRawStatement(s *influxql.SelectStatement, limit int) string {
+	if len(qb.RawFields) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("SELECT ")
+	writeFields(&buf, qb.RawFields)
+
+	if len(s.Sources) > 0 {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(s.Sources.String())
+	}
+	if s.Condition != nil {
+		_, _ = buf.WriteString(" WHERE ")
+		_, _ = buf.WriteString(s.Condition.String())
+	}
+	dimensions := influxql.Dimensions{}
+	for _, d := range s.Dimensions {
+		if _, isTime := d.Expr.(*influxql.Call); !isTime {
+			dimensions = append(dimensions, d)
+		}
+	}
+	if len(dimensions) > 0 {
+		_, _ = buf.WriteString(" GROUP BY ")
+		_, _ = buf.WriteString(dimensions.String())
+	}
+	if limit > 0 {
+		_, _ = fmt.Fprintf(&buf, " LIMIT %d", limit)
+	}
+	if s.SLimit > 0 {
+		_, _ = fmt.Fprintf(&buf, " SLIMIT %d", s.SLimit)
+	}
+	if s.SOffset > 0 {
+		_, _ = fmt.Fprintf(&buf, " SOFFSET %d", s.SOffset)
+	}
+	return buf.String()
+}
+
+func writeFields(buf *bytes.Buffer, fields map[string]string) {
+	for field, name := range fields {
+		_, _ = buf.WriteString(field + " AS " + name + ",")
+	}
+	// Remove comma
+	buf.Truncate(buf.Len() - 1)
+}
@@ -0,0 +1,127 @@
+package merge
+
+import (
+	"time"
+)
+
+// Stateful is implemented by nodes that depend on the values in previous rows. They are
+// reset before merging each series so that the state is not carried between them.
+type Stateful interface {
+	Reset()
+}
+
+// Derivative calculates the rate of change between the values of consecutive rows.
+type Derivative struct {
+	values      QueryNode
+	unit        time.Duration
+	nonNegative bool
+	prevTime    int64
+	prevValue   float64
+	hasPrev     bool
+}
+
+func NewDerivative(values QueryNode, unit time.Duration, nonNegative bool) *Derivative {
+	return &Derivative{values: values, unit: unit, nonNegative: nonNegative}
+}
+
+func (n *Derivative) Next(source ResultSource) []float64 {
+	values := n.values.Next(source)
+	t := source.UnixNano()
+	var res []float64
+	for _, v := range values {
+		if n.hasPrev && t != n.prevTime {
+			value := (v - n.prevValue) / (float64(t-n.prevTime) / float64(n.unit))
+			if !n.nonNegative || value >= 0 {
+				res = append(res, value)
+			}
+		}
+		n.prevTime, n.prevValue, n.hasPrev = t, v, true
+	}
+	return res
+}
+
+func (n *Derivative) Reset() {
+	n.hasPrev = false
+}
+
+// Difference calculates the difference between the values of consecutive rows.
+type Difference struct {
+	values      QueryNode
+	nonNegative bool
+	prevValue   float64
+	hasPrev     bool
+}
+
+func NewDifference(values QueryNode, nonNegative bool) *Difference {
+	return &Difference{values: values, nonNegative: nonNegative}
+}
+
+func (n *Difference) Next(source ResultSource) []float64 {
+	var res []float64
+	for _, v := range n.values.Next(source) {
+		if n.hasPrev {
+			value := v - n.prevValue
+			if !n.nonNegative || value >= 0 {
+				res = append(res, value)
+			}
+		}
+		n.prevValue, n.hasPrev = v, true
+	}
+	return res
+}
+
+func (n *Difference) Reset() {
+	n.hasPrev = false
+}
+
+type CumulativeSum struct {
+	values QueryNode
+	sum    float64
+}
+
+func NewCumulativeSum(values QueryNode) *CumulativeSum {
+	return &CumulativeSum{values: values}
+}
+
+func (n *CumulativeSum) Next(source ResultSource) []float64 {
+	var res []float64
+	for _, v := range n.values.Next(source) {
+		n.sum += v
+		res = append(res, n.sum)
+	}
+	return res
+}
+
+func (n *CumulativeSum) Reset() {
+	n.sum = 0
+}
+
+// Elapsed calculates the time between consecutive rows that have a value.
+type Elapsed struct {
+	values   QueryNode
+	unit     time.Duration
+	prevTime int64
+	hasPrev  bool
+}
+
+func NewElapsed(values QueryNode, unit time.Duration) *Elapsed {
+	return &Elapsed{values: values, unit: unit}
+}
+
+func (n *Elapsed) Next(source ResultSource) []float64 {
+	values := n.values.Next(source)
+	if len(values) == 0 {
+		return nil
+	}
+	t := source.UnixNano()
+	var res []float64
+	if n.hasPrev {
+		res = []float64{float64((t - n.prevTime) / int64(n.unit))}
+	}
+	n.prevTime, n.hasPrev = t, true
+	return res
+}
+
+func (n *Elapsed) Reset() {
+	n.hasPrev = false
+}
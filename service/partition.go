@@ -20,9 +20,13 @@ type WriteContext struct {
 
 type partitionValidationError error
 
-func partitionPoints(points []models.Point, partitioner cluster.Partitioner, db string) (map[int][]models.Point, error) {
+// partitionPoints groups the points by the hash of their partition. Points that do not have the tags
+// required by the partition key of their measurement are not included, and their indices are returned
+// together with the reason.
+func partitionPoints(points []models.Point, partitioner cluster.Partitioner, db string) (map[int][]models.Point, map[int]error) {
 	pointGroups := make(map[int][]models.Point)
-	for _, point := range points {
+	rejected := make(map[int]error)
+	for i, point := range points {
 		key, ok := partitioner.GetKeyByMeasurement(db, string(point.Name()))
 		var numericHash int
 		if ok {
@@ -31,12 +35,30 @@ func partitionPoints(points []models.Point, partitioner cluster.Partitioner, db
 				values[string(tag.Key)] = []string{string(tag.Value)}
 			}
 			if !partitioner.FulfillsKey(key, values) {
-				err := partitionValidationError(fmt.Errorf("the partition key for measurement %s requires the tags [%s]",
-						key.Measurement, strings.Join(key.Tags, ", ")))
-				return pointGroups, err
+				rejected[i] = partitionValidationError(fmt.Errorf("the partition key for measurement %s requires the tags [%s]",
+					key.Measurement, strings.Join(key.Tags, ", ")))
+				continue
 			}
 
 			numericHash, _ = cluster.GetHash(key, values)
+			if key.Resharding() {
+				// Points are written by both sets of tags until the key has been changed.
+				migrationKey := key.MigrationKey()
+				if !partitioner.FulfillsKey(migrationKey, values) {
+					rejected[i] = partitionValidationError(fmt.Errorf("the partition key for measurement %s is being changed and requires the tags [%s]",
+						key.Measurement, strings.Join(migrationKey.Tags, ", ")))
+					continue
+				}
+				if migrationHash, _ := cluster.GetHash(migrationKey, values); migrationHash != numericHash {
+					pointGroups[migrationHash] = append(pointGroups[migrationHash], point)
+				}
+			}
+			if key.Draining() {
+				// The data of a key that is being dropped is moved to the nodes of the database, which
+				// therefore also have to receive new points until the key is removed.
+				dbHash := int(hash.String(cluster.CreatePartitionKeyIdentifier(db, "")))
+				pointGroups[dbHash] = append(pointGroups[dbHash], point)
+			}
 		} else {
 			numericHash = int(hash.String(cluster.CreatePartitionKeyIdentifier(db, "")))
 		}
@@ -45,5 +67,5 @@ func partitionPoints(points []models.Point, partitioner cluster.Partitioner, db
 		}
 		pointGroups[numericHash] = append(pointGroups[numericHash], point)
 	}
-	return pointGroups, nil
+	return pointGroups, rejected
 }
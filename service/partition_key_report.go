@@ -0,0 +1,283 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+)
+
+// partitionKeySampleSize is the maximum number of series that are inspected on each node.
+const partitionKeySampleSize = 10000
+
+// partitionKeyReport describes how the existing data would be distributed by a partition key.
+// Series are counted rather than points, as the number of points is too expensive to find, and
+// only a sample of them is inspected if there are many.
+type partitionKeyReport struct {
+	// values is the number of distinct values of each tag of the key.
+	values map[string]int
+	// nodes is the number of series that each node would be the primary owner of.
+	nodes map[string]int
+	// unplaced is the number of series that do not have all tags of the key.
+	unplaced int
+	// problems make the key unusable, while warnings only tell that it may distribute data poorly.
+	problems []string
+	warnings []string
+}
+
+// inspectPartitionKey checks that the tags of the key are tag keys of the measurements that it would
+// be used by, and estimates how their series would be distributed over the nodes.
+func inspectPartitionKey(key cluster.PartitionKey, keys []*cluster.PartitionKey, resolver *cluster.Resolver, client *http.Client, r *http.Request) (*partitionKeyReport, error) {
+	report := &partitionKeyReport{values: map[string]int{}, nodes: map[string]int{}}
+	for _, tag := range key.Tags {
+		report.values[tag] = 0
+	}
+	if resolver == nil {
+		return report, nil
+	}
+
+	var sources influxql.Sources
+	if key.Measurement != "" {
+		sources = influxql.Sources{&influxql.Measurement{Name: key.Measurement}}
+	}
+	// A key on a database is not used by measurements that have a key of their own.
+	uses := func(msmt string) bool {
+		if key.Measurement != "" {
+			return msmt == key.Measurement
+		}
+		for _, pk := range keys {
+			if pk.Database == key.Database && pk.Measurement == msmt {
+				return false
+			}
+		}
+		return true
+	}
+
+	// A node that can not be reached is left out with a warning, as the key would otherwise not be
+	// possible to create while any node is down.
+	unreachable := map[string]bool{}
+	inspect := func(stmt influxql.Statement, location string, fn func(row *models.Row, name string)) error {
+		if unreachable[location] {
+			return nil
+		}
+		err := inspectRows(stmt, location, client, r, fn)
+		if _, ok := err.(unreachableNodeError); ok {
+			unreachable[location] = true
+			report.warnings = append(report.warnings, err.Error())
+			return nil
+		}
+		return err
+	}
+
+	tagKeys := map[string]map[string]bool{}
+	fieldKeys := map[string]map[string]bool{}
+	locations := resolver.FindAll()
+	for _, location := range locations {
+		statements := []influxql.Statement{
+			&influxql.ShowTagKeysStatement{Database: key.Database, Sources: sources},
+			&influxql.ShowFieldKeysStatement{Database: key.Database, Sources: sources},
+		}
+		for i, stmt := range statements {
+			err := inspect(stmt, location, func(row *models.Row, name string) {
+				if i == 0 {
+					addKey(tagKeys, row.Name, name)
+				} else {
+					addKey(fieldKeys, row.Name, name)
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	measurements := []string{}
+	for msmt := range tagKeys {
+		if uses(msmt) {
+			measurements = append(measurements, msmt)
+		}
+	}
+	for msmt := range fieldKeys {
+		if _, ok := tagKeys[msmt]; !ok && uses(msmt) {
+			measurements = append(measurements, msmt)
+		}
+	}
+	sort.Strings(measurements)
+	for _, tag := range key.Tags {
+		missing := []string{}
+		for _, msmt := range measurements {
+			if fieldKeys[msmt][tag] {
+				report.problems = append(report.problems, fmt.Sprintf("%s is a field of measurement %s", tag, msmt))
+			} else if !tagKeys[msmt][tag] {
+				missing = append(missing, msmt)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		if key.Measurement != "" || len(missing) == len(measurements) {
+			report.problems = append(report.problems, fmt.Sprintf("%s is not a tag of %s", tag, strings.Join(missing, ", ")))
+		} else {
+			report.warnings = append(report.warnings, fmt.Sprintf("points of %s would be rejected as they do not have the tag %s",
+				strings.Join(missing, ", "), tag))
+		}
+	}
+	if len(report.problems) > 0 {
+		return report, nil
+	}
+
+	// The series are only needed to estimate the distribution, so a sample of them is enough.
+	seriesKeys := map[string]bool{}
+	sampled := false
+	for _, location := range locations {
+		stmt := &influxql.ShowSeriesStatement{Database: key.Database, Sources: sources, Limit: partitionKeySampleSize}
+		count := 0
+		err := inspect(stmt, location, func(row *models.Row, name string) {
+			seriesKeys[name] = true
+			count++
+		})
+		if err != nil {
+			return nil, err
+		}
+		sampled = sampled || count >= partitionKeySampleSize
+	}
+
+	nodes := resolver.FindAllNodes()
+	for _, node := range nodes {
+		report.nodes[node.Name] = 0
+	}
+	inspected := 0
+	values := map[string]map[string]bool{}
+	combinations := map[string]bool{}
+	for seriesKey := range seriesKeys {
+		msmt, tags := models.ParseKey([]byte(seriesKey))
+		if !uses(msmt) {
+			continue
+		}
+		inspected++
+		tagValues := map[string][]string{}
+		for _, tag := range tags {
+			tagValues[string(tag.Key)] = []string{string(tag.Value)}
+		}
+		hash, err := cluster.GetHash(key, tagValues)
+		if err != nil {
+			report.unplaced++
+			continue
+		}
+		combination := []string{}
+		for _, tag := range key.Tags {
+			addKey(values, tag, tagValues[tag][0])
+			combination = append(combination, tagValues[tag][0])
+		}
+		combinations[strings.Join(combination, "\x00")] = true
+		if node := resolver.FindPrimary(hash); node != nil {
+			report.nodes[node.Name]++
+		}
+	}
+	for tag, v := range values {
+		report.values[tag] = len(v)
+	}
+
+	placed := inspected - report.unplaced
+	if sampled {
+		report.warnings = append(report.warnings, fmt.Sprintf("the distribution is estimated from the first %d series of each node", partitionKeySampleSize))
+	}
+	if report.unplaced > 0 {
+		report.warnings = append(report.warnings, fmt.Sprintf("%d series do not have all tags of the key", report.unplaced))
+	}
+	if placed > 0 && len(combinations) < len(nodes) {
+		report.warnings = append(report.warnings, fmt.Sprintf("the key has only %d distinct values, so some of the %d nodes would not get any data",
+			len(combinations), len(nodes)))
+	}
+	if placed > 0 && len(nodes) > 1 {
+		for _, name := range report.nodeNames() {
+			// A node with more than twice its share of the series is considered to be skewed.
+			if share := float64(report.nodes[name]) / float64(placed); share > 2/float64(len(nodes)) {
+				report.warnings = append(report.warnings, fmt.Sprintf("node %s would have %.0f%% of the series", name, share*100))
+			}
+		}
+	}
+	return report, nil
+}
+
+// unreachableNodeError is returned when a node does not respond, in which case the report is made from
+// the data of the other nodes.
+type unreachableNodeError struct{ error }
+
+// inspectRows calls the function with the first value of every row in the results of the statement on the node.
+// A node that does not have the database has no rows.
+func inspectRows(stmt influxql.Statement, location string, client *http.Client, r *http.Request, fn func(row *models.Row, name string)) error {
+	results, err, resp := request(stmt.String(), location, client, r)
+	if err != nil {
+		err = fmt.Errorf("failed to inspect data at %s: %s", location, err)
+		if resp == nil {
+			return unreachableNodeError{err}
+		}
+		return err
+	}
+	for _, res := range results {
+		if strings.HasPrefix(res.Err, "database not found") {
+			continue
+		}
+		if res.Err != "" {
+			return fmt.Errorf("failed to inspect data at %s: %s", location, res.Err)
+		}
+		for _, row := range res.Series {
+			for _, values := range row.Values {
+				name, _ := values[0].(string)
+				fn(row, name)
+			}
+		}
+	}
+	return nil
+}
+
+func addKey(keys map[string]map[string]bool, group, key string) {
+	if keys[group] == nil {
+		keys[group] = map[string]bool{}
+	}
+	keys[group][key] = true
+}
+
+func (report *partitionKeyReport) nodeNames() []string {
+	names := []string{}
+	for name := range report.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// messages returns the warnings as messages of a result.
+func (report *partitionKeyReport) messages() []*Message {
+	messages := []*Message{}
+	for _, warning := range report.warnings {
+		messages = append(messages, &Message{Level: "warning", Text: warning})
+	}
+	return messages
+}
+
+// results returns the number of values of each tag and of series of each node.
+func (report *partitionKeyReport) results(key cluster.PartitionKey) []Result {
+	tags := &models.Row{Name: "tags", Columns: []string{"tag", "values"}}
+	for _, tag := range key.Tags {
+		tags.Values = append(tags.Values, []interface{}{tag, report.values[tag]})
+	}
+	total := 0
+	for _, count := range report.nodes {
+		total += count
+	}
+	nodes := &models.Row{Name: "nodes", Columns: []string{"node", "series", "percentage"}}
+	for _, name := range report.nodeNames() {
+		percentage := 0.0
+		if total > 0 {
+			percentage = float64(report.nodes[name]) * 100 / float64(total)
+		}
+		nodes.Values = append(nodes.Values, []interface{}{name, report.nodes[name], percentage})
+	}
+	return []Result{{Series: []*models.Row{tags, nodes}, Messages: report.messages()}}
+}
@@ -0,0 +1,370 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+)
+
+// QueryTracker keeps track of the statements that are being handled by the cluster. Every statement
+// gets a cluster query ID, and the IDs that the nodes give the statements that are sent to them on its
+// behalf are recorded so that they can be killed together.
+type QueryTracker struct {
+	mu      sync.Mutex
+	prefix  uint64
+	nextID  uint64
+	queries map[uint64]*trackedQuery
+	client  *http.Client
+}
+
+type trackedQuery struct {
+	id       uint64
+	query    string
+	database string
+	started  time.Time
+	ctx      context.Context
+	cancel   context.CancelFunc
+	tracker  *QueryTracker
+
+	mu    sync.Mutex
+	sent  map[nodeStatement]int
+	nodes map[nodeStatement]uint64
+}
+
+// nodeStatement is a statement that was sent to a node on behalf of a query of the cluster.
+type nodeStatement struct {
+	host      string
+	statement string
+}
+
+// queryIDBits is the number of bits of a cluster query ID that are counted by the tracker. The bits above
+// are taken from the name of the node that handles the query, so that the IDs of different nodes do not collide.
+const queryIDBits = 48
+
+// nodeQueryLookupDelay is how long after a statement was sent to a node that its ID on the node is looked up.
+// Statements of queries that have ended by then are not looked up at all.
+var nodeQueryLookupDelay = 100 * time.Millisecond
+
+// NewQueryTracker creates a tracker for the queries that are handled by the node with the name.
+func NewQueryTracker(name string) *QueryTracker {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return &QueryTracker{
+		prefix:  uint64(h.Sum32()&0xffff) << queryIDBits,
+		nextID:  1,
+		queries: map[uint64]*trackedQuery{},
+		client:  cluster.NewDataClient(10 * time.Second),
+	}
+}
+
+// Start registers a statement and returns a context that is cancelled if the query is killed.
+func (t *QueryTracker) Start(ctx context.Context, query, database string) (context.Context, uint64) {
+	ctx, cancel := context.WithCancel(ctx)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q := &trackedQuery{
+		id:       t.prefix | t.nextID,
+		query:    query,
+		database: database,
+		started:  time.Now(),
+		ctx:      ctx,
+		cancel:   cancel,
+		tracker:  t,
+		sent:     map[nodeStatement]int{},
+		nodes:    map[nodeStatement]uint64{},
+	}
+	t.nextID++
+	t.queries[q.id] = q
+	return context.WithValue(ctx, trackedQueryKey{}, q), q.id
+}
+
+// Finish removes the query and releases its context.
+func (t *QueryTracker) Finish(id uint64) {
+	t.mu.Lock()
+	q, ok := t.queries[id]
+	delete(t.queries, id)
+	t.mu.Unlock()
+	if ok {
+		q.cancel()
+	}
+}
+
+func (t *QueryTracker) get(id uint64) (*trackedQuery, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q, ok := t.queries[id]
+	return q, ok
+}
+
+// owns returns true if the ID is of a query that was started by this tracker, whether it is still running or not.
+func (t *QueryTracker) owns(id uint64) bool {
+	return id&^(1<<queryIDBits-1) == t.prefix
+}
+
+func (t *QueryTracker) list() []*trackedQuery {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	queries := make([]*trackedQuery, 0, len(t.queries))
+	for _, q := range t.queries {
+		queries = append(queries, q)
+	}
+	sort.Slice(queries, func(i, j int) bool { return queries[i].id < queries[j].id })
+	return queries
+}
+
+// started returns true if the query on the host was started by a query of the cluster.
+func (t *QueryTracker) started(host string, qid uint64) bool {
+	for _, q := range t.list() {
+		if q.sentTo(host, qid) {
+			return true
+		}
+	}
+	return false
+}
+
+type trackedQueryKey struct{}
+
+// trackNodeQuery returns the context of a request of the statement to the host. If the statement is sent on
+// behalf of a query of the cluster, the ID that the node gives it is looked up shortly after it has been sent.
+// A statement without chunks has ended on the node once the response has started, so it is not looked up then.
+func trackNodeQuery(r *http.Request, host, statement string) context.Context {
+	ctx := r.Context()
+	q, ok := ctx.Value(trackedQueryKey{}).(*trackedQuery)
+	if !ok {
+		return ctx
+	}
+	chunked := r.URL.Query().Get("chunked") == "true"
+	var once sync.Once
+	var responded int32
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err != nil {
+				return
+			}
+			once.Do(func() {
+				q.send(host, statement)
+				sent := time.Now()
+				time.AfterFunc(nodeQueryLookupDelay, func() {
+					if q.ctx.Err() == nil && (chunked || atomic.LoadInt32(&responded) == 0) {
+						q.lookup(host, statement, sent, r)
+					}
+				})
+			})
+		},
+		GotFirstResponseByte: func() {
+			atomic.StoreInt32(&responded, 1)
+		},
+	})
+}
+
+// send records that the statement has been sent to the host.
+func (q *trackedQuery) send(host, statement string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sent[nodeStatement{host, statement}]++
+}
+
+// lookup finds the ID of the statement that was sent to the host and records it. The ID is only recorded if
+// no other query of the cluster has sent the same statement to the host, and if exactly one query on the node
+// with the statement started after it was sent and is not recorded for another query. Otherwise the query on
+// the node can not be told apart from others, and it is left without an ID rather than risking the wrong one.
+func (q *trackedQuery) lookup(host, statement string, sent time.Time, r *http.Request) {
+	nodeQueries, _, err := showNodeQueries([]string{host}, q.tracker.client, r.WithContext(context.Background()))
+	if err != nil {
+		log.Printf("failed to find query on %s: %s", host, err)
+		return
+	}
+	elapsed := time.Since(sent)
+	key := nodeStatement{host, statement}
+	q.tracker.mu.Lock()
+	defer q.tracker.mu.Unlock()
+	senders := 0
+	for _, other := range q.tracker.queries {
+		senders += other.sentCount(key)
+	}
+	candidates := []uint64{}
+	for _, nq := range nodeQueries {
+		if nq.query != statement || nq.duration > elapsed {
+			continue
+		}
+		claimed := false
+		for _, other := range q.tracker.queries {
+			if other.sentTo(host, nq.qid) {
+				claimed = true
+			}
+		}
+		if !claimed {
+			candidates = append(candidates, nq.qid)
+		}
+	}
+	if senders != 1 || len(candidates) != 1 {
+		log.Printf("the ID of query %d on %s is not known, as it can not be told apart from other queries on the node", q.id, host)
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nodes[key] = candidates[0]
+}
+
+func (q *trackedQuery) sentCount(key nodeStatement) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.sent[key]
+}
+
+func (q *trackedQuery) sentTo(host string, qid uint64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for key, id := range q.nodes {
+		if key.host == host && id == qid {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeQueries returns the IDs of the queries on the nodes by host.
+func (q *trackedQuery) nodeQueries() map[string][]uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queries := map[string][]uint64{}
+	for key, qid := range q.nodes {
+		queries[key.host] = append(queries[key.host], qid)
+	}
+	return queries
+}
+
+// unknownNodes returns the hosts that statements were sent to whose IDs on the node are not known.
+func (q *trackedQuery) unknownNodes() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	unknown := map[string]bool{}
+	for key := range q.sent {
+		if _, ok := q.nodes[key]; !ok {
+			unknown[key.host] = true
+		}
+	}
+	hosts := []string{}
+	for host := range unknown {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// nodeQuery is a query that is running on a node.
+type nodeQuery struct {
+	host     string
+	qid      uint64
+	query    string
+	duration time.Duration
+	row      []interface{}
+}
+
+// showNodeQueries asks every node for its running queries.
+func showNodeQueries(locations []string, client *http.Client, r *http.Request) ([]nodeQuery, []string, error) {
+	queries := []nodeQuery{}
+	var columns []string
+	for _, location := range locations {
+		results, err, _ := request((&influxql.ShowQueriesStatement{}).String(), location, client, r)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, res := range results {
+			for _, series := range res.Series {
+				columns = series.Columns
+				for _, row := range series.Values {
+					qid, _ := toFloat(row[0])
+					query, _ := row[1].(string)
+					var duration time.Duration
+					if len(row) > 3 {
+						text, _ := row[3].(string)
+						duration, _ = time.ParseDuration(text)
+					}
+					queries = append(queries, nodeQuery{location, uint64(qid), query, duration, row})
+				}
+			}
+		}
+	}
+	return queries, columns, nil
+}
+
+// RouteShowQueries lists the queries of the cluster, together with the queries on each node that were
+// not started by the cluster. Queries of the cluster have no host, and can be killed on all nodes at once.
+func RouteShowQueries(tracker *QueryTracker, resolver *cluster.Resolver, client *http.Client) RoutingFunc {
+	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement, flusher ResultFlusher) ([]Result, error) {
+		nodeQueries, _, err := showNodeQueries(resolver.FindAll(), client, r)
+		if err != nil {
+			return nil, respondWithCoordinationError(w, err, nil)
+		}
+		tracked := tracker.list()
+
+		row := &models.Row{Columns: []string{"qid", "query", "database", "duration", "status", "host"}}
+		for _, q := range tracked {
+			duration := time.Since(q.started).Truncate(time.Millisecond)
+			row.Values = append(row.Values, []interface{}{q.id, q.query, q.database, duration.String(), "running", ""})
+		}
+		for _, nq := range nodeQueries {
+			if tracker.started(nq.host, nq.qid) {
+				continue
+			}
+			values := []interface{}{nq.qid, nq.query, nil, nil, nil, nq.host}
+			for i := 2; i < len(nq.row) && i < 5; i++ {
+				values[i] = nq.row[i]
+			}
+			row.Values = append(row.Values, values)
+		}
+		return []Result{{Series: []*models.Row{row}}}, nil
+	}
+}
+
+// RouteKillQuery kills a query of the cluster by cancelling its requests and killing the queries that
+// it started on the nodes. If a host is given, the query is instead killed on that node only.
+func RouteKillQuery(tracker *QueryTracker, resolver *cluster.Resolver, client *http.Client) RoutingFunc {
+	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement, flusher ResultFlusher) ([]Result, error) {
+		kill := stmt.(*influxql.KillQueryStatement)
+		if kill.Host != "" {
+			results, err, res := request((&influxql.KillQueryStatement{QueryID: kill.QueryID}).String(), kill.Host, client, r)
+			if err != nil {
+				return nil, respondWithCoordinationError(w, err, res)
+			}
+			return results, nil
+		}
+
+		q, ok := tracker.get(kill.QueryID)
+		if !ok {
+			err := fmt.Errorf("no such query id: %d", kill.QueryID)
+			if !tracker.owns(kill.QueryID) {
+				err = fmt.Errorf("no such query id: %d, it has to be killed on the node of the cluster that handles it", kill.QueryID)
+			}
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return nil, err
+		}
+		q.cancel()
+		for host, qids := range q.nodeQueries() {
+			for _, qid := range qids {
+				if _, err, _ := request((&influxql.KillQueryStatement{QueryID: qid}).String(), host, client, r); err != nil {
+					// The query may already have been stopped by the cancelled request.
+					log.Printf("failed to kill query %d on %s: %s", qid, host, err)
+				}
+			}
+		}
+		result := Result{}
+		for _, host := range q.unknownNodes() {
+			result.Messages = append(result.Messages, &Message{Level: "warning", Text: fmt.Sprintf(
+				"the query on %s can not be killed as its ID on the node is not known, only its request was cancelled", host)})
+		}
+		return []Result{result}, nil
+	}
+}
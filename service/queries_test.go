@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxql"
+	"github.com/stretchr/testify/assert"
+)
+
+func newQueriesNode(t *testing.T, kills chan<- string) *httptest.Server {
+	return newFakeNodeFunc(t, func(q string) string {
+		if strings.HasPrefix(q, "KILL QUERY") {
+			kills <- q
+			return `{"results":[{"statement_id":0}]}`
+		}
+		return `{"results":[{"statement_id":0,"series":[{"columns":["qid","query","database","duration","status"],"values":[` +
+			`[6,"SELECT value FROM sharded..treasures","sharded","1h","running"],` +
+			`[7,"SELECT value FROM sharded..treasures","sharded","1s","running"],` +
+			`[8,"SELECT * FROM other","sharded","2s","running"]]}]}]}`
+	})
+}
+
+// startNodeQuery starts a query of the cluster and records the query that it sent to the node two seconds ago.
+func startNodeQuery(t *testing.T, tracker *QueryTracker, location string) (context.Context, *trackedQuery) {
+	ctx, id := tracker.Start(context.Background(), "SELECT value FROM treasures", testDB)
+	q, ok := tracker.get(id)
+	assert.True(t, ok)
+	q.send(location, "SELECT value FROM sharded..treasures")
+	q.lookup(location, "SELECT value FROM sharded..treasures", time.Now().Add(-2*time.Second), newQueryRequest("SELECT value FROM treasures"))
+	return ctx, q
+}
+
+func TestQueryTracker(t *testing.T) {
+	node := newQueriesNode(t, nil)
+	defer node.Close()
+	location := strings.TrimPrefix(node.URL, "http://")
+
+	tracker := NewQueryTracker("node-a")
+	ctx, q := startNodeQuery(t, tracker, location)
+	// The query that was running before the statement was sent is of another client.
+	assert.Equal(t, map[string][]uint64{location: {7}}, q.nodeQueries())
+	assert.Empty(t, q.unknownNodes())
+	// Another query that sends the same statement to the node can not be told apart from the first one.
+	_, other := startNodeQuery(t, tracker, location)
+	assert.Empty(t, other.nodeQueries())
+	assert.Equal(t, []string{location}, other.unknownNodes())
+	assert.True(t, tracker.started(location, 7))
+	assert.False(t, tracker.started(location, 6))
+
+	assert.True(t, tracker.owns(q.id))
+	assert.False(t, NewQueryTracker("node-b").owns(q.id))
+
+	tracker.Finish(q.id)
+	tracker.Finish(other.id)
+	assert.Empty(t, tracker.list())
+	assert.Error(t, ctx.Err())
+}
+
+func TestRouteKillQuery(t *testing.T) {
+	kills := make(chan string, 2)
+	node := newQueriesNode(t, kills)
+	defer node.Close()
+	location := strings.TrimPrefix(node.URL, "http://")
+
+	tracker := NewQueryTracker("node-a")
+	ctx, q := startNodeQuery(t, tracker, location)
+
+	route := RouteKillQuery(tracker, newFakeCluster(node), &http.Client{})
+	stmt := &influxql.KillQueryStatement{QueryID: q.id}
+	results, err := route(httptest.NewRecorder(), newQueryRequest(stmt.String()), stmt, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, results[0].Messages)
+	assert.Equal(t, context.Canceled, ctx.Err())
+
+	// A query whose ID on the node is not known is reported instead of killing another one.
+	ctx, id := tracker.Start(context.Background(), "SELECT value FROM treasures", testDB)
+	unknown, _ := tracker.get(id)
+	unknown.send(location, "SELECT value FROM sharded..treasures")
+	stmt = &influxql.KillQueryStatement{QueryID: id}
+	results, err = route(httptest.NewRecorder(), newQueryRequest(stmt.String()), stmt, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, context.Canceled, ctx.Err())
+	assert.Len(t, results[0].Messages, 1)
+	assert.Contains(t, results[0].Messages[0].Text, "can not be killed")
+	close(kills)
+	killed := []string{}
+	for q := range kills {
+		killed = append(killed, q)
+	}
+	// Only the query that was started by the cluster is killed.
+	assert.Equal(t, []string{"KILL QUERY 7"}, killed)
+
+	w := httptest.NewRecorder()
+	stmt = &influxql.KillQueryStatement{QueryID: 100}
+	_, err = route(w, newQueryRequest(stmt.String()), stmt, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRouteShowQueries(t *testing.T) {
+	node := newQueriesNode(t, nil)
+	defer node.Close()
+	location := strings.TrimPrefix(node.URL, "http://")
+
+	tracker := NewQueryTracker("node-a")
+	_, q := startNodeQuery(t, tracker, location)
+	defer tracker.Finish(q.id)
+
+	route := RouteShowQueries(tracker, newFakeCluster(node), &http.Client{})
+	stmt := &influxql.ShowQueriesStatement{}
+	results, err := route(httptest.NewRecorder(), newQueryRequest(stmt.String()), stmt, nil)
+	assert.NoError(t, err)
+	values := results[0].Series[0].Values
+	assert.Len(t, values, 3)
+	assert.Equal(t, q.id, values[0][0])
+	assert.Equal(t, "", values[0][5])
+	assert.Equal(t, []interface{}{uint64(6), uint64(8)}, []interface{}{values[1][0], values[2][0]})
+	assert.Equal(t, location, values[1][5])
+}
@@ -1,10 +1,11 @@
 package service
 
 import (
+	"context"
 	"github.com/adamringhede/influxdb-ha/cluster"
 	"github.com/influxdata/influxql"
+	"log"
 	"net/http"
-	"time"
 )
 
 const defaultDB = "default"
@@ -16,16 +17,19 @@ type QueryHandler struct {
 	clusterHandler *ClusterHandler
 	authService    AuthService
 	routeFactory   *RoutingStrategyFactory
+	tracker        *QueryTracker
 }
 
 func NewQueryHandler(resolver *cluster.Resolver, partitioner cluster.Partitioner,
 	clusterHandler *ClusterHandler, authService AuthService) *QueryHandler {
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	routeFactory := &RoutingStrategyFactory{resolver, partitioner, authService, client}
+	// Requests end at the deadline of the query, which is set by route.
+	client := cluster.NewDataClient(0)
+	tracker := NewQueryTracker("")
+	routeFactory := &RoutingStrategyFactory{resolver, partitioner, authService, client, NewFieldKeyCache(), tracker, QueryOptions{}}
 
 	return &QueryHandler{client, resolver, partitioner,
-		clusterHandler, authService, routeFactory}
+		clusterHandler, authService, routeFactory, tracker}
 }
 
 func (h *QueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -55,16 +59,19 @@ func (h *QueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var allResults []Result
-
-	for _, stmt := range q.Statements {
+	flusher := NewResultFlusher(w, r)
+	for i, stmt := range q.Statements {
 		if route := h.routeFactory.Build(stmt, db); route != nil {
-			results, routeErr := route(w, r, stmt)
+			flusher.SetStatementID(i)
+			results, routeErr := h.route(route, w, r, stmt, db, flusher)
 			if routeErr != nil {
 				// Assuming the routing has passed back an appropriate error message
 				return
 			}
-			allResults = append(allResults, results...)
+			if err := flusher.Write(results...); err != nil {
+				log.Println(err)
+				return
+			}
 		} else {
 			// Not supported. Client must connect to the individual data node.
 			jsonError(w, 400, "Statement is not supported on cluster: "+stmt.String())
@@ -81,10 +88,35 @@ func (h *QueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// TODO replace with a dynamic ResultFlusher that can either save all
-	// results in memory and flushes in the end, or flushes every chunk received.
-	respondWithResults(w, allResults)
+	if err := flusher.Flush(); err != nil {
+		log.Println(err)
+	}
+}
+
+// UseTracker sets the tracker of the queries that are handled, which should be named after the node so
+// that the IDs of its queries are unique in the cluster.
+func (h *QueryHandler) UseTracker(tracker *QueryTracker) {
+	h.tracker = tracker
+	h.routeFactory.tracker = tracker
+}
 
+// UseOptions sets the deadline of queries and how they are sent to the data nodes.
+func (h *QueryHandler) UseOptions(options QueryOptions) {
+	h.routeFactory.options = options
+}
+
+// route runs the route of a statement as a query of the cluster, so that it is listed by SHOW QUERIES and can be killed.
+func (h *QueryHandler) route(route RoutingFunc, w http.ResponseWriter, r *http.Request, stmt influxql.Statement, db string, flusher ResultFlusher) ([]Result, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.routeFactory.options.withDefaults().Timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+	switch stmt.(type) {
+	case *influxql.ShowQueriesStatement, *influxql.KillQueryStatement:
+		return route(w, r, stmt, flusher)
+	}
+	ctx, id := h.tracker.Start(r.Context(), stmt.String(), db)
+	defer h.tracker.Finish(id)
+	return route(w, r.WithContext(ctx), stmt, flusher)
 }
 
 func (h *QueryHandler) checkAccess(w http.ResponseWriter, r *http.Request, q *influxql.Query, db string) bool {
@@ -1,10 +1,11 @@
 package service
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/adamringhede/influxdb-ha/cluster"
 	"github.com/influxdata/influxdb/models"
 	"io"
 	"io/ioutil"
@@ -31,18 +32,21 @@ type response struct {
 	Results []Result `json:"results"`
 }
 
-func parseResp(body io.Reader, chunked bool) response {
+// parseResp decodes every response in the body, of which there are several if it is chunked.
+func parseResp(body io.Reader, chunked bool) (response, error) {
 	fullResponse := response{}
-	scanner := bufio.NewScanner(body)
-	for scanner.Scan() {
+	decoder := json.NewDecoder(body)
+	for {
 		var r response
-		err := json.Unmarshal([]byte(scanner.Text()), &r)
+		err := decoder.Decode(&r)
+		if err == io.EOF {
+			return fullResponse, nil
+		}
 		if err != nil {
-			log.Panic(err)
+			return fullResponse, err
 		}
 		fullResponse.Results = append(fullResponse.Results, r.Results...)
 	}
-	return fullResponse
 }
 
 func passBack(w http.ResponseWriter, res *http.Response) {
@@ -101,22 +105,56 @@ func handleErrorWithCode(w http.ResponseWriter, err error, code int) {
 	}
 }
 
-func request(statement string, host string, client *http.Client, r *http.Request) ([]Result, error, *http.Response) {
-	baseUrl, _ := url.Parse("http://" + host + r.URL.Path)
+// newNodeRequest creates a request for the statement to the host with the same parameters as
+// the original request. The body is not forwarded as the statement is in the query string and
+// the same request may be used for several nodes at the same time.
+func newNodeRequest(statement string, host string, r *http.Request) (*http.Request, error) {
+	baseUrl, _ := url.Parse(cluster.DataURL(host) + r.URL.Path)
 	queryValues := r.URL.Query()
 	queryValues.Set("q", statement)
+	if _, ok := cluster.GetDataCredentials(host); ok {
+		// The credentials of the client are for the cluster and not for the data node.
+		queryValues.Del("u")
+		queryValues.Del("p")
+	}
 	baseUrl.RawQuery = queryValues.Encode()
-	res, err := client.Post(baseUrl.String(), "", r.Body)
+	req, err := http.NewRequest("POST", baseUrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	cluster.AuthorizeDataRequest(req, host)
+	return req.WithContext(trackNodeQuery(r, host, statement)), nil
+}
+
+func request(statement string, host string, client *http.Client, r *http.Request) ([]Result, error, *http.Response) {
 	results := []Result{}
+	req, err := newNodeRequest(statement, host, r)
+	if err != nil {
+		return results, err, nil
+	}
+	res, err := client.Do(req)
 	if err != nil {
 		log.Println(err)
 		return results, err, res
 	}
+	defer res.Body.Close()
+	// Reading the entire body first makes sure that a response which was cut off,
+	// for example due to a cancelled request, is not taken as a complete one.
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return results, err, nil
+	}
 	if res.StatusCode/100 != 2 {
-		return results, errors.New(parseErrorResponseMessage(res)), res
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		message := parseErrorResponseMessage(res)
+		// The body is kept so that the error can be passed back to the client after the response is closed.
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return results, errors.New(message), res
+	}
+	response, err := parseResp(bytes.NewReader(body), isChunked(r))
+	if err != nil {
+		return results, fmt.Errorf("failed to parse response from %s: %s", host, err), nil
 	}
-	chunked := r.URL.Query().Get("chunked") == "true"
-	response := parseResp(res.Body, chunked)
 	return response.Results, nil, res
 }
 
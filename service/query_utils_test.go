@@ -0,0 +1,62 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewNodeRequest_DataCredentials(t *testing.T) {
+	r := httptest.NewRequest("GET", "/query?db="+testDB+"&u=admin&p=secret", nil)
+
+	// Without credentials for the data node, the request is forwarded as is.
+	req, err := newNodeRequest("SHOW DATABASES", "influx-1:8086", r)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", req.URL.Query().Get("u"))
+	_, _, ok := req.BasicAuth()
+	assert.False(t, ok)
+
+	cluster.SetDataCredentials(&cluster.DataCredentials{Default: &cluster.Credentials{Username: "cluster", Password: "pass"}})
+	defer cluster.SetDataCredentials(&cluster.DataCredentials{})
+	req, err = newNodeRequest("SHOW DATABASES", "influx-1:8086", r)
+	assert.NoError(t, err)
+	assert.Empty(t, req.URL.Query().Get("u"))
+	assert.Empty(t, req.URL.Query().Get("p"))
+	username, password, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "cluster", username)
+	assert.Equal(t, "pass", password)
+}
+
+func TestRequest_ErrorBody(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonError(w, http.StatusBadRequest, "database not found: nope")
+	}))
+	defer node.Close()
+
+	_, err, res := request("SHOW MEASUREMENTS", strings.TrimPrefix(node.URL, "http://"), &http.Client{}, newQueryRequest("SHOW MEASUREMENTS"))
+	assert.EqualError(t, err, "database not found: nope")
+	// The response is closed, but its body can still be passed back to the client.
+	body, readErr := ioutil.ReadAll(res.Body)
+	assert.NoError(t, readErr)
+	assert.Contains(t, string(body), "database not found: nope")
+}
+
+func TestParseResp_LongLines(t *testing.T) {
+	long := strings.Repeat("x", 100000)
+	body := `{"results":[{"statement_id":0,"series":[{"name":"cpu","columns":["time","value"],"values":[[1,"` + long + `"]],"partial":true}],"partial":true}]}
+{"results":[{"statement_id":0,"series":[{"name":"cpu","columns":["time","value"],"values":[[2,"` + long + `"]]}]}]}
+`
+	parsed, err := parseResp(strings.NewReader(body), true)
+	assert.NoError(t, err)
+	assert.Len(t, parsed.Results, 2)
+	assert.Equal(t, long, parsed.Results[1].Series[0].Values[0][1])
+
+	_, err = parseResp(strings.NewReader(`{"results":[`), false)
+	assert.Error(t, err)
+}
@@ -8,10 +8,12 @@ import (
 	"net/http"
 )
 
-type RoutingFunc func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement) ([]Result, error)
+// RoutingFunc executes a statement and returns its results. Routes that stream results write
+// them to the flusher instead. If an error is returned, it has already been written to w.
+type RoutingFunc func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement, flusher ResultFlusher) ([]Result, error)
 
 func RouteToAll(resolver *cluster.Resolver, client *http.Client) RoutingFunc {
-	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement) ([]Result, error) {
+	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement, flusher ResultFlusher) ([]Result, error) {
 		// TODO In case one request fails, store in a log in peristent storage so that the command can be replayed in order
 		// If it is a delete request, it is important that it is applied before recovering new writes.
 		// All these requests which are mutable, should be added to a log that all nodes schould read from the first thing they do on startup after the local influxdb process is reachable.
@@ -41,7 +43,7 @@ func RouteToAll(resolver *cluster.Resolver, client *http.Client) RoutingFunc {
 }
 
 func RouteToFirstAvailable(resolver *cluster.Resolver, client *http.Client) RoutingFunc {
-	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement) ([]Result, error) {
+	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement, flusher ResultFlusher) ([]Result, error) {
 		all := resolver.FindAll()
 		for ri, location := range all {
 			// Try requesting every single replica. Only if last one fails
@@ -57,26 +59,42 @@ func RouteToFirstAvailable(resolver *cluster.Resolver, client *http.Client) Rout
 	}
 }
 
-func RouteWithCoordination(resolver *cluster.Resolver, partitioner cluster.Partitioner, db string) RoutingFunc {
-	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement) ([]Result, error) {
-		c := &Coordinator{resolver, partitioner}
+func RouteWithCoordination(resolver *cluster.Resolver, partitioner cluster.Partitioner, fieldKeys *FieldKeyCache, options QueryOptions, db string) RoutingFunc {
+	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement, flusher ResultFlusher) ([]Result, error) {
+		c := NewCoordinatorWithOptions(resolver, partitioner, options)
+		c.fieldKeys = fieldKeys
+		if isChunked(r) {
+			err, res := c.HandleChunked(stmt.(*influxql.SelectStatement), r, db, flusher)
+			if err != nil && flusher.Started() {
+				// The headers have already been sent with the chunks of earlier results.
+				log.Println(err)
+				return nil, flusher.Write(Result{Err: err.Error()})
+			}
+			if err != nil {
+				return nil, respondWithCoordinationError(w, err, res)
+			}
+			return nil, nil
+		}
 		results, err, res := c.Handle(stmt.(*influxql.SelectStatement), r, db)
 		if err != nil {
-			log.Println(err)
-			if res != nil {
-				passBack(w, res)
-			} else {
-				jsonError(w, http.StatusBadRequest, err.Error())
-				return nil, fmt.Errorf("bad request")
-			}
+			return nil, respondWithCoordinationError(w, err, res)
 		}
-		// TODO Add support for chunked results, in which case we should stream data in chunks
 		return results, nil
 	}
 }
 
+func respondWithCoordinationError(w http.ResponseWriter, err error, res *http.Response) error {
+	log.Println(err)
+	if res != nil {
+		passBack(w, res)
+	} else {
+		jsonError(w, http.StatusBadRequest, err.Error())
+	}
+	return fmt.Errorf("bad request")
+}
+
 func RouteAuthService(authService AuthService) RoutingFunc {
-	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement) ([]Result, error) {
+	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement, flusher ResultFlusher) ([]Result, error) {
 		if results, err := HandleAuthStatement(stmt, authService); err != nil {
 			handleBadRequestError(w, err)
 			return nil, fmt.Errorf("bad request")
@@ -91,6 +109,9 @@ type RoutingStrategyFactory struct {
 	partitioner cluster.Partitioner
 	authService AuthService
 	client      *http.Client
+	fieldKeys   *FieldKeyCache
+	tracker     *QueryTracker
+	options     QueryOptions
 }
 
 func (rsf *RoutingStrategyFactory) Build(stmt influxql.Statement, db string) RoutingFunc {
@@ -111,7 +132,6 @@ func (rsf *RoutingStrategyFactory) Build(stmt influxql.Statement, db string) Rou
 		return RouteToAll(rsf.resolver, rsf.client)
 
 	case *influxql.DropShardStatement,
-		*influxql.KillQueryStatement,
 		*influxql.ShowShardGroupsStatement,
 		*influxql.ShowShardsStatement,
 		*influxql.ShowStatsStatement,
@@ -129,13 +149,17 @@ func (rsf *RoutingStrategyFactory) Build(stmt influxql.Statement, db string) Rou
 
 	case *influxql.ShowMeasurementsStatement,
 		*influxql.ShowSeriesStatement,
-		*influxql.ShowTagValuesStatement,
-		*influxql.ShowQueriesStatement:
-		// TODO implement merging of results
-		return RouteToFirstAvailable(rsf.resolver, rsf.client)
+		*influxql.ShowTagValuesStatement:
+		return RouteWithMerge(rsf.resolver, rsf.partitioner, rsf.options, rsf.client)
+
+	case *influxql.ShowQueriesStatement:
+		return RouteShowQueries(rsf.tracker, rsf.resolver, rsf.client)
+
+	case *influxql.KillQueryStatement:
+		return RouteKillQuery(rsf.tracker, rsf.resolver, rsf.client)
 
 	case *influxql.SelectStatement:
-		return RouteWithCoordination(rsf.resolver, rsf.partitioner, db)
+		return RouteWithCoordination(rsf.resolver, rsf.partitioner, rsf.fieldKeys, rsf.options, db)
 
 	case *influxql.CreateUserStatement,
 		*influxql.DropUserStatement,
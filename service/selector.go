@@ -0,0 +1,170 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+)
+
+// selectorCall returns the top or bottom call in the statement and the index of the column
+// that it results in. InfluxDB does not allow them to be combined with other functions.
+func selectorCall(stmt *influxql.SelectStatement) (*influxql.Call, int) {
+	for i, field := range stmt.Fields {
+		if call, ok := field.Expr.(*influxql.Call); ok && (call.Name == "top" || call.Name == "bottom") {
+			// The first column is the time.
+			return call, i + 1
+		}
+	}
+	return nil, 0
+}
+
+// handleSelector merges the results of top and bottom. Every node returns the points that it
+// would select together with their time and other columns, so the final points are selected
+// from those in each interval.
+func (c *Coordinator) handleSelector(stmt *influxql.SelectStatement, call *influxql.Call, column int, r *http.Request,
+	hashes []int, scope cluster.ReplicationScope, pKey cluster.PartitionKey, client *http.Client) ([]Result, error, *http.Response) {
+
+	limit, ok := call.Args[len(call.Args)-1].(*influxql.IntegerLiteral)
+	if !ok {
+		return []Result{}, fmt.Errorf("expected integer as last argument in %s(), found %s", call.Name, call.Args[len(call.Args)-1]), nil
+	}
+	// The form top(field, tag, N) selects at most one point for each value of the tag.
+	var tag string
+	if len(call.Args) == 3 {
+		ref, ok := call.Args[1].(*influxql.VarRef)
+		if !ok {
+			return []Result{}, fmt.Errorf("expected tag as second argument in %s(), found %s", call.Name, call.Args[1]), nil
+		}
+		tag = ref.Val
+	}
+	window, err := newTimeWindow(stmt, r.URL.Query().Get("epoch"))
+	if err != nil {
+		return []Result{}, err, nil
+	}
+
+	// Limit and offset apply to the selected points and not to those of each node.
+	pushed := withPartitionDimensions(stmt, pKey).Clone()
+	pushed.Limit, pushed.Offset = 0, 0
+	allResults, owners, err, response := c.performQuery(pushed.String(), withoutEpoch(r), hashes, scope, client)
+	if err != nil {
+		return []Result{}, err, nil
+	}
+
+	groupedResults := groupResultsByTags(c.ownedResults(allResults, owners, pKey, stmt))
+	mergedResults := []Result{}
+	for _, key := range sortedGroupKeys(groupedResults) {
+		group := groupedResults[key]
+		first := group[0].Series[0]
+		tagColumn := -1
+		for i, col := range first.Columns {
+			if tag != "" && col == tag {
+				tagColumn = i
+			}
+		}
+		points, err := selectorPoints(group, column, window)
+		if err != nil {
+			return []Result{}, err, nil
+		}
+		values := [][]interface{}{}
+		for _, interval := range sortedIntervals(points) {
+			selected := selectPoints(points[interval], call.Name == "top", int(limit.Val), tagColumn)
+			for _, p := range selected {
+				row := append([]interface{}{window.format(p.time)}, p.row[1:]...)
+				values = append(values, row)
+			}
+		}
+		if !stmt.TimeAscending() {
+			for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+				values[i], values[j] = values[j], values[i]
+			}
+		}
+		values = limitValues(values, stmt.Limit, stmt.Offset)
+		mergedResults = append(mergedResults, Result{Series: []*models.Row{{
+			Name:    first.Name,
+			Tags:    first.Tags,
+			Columns: first.Columns,
+			Values:  values,
+		}}})
+	}
+	return combineResults(mergedResults), nil, response
+}
+
+type selectorPoint struct {
+	time  time.Time
+	value float64
+	row   []interface{}
+}
+
+// selectorPoints groups the points in the results by the interval that they are in.
+func selectorPoints(results []Result, column int, window timeWindow) (map[time.Time][]selectorPoint, error) {
+	points := map[time.Time][]selectorPoint{}
+	for _, res := range results {
+		for _, series := range res.Series {
+			for _, row := range series.Values {
+				value, ok := toFloat(row[column])
+				if !ok {
+					continue
+				}
+				t, err := parseTime(row[0])
+				if err != nil {
+					return nil, err
+				}
+				interval := window.startOf(t)
+				points[interval] = append(points[interval], selectorPoint{t, value, row})
+			}
+		}
+	}
+	return points, nil
+}
+
+func sortedIntervals(points map[time.Time][]selectorPoint) []time.Time {
+	intervals := make([]time.Time, 0, len(points))
+	for interval := range points {
+		intervals = append(intervals, interval)
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].Before(intervals[j]) })
+	return intervals
+}
+
+// selectPoints returns the n highest or lowest points sorted by time. Points with equal values are
+// selected by time like in InfluxDB. If tagColumn is set, only the first point of each tag value is used.
+func selectPoints(points []selectorPoint, top bool, n int, tagColumn int) []selectorPoint {
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].value != points[j].value {
+			return (points[i].value > points[j].value) == top
+		}
+		return points[i].time.Before(points[j].time)
+	})
+	selected := []selectorPoint{}
+	seenTags := map[interface{}]bool{}
+	for _, p := range points {
+		if len(selected) == n {
+			break
+		}
+		if tagColumn >= 0 {
+			if seenTags[p.row[tagColumn]] {
+				continue
+			}
+			seenTags[p.row[tagColumn]] = true
+		}
+		selected = append(selected, p)
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].time.Before(selected[j].time) })
+	return selected
+}
+
+func limitValues(values [][]interface{}, limit, offset int) [][]interface{} {
+	if offset >= len(values) {
+		return [][]interface{}{}
+	}
+	values = values[offset:]
+	if limit > 0 && limit < len(values) {
+		values = values[:limit]
+	}
+	return values
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoordinator_TopPerInterval(t *testing.T) {
+	queries := make(chan string, 2)
+	one := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","tags":{"type":"trash"},"columns":["time","top","location"],"values":[`+
+		`["1970-01-01T00:00:10Z",5,"a"],["1970-01-01T00:00:20Z",3,"b"],["1970-01-01T00:01:10Z",1,"a"]]}]}]}`, queries)
+	defer one.Close()
+	two := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","tags":{"type":"gold"},"columns":["time","top","location"],"values":[`+
+		`["1970-01-01T00:00:30Z",4,"c"],["1970-01-01T00:00:40Z",1,"c"],["1970-01-01T00:01:30Z",7,"c"]]}]}]}`, queries)
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT top(value, 2), location FROM treasures WHERE time >= 0 AND time < 2m GROUP BY time(1m)`)
+	r := httptest.NewRequest("GET", "/query?db="+testDB+"&epoch=s&q="+url.QueryEscape(stmt.String()), nil)
+	results, err, _ := c.Handle(stmt, r, testDB)
+	assert.NoError(t, err)
+	assert.Len(t, results[0].Series, 1)
+	assert.Equal(t, []string{"time", "top", "location"}, results[0].Series[0].Columns)
+	assert.Equal(t, [][]interface{}{
+		{10., 5., "a"},
+		{30., 4., "c"},
+		{70., 1., "a"},
+		{90., 7., "c"},
+	}, results[0].Series[0].Values)
+
+	close(queries)
+	for q := range queries {
+		assert.Contains(t, q, "top(value, 2)")
+	}
+}
+
+func TestCoordinator_TopWithTag(t *testing.T) {
+	one := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","tags":{"type":"trash"},"columns":["time","top","location"],"values":[`+
+		`["1970-01-01T00:00:10Z",5,"a"],["1970-01-01T00:00:20Z",3,"b"]]}]}]}`, nil)
+	defer one.Close()
+	two := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","tags":{"type":"gold"},"columns":["time","top","location"],"values":[`+
+		`["1970-01-01T00:00:30Z",6,"a"],["1970-01-01T00:00:40Z",2,"c"]]}]}]}`, nil)
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT top(value, location, 2) FROM treasures`)
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	// Only the highest point of each location is selected.
+	assert.Equal(t, [][]interface{}{
+		{"1970-01-01T00:00:20Z", 3., "b"},
+		{"1970-01-01T00:00:30Z", 6., "a"},
+	}, results[0].Series[0].Values)
+}
+
+func Test_selectPoints(t *testing.T) {
+	points := []selectorPoint{{value: 3}, {value: 1}, {value: 2}, {value: 1}}
+	for i := range points {
+		points[i].time = time.Unix(int64(i), 0)
+	}
+	selected := selectPoints(points, false, 2, -1)
+	assert.Equal(t, []float64{1, 1}, []float64{selected[0].value, selected[1].value})
+	assert.True(t, selected[0].time.Before(selected[1].time))
+}
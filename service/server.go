@@ -6,11 +6,37 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type Config struct {
 	BindAddr string `toml:"bind-addr"`
 	BindPort int    `toml:"bind-port"`
+
+	// TLSCertFile and TLSKeyFile enable HTTPS for clients.
+	TLSCertFile string `toml:"https-certificate"`
+	TLSKeyFile  string `toml:"https-private-key"`
+
+	// WALDir enables the write-ahead log, which saves writes in the directory before they are relayed.
+	WALDir string `toml:"wal-dir"`
+	// WALMaxSize is the number of bytes in the write-ahead log at which new writes are rejected.
+	WALMaxSize int64 `toml:"wal-max-size"`
+
+	// WriteBatchSize is the number of points that are written to a node at once.
+	WriteBatchSize int `toml:"write-batch-size"`
+	// WriteBatchInterval is how long points are collected before being written. Batching is disabled if it is 0.
+	WriteBatchInterval time.Duration `toml:"write-batch-interval"`
+
+	// QueryTimeout is the deadline of a query, including all requests to the data nodes.
+	QueryTimeout time.Duration `toml:"query-timeout"`
+	// QueryConcurrency is the maximum number of data nodes that a query requests at the same time.
+	QueryConcurrency int `toml:"query-concurrency"`
+	// QueryHedgeDelay is how long to wait for a replica before the next one is requested as well.
+	QueryHedgeDelay time.Duration `toml:"query-hedge-delay"`
+
+	// UDP and Graphite are listeners whose points are written like those sent to /write.
+	UDP      []UDPConfig      `toml:"udp"`
+	Graphite []GraphiteConfig `toml:"graphite"`
 }
 
 func Start(
@@ -19,24 +45,70 @@ func Start(
 	recovery cluster.RecoveryStorage,
 	pks cluster.PartitionKeyStorage,
 	ns cluster.NodeStorage,
+	settings cluster.ReplicationFactorStorage,
+	drainer PartitionKeyDrainer,
+	resharder PartitionKeyResharder,
 	auth AuthService,
+	consistency *DefaultConsistency,
 	config Config,
 	localNode *cluster.Node,
 	ctx context.Context) {
 
 	addr := config.BindAddr + ":" + strconv.FormatInt(int64(config.BindPort), 10)
 
-	ch := &ClusterHandler{pks, ns, auth}
+	ch := &ClusterHandler{pks, ns, settings, auth, drainer, resharder, resolver, cluster.NewDataClient(60 * time.Second)}
 
 	mux := http.NewServeMux()
-	mux.Handle("/", NewQueryHandler(resolver, partitioner, ch, auth))
+	queryHandler := NewQueryHandler(resolver, partitioner, ch, auth)
+	queryHandler.UseOptions(QueryOptions{config.QueryTimeout, config.QueryConcurrency, config.QueryHedgeDelay})
+	queryHandler.UseTracker(NewQueryTracker(localNode.Name))
+	mux.Handle("/", queryHandler)
 	mux.Handle("/ping", NewPingHandler(localNode))
-	mux.Handle("/write", NewWriteHandler(resolver, partitioner, auth, NewHttpPointsWriter(recovery)))
+	writeHandler := NewWriteHandler(resolver, partitioner, auth, newPointsWriter(recovery, config), consistency)
+	if config.WALDir != "" {
+		maxSize := config.WALMaxSize
+		if maxSize <= 0 {
+			maxSize = DefaultWALMaxSize
+		}
+		wal, err := OpenWriteAheadLog(config.WALDir, maxSize)
+		if err != nil {
+			log.Fatal("Failed to open write-ahead log: ", err)
+		}
+		defer wal.Close()
+		writeHandler.UseWriteAheadLog(ctx, wal)
+	}
+	for _, c := range config.UDP {
+		listener := NewUDPListener(c, writeHandler.ingest)
+		if err := listener.Open(); err != nil {
+			log.Fatal("Failed to open UDP listener: ", err)
+		}
+		defer listener.Close()
+		go listener.Serve()
+	}
+	for _, c := range config.Graphite {
+		listener, err := NewGraphiteListener(c, writeHandler.ingest)
+		if err == nil {
+			err = listener.Open()
+		}
+		if err != nil {
+			log.Fatal("Failed to open Graphite listener: ", err)
+		}
+		defer listener.Close()
+		go listener.Serve()
+	}
+	mux.Handle("/write", writeHandler)
+	mux.Handle("/api/v2/write", NewV2WriteHandler(writeHandler))
 
 	srv := http.Server{Addr: addr, Handler: mux}
 
-	log.Println("Listening on " + addr)
-	err := srv.ListenAndServe()
+	var err error
+	if config.TLSCertFile != "" {
+		log.Println("Listening with HTTPS on " + addr)
+		err = srv.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+	} else {
+		log.Println("Listening on " + addr)
+		err = srv.ListenAndServe()
+	}
 	if err != nil {
 		log.Fatal("ListenAndServe: ", err)
 	}
@@ -47,3 +119,15 @@ func Start(
 		srv.Close()
 	}
 }
+
+func newPointsWriter(recovery cluster.RecoveryStorage, config Config) PointsWriter {
+	writer := NewHttpPointsWriter(recovery)
+	if config.WriteBatchInterval <= 0 {
+		return writer
+	}
+	size := config.WriteBatchSize
+	if size <= 0 {
+		size = DefaultWriteBatchSize
+	}
+	return NewBatchingPointsWriter(writer, size, config.WriteBatchInterval)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+)
+
+// RouteWithMerge sends the statement to one replica of every partition, as any of them may have
+// measurements, series or tag values that the others do not have. The rows are merged, deduplicated
+// and sorted, and the limit and offset of the statement are applied afterwards.
+func RouteWithMerge(resolver *cluster.Resolver, partitioner cluster.Partitioner, options QueryOptions, client *http.Client) RoutingFunc {
+	return func(w http.ResponseWriter, r *http.Request, stmt influxql.Statement, flusher ResultFlusher) ([]Result, error) {
+		pushed, limit, offset := withoutOffset(stmt)
+		c := NewCoordinatorWithOptions(resolver, partitioner, options)
+		// The statement may be about any measurement, so only nodes that have replicas of all data are used.
+		allResults, _, err, res := c.performQuery(pushed.String(), r, resolver.FindAllTokens(), cluster.ReplicationScope{}, client)
+		if err != nil {
+			return nil, respondWithCoordinationError(w, err, res)
+		}
+		return mergeShowResults(resultsOf(allResults), limit, offset), nil
+	}
+}
+
+// withoutOffset returns a copy of the statement where the offset is included in the limit, as the
+// rows that are skipped can only be known after merging. The original limit and offset are returned.
+func withoutOffset(stmt influxql.Statement) (influxql.Statement, int, int) {
+	switch s := stmt.(type) {
+	case *influxql.ShowMeasurementsStatement:
+		pushed := *s
+		pushed.Limit, pushed.Offset = pushedLimit(s.Limit, s.Offset), 0
+		return &pushed, s.Limit, s.Offset
+	case *influxql.ShowSeriesStatement:
+		pushed := *s
+		pushed.Limit, pushed.Offset = pushedLimit(s.Limit, s.Offset), 0
+		return &pushed, s.Limit, s.Offset
+	case *influxql.ShowTagValuesStatement:
+		pushed := *s
+		pushed.Limit, pushed.Offset = pushedLimit(s.Limit, s.Offset), 0
+		return &pushed, s.Limit, s.Offset
+	}
+	return stmt, 0, 0
+}
+
+func pushedLimit(limit, offset int) int {
+	if limit == 0 {
+		return 0
+	}
+	return limit + offset
+}
+
+// combineShowResults puts the rows of series with the same name and tags together.
+func combineShowResults(allResults [][]Result) []Result {
+	combined := Result{}
+	series := map[string]*models.Row{}
+	for _, results := range allResults {
+		for _, res := range results {
+			if res.Err != "" {
+				return []Result{{Err: res.Err}}
+			}
+			combined.Messages = append(combined.Messages, res.Messages...)
+			for _, row := range res.Series {
+				key := seriesKey(row)
+				if existing, ok := series[key]; ok {
+					existing.Values = append(existing.Values, row.Values...)
+					continue
+				}
+				copied := *row
+				series[key] = &copied
+				combined.Series = append(combined.Series, &copied)
+			}
+		}
+	}
+	sort.SliceStable(combined.Series, func(i, j int) bool {
+		return seriesKey(combined.Series[i]) < seriesKey(combined.Series[j])
+	})
+	return []Result{combined}
+}
+
+func mergeShowResults(allResults [][]Result, limit, offset int) []Result {
+	results := combineShowResults(allResults)
+	for _, row := range results[0].Series {
+		unique := [][]interface{}{}
+		seen := map[string]bool{}
+		for _, v := range row.Values {
+			if point := hashPoint(v); !seen[point] {
+				seen[point] = true
+				unique = append(unique, v)
+			}
+		}
+		sort.SliceStable(unique, func(i, j int) bool { return lessRow(unique[i], unique[j]) })
+		row.Values = limitValues(unique, limit, offset)
+	}
+	return results
+}
+
+// lessRow compares rows by their columns in order.
+func lessRow(a, b []interface{}) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(fmt.Sprint(a[i]), fmt.Sprint(b[i])); c != 0 {
+			return c < 0
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/influxdata/influxql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteWithMerge_ShowTagValues(t *testing.T) {
+	queries := make(chan string, 2)
+	one := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["key","value"],"values":[["type","gold"],["type","trash"]]}]}]}`, queries)
+	defer one.Close()
+	two := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["key","value"],"values":[["type","gold"],["type","diamond"]]}]}]}`, queries)
+	defer two.Close()
+
+	resolver := newFakeCluster(one, two)
+	route := RouteWithMerge(resolver, newPartitioner(), QueryOptions{}, &http.Client{})
+	stmt, err := influxql.ParseStatement(`SHOW TAG VALUES FROM treasures WITH KEY = "type" LIMIT 2 OFFSET 1`)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	results, err := route(w, newQueryRequest(stmt.String()), stmt, nil)
+	assert.NoError(t, err)
+	assert.Len(t, results[0].Series, 1)
+	// The rows are diamond, gold and trash after merging.
+	assert.Equal(t, [][]interface{}{{"type", "gold"}, {"type", "trash"}}, results[0].Series[0].Values)
+
+	close(queries)
+	for q := range queries {
+		assert.Contains(t, q, "LIMIT 3")
+		assert.NotContains(t, q, "OFFSET")
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+)
+
+// hasMultipleSources returns true if the statement may select from more than one measurement. Each
+// measurement can have a partition key of its own and therefore has to be queried separately.
+func hasMultipleSources(stmt *influxql.SelectStatement) bool {
+	if len(stmt.Sources) > 1 {
+		return true
+	}
+	m, ok := stmt.Sources[0].(*influxql.Measurement)
+	return ok && m.Regex != nil
+}
+
+// handleSources handles the statement for each of its measurements and combines the series
+// in the same order as InfluxDB, sorted by measurement and tags.
+func (c *Coordinator) handleSources(stmt *influxql.SelectStatement, r *http.Request, db string, client *http.Client) ([]Result, error, *http.Response) {
+	measurements, err := c.expandSources(stmt.Sources, r, db, client)
+	if err != nil {
+		return []Result{}, err, nil
+	}
+
+	var response *http.Response
+	combined := Result{}
+	for _, m := range measurements {
+		single := stmt.Clone()
+		single.Sources = influxql.Sources{m}
+		results, err, res := c.Handle(single, r, db)
+		if err != nil {
+			return []Result{}, err, res
+		}
+		if res != nil {
+			response = res
+		}
+		for _, result := range results {
+			if result.Err != "" {
+				return []Result{}, errors.New(result.Err), response
+			}
+			combined.Series = append(combined.Series, result.Series...)
+			combined.Messages = append(combined.Messages, result.Messages...)
+		}
+	}
+	sort.SliceStable(combined.Series, func(i, j int) bool {
+		return seriesKey(combined.Series[i]) < seriesKey(combined.Series[j])
+	})
+	if stmt.HasFieldWildcard() && !hasCall(stmt) {
+		alignColumns(combined.Series)
+	}
+	return []Result{combined}, nil, response
+}
+
+// expandSources replaces measurements with regular expressions by the measurements that match them.
+// The measurements are found by asking a node for every partition, as they may only exist on some.
+func (c *Coordinator) expandSources(sources influxql.Sources, r *http.Request, db string, client *http.Client) ([]*influxql.Measurement, error) {
+	measurements := []*influxql.Measurement{}
+	seen := map[string]bool{}
+	add := func(m *influxql.Measurement) {
+		if !seen[m.String()] {
+			seen[m.String()] = true
+			measurements = append(measurements, m)
+		}
+	}
+	for _, source := range sources {
+		m, ok := source.(*influxql.Measurement)
+		if !ok {
+			return nil, errors.New("a subquery can not be combined with other sources")
+		}
+		if m.Regex == nil {
+			add(m)
+			continue
+		}
+		show := &influxql.ShowMeasurementsStatement{
+			Database: m.Database,
+			Source:   &influxql.Measurement{Regex: m.Regex},
+		}
+		scope := cluster.ReplicationScope{Database: db}
+		if m.Database != "" {
+			scope.Database = m.Database
+		}
+		allResults, _, err, _ := c.performQuery(show.String(), r, c.resolver.FindAllTokens(), scope, client)
+		if err != nil {
+			return nil, err
+		}
+		names := []string{}
+		forEachRow(allResults, func(row []interface{}) {
+			if name, ok := row[0].(string); ok {
+				names = append(names, name)
+			}
+		})
+		sort.Strings(names)
+		for _, name := range names {
+			add(&influxql.Measurement{Database: m.Database, RetentionPolicy: m.RetentionPolicy, Name: name})
+		}
+	}
+	return measurements, nil
+}
+
+// alignColumns gives all series the same columns when selecting all fields from several measurements,
+// which is what InfluxDB does. Values are null in the columns that a measurement does not have.
+func alignColumns(series []*models.Row) {
+	seen := map[string]bool{}
+	columns := []string{}
+	for _, row := range series {
+		for _, col := range row.Columns[1:] {
+			if !seen[col] {
+				seen[col] = true
+				columns = append(columns, col)
+			}
+		}
+	}
+	sort.Strings(columns)
+	columns = append([]string{"time"}, columns...)
+
+	for _, row := range series {
+		indices := map[string]int{}
+		for i, col := range row.Columns {
+			indices[col] = i
+		}
+		values := make([][]interface{}, len(row.Values))
+		for i, v := range row.Values {
+			aligned := make([]interface{}, len(columns))
+			for j, col := range columns {
+				if k, ok := indices[col]; ok {
+					aligned[j] = v[k]
+				}
+			}
+			values[i] = aligned
+		}
+		row.Columns, row.Values = columns, values
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoordinator_MultipleSources(t *testing.T) {
+	// treasures is partitioned by type while coins is not partitioned at all.
+	one := newFakeNodeFunc(t, func(q string) string {
+		switch {
+		case strings.HasPrefix(q, "SHOW MEASUREMENTS"):
+			assert.Contains(t, q, "=~ /^(coins|treasures)$/")
+			return `{"results":[{"statement_id":0,"series":[{"name":"measurements","columns":["name"],"values":[["treasures"]]}]}]}`
+		case strings.Contains(q, "treasures"):
+			return `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","value"],"values":[["1970-01-01T00:00:01Z",1]]}]}]}`
+		}
+		return `{"results":[{"statement_id":0}]}`
+	})
+	defer one.Close()
+	two := newFakeNodeFunc(t, func(q string) string {
+		switch {
+		case strings.HasPrefix(q, "SHOW MEASUREMENTS"):
+			return `{"results":[{"statement_id":0,"series":[{"name":"measurements","columns":["name"],"values":[["coins"],["treasures"]]}]}]}`
+		case strings.Contains(q, "treasures"):
+			return `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","value"],"values":[["1970-01-01T00:00:02Z",2]]}]}]}`
+		}
+		return `{"results":[{"statement_id":0,"series":[{"name":"coins","columns":["time","amount"],"values":[["1970-01-01T00:00:03Z",3]]}]}]}`
+	})
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	for _, q := range []string{`SELECT * FROM treasures, coins`, `SELECT * FROM /^(coins|treasures)$/`} {
+		stmt := mustGetSelect(q)
+		results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+		assert.NoError(t, err)
+		series := results[0].Series
+		assert.Len(t, series, 2)
+		assert.Equal(t, "coins", series[0].Name)
+		assert.Equal(t, []string{"time", "amount", "value"}, series[0].Columns)
+		assert.Equal(t, [][]interface{}{{"1970-01-01T00:00:03Z", 3., nil}}, series[0].Values)
+		assert.Equal(t, "treasures", series[1].Name)
+		assert.Equal(t, [][]interface{}{{"1970-01-01T00:00:01Z", nil, 1.}, {"1970-01-01T00:00:02Z", nil, 2.}}, series[1].Values)
+	}
+}
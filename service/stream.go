@@ -0,0 +1,301 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+)
+
+var streamClient struct {
+	once   sync.Once
+	client *http.Client
+}
+
+// getStreamClient returns the client used for responses that are forwarded while they are being read.
+// There is therefore only a timeout for receiving the headers and not for reading the entire body.
+func getStreamClient() *http.Client {
+	streamClient.once.Do(func() {
+		transport := cluster.NewDataTransport()
+		transport.ResponseHeaderTimeout = 10 * time.Second
+		streamClient.client = &http.Client{Transport: transport}
+	})
+	return streamClient.client
+}
+
+// HandleChunked writes results to the flusher while they are received from the nodes. Queries
+// that can be answered by a single node are forwarded as they are, and queries without aggregations
+// are merged while reading from all nodes. Other queries, including those with subqueries or several
+// sources, are handled like in Handle as their results need to be complete before they can be merged.
+func (c *Coordinator) HandleChunked(stmt *influxql.SelectStatement, r *http.Request, db string, flusher ResultFlusher) (error, *http.Response) {
+	hashes, _ := c.resolveHashes(stmt, db)
+	if len(hashes) == 0 {
+		return fmt.Errorf("there are no nodes available to handle the query"), nil
+	}
+	interval, err := stmt.GroupByInterval()
+	if err != nil {
+		return err, nil
+	}
+	_, isSubQuery := subQuery(stmt)
+	if isSubQuery || hasMultipleSources(stmt) || (len(hashes) > 1 && (interval != 0 || hasCall(stmt))) {
+		results, err, res := c.Handle(stmt, r, db)
+		if err != nil {
+			return err, res
+		}
+		return flusher.Write(results...), nil
+	}
+
+	// The deadline also applies while the responses are being read, which the stream client does not limit.
+	ctx, cancel := context.WithTimeout(r.Context(), c.timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	streams, err, res := c.openStreams(stmt.String(), hashes, readScope(stmt, db), r)
+	defer func() {
+		for _, stream := range streams {
+			stream.Close()
+		}
+	}()
+	if err != nil {
+		return err, res
+	}
+
+	if len(streams) == 1 {
+		for {
+			results, err := streams[0].Next()
+			if err == io.EOF {
+				return nil, nil
+			}
+			if err != nil {
+				return flusher.Write(Result{Err: err.Error()}), nil
+			}
+			if err := flusher.Write(results...); err != nil {
+				return err, nil
+			}
+		}
+	}
+
+	cursors := make([]*rowCursor, len(streams))
+	for i, stream := range streams {
+		cursors[i] = &rowCursor{stream: stream}
+	}
+	if err := mergeStreams(cursors, lessForRequest(r), chunkSize(r), flusher); err != nil {
+		return flusher.Write(Result{Err: err.Error()}), nil
+	}
+	return nil, nil
+}
+
+// openStreams opens a response from one replica for each of the hashes. Like in performQuery, a hash
+// is skipped if a location that has its data is already used.
+func (c *Coordinator) openStreams(stmt string, hashes []int, scope cluster.ReplicationScope, r *http.Request) ([]*resultStream, error, *http.Response) {
+	opened := map[string]bool{}
+	streams := []*resultStream{}
+hashLoop:
+	for _, hash := range hashes {
+		locations := c.resolver.FindByKey(hash, scope.Database, scope.Measurement, cluster.READ)
+		for _, location := range locations {
+			if opened[location] {
+				continue hashLoop
+			}
+		}
+		err := fmt.Errorf("no replica is available for key %d", hash)
+		var res *http.Response
+		for _, location := range locations {
+			var stream *resultStream
+			stream, err, res = openStream(stmt, location, getStreamClient(), r)
+			if err == nil {
+				opened[location] = true
+				streams = append(streams, stream)
+				break
+			}
+		}
+		if err != nil {
+			return streams, err, res
+		}
+	}
+	return streams, nil, nil
+}
+
+func openStream(statement string, host string, client *http.Client, r *http.Request) (*resultStream, error, *http.Response) {
+	req, err := newNodeRequest(statement, host, r)
+	if err != nil {
+		return nil, err, nil
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err, nil
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, errors.New(parseErrorResponseMessage(res)), res
+	}
+	return &resultStream{res.Body, json.NewDecoder(res.Body)}, nil, res
+}
+
+// resultStream decodes a response from a node one chunk at a time.
+type resultStream struct {
+	body    io.ReadCloser
+	decoder *json.Decoder
+}
+
+// Next returns the results in the next chunk or io.EOF when there are no more.
+func (s *resultStream) Next() ([]Result, error) {
+	var chunk response
+	if err := s.decoder.Decode(&chunk); err != nil {
+		return nil, err
+	}
+	return chunk.Results, nil
+}
+
+func (s *resultStream) Close() error {
+	return s.body.Close()
+}
+
+// rowCursor iterates over the rows of every series in a stream.
+type rowCursor struct {
+	stream  *resultStream
+	pending []*models.Row
+	series  *models.Row
+	key     string
+	row     int
+	err     error
+}
+
+// next moves the cursor to the next row. It returns false when the stream is
+// consumed or failed, in which case err is set.
+func (c *rowCursor) next() bool {
+	c.row++
+	for c.series == nil || c.row >= len(c.series.Values) {
+		if len(c.pending) > 0 {
+			c.series, c.pending = c.pending[0], c.pending[1:]
+			c.key = seriesKey(c.series)
+			c.row = 0
+			continue
+		}
+		results, err := c.stream.Next()
+		if err != nil {
+			if err != io.EOF {
+				c.err = err
+			}
+			return false
+		}
+		for _, res := range results {
+			if res.Err != "" {
+				c.err = errors.New(res.Err)
+				return false
+			}
+			c.pending = append(c.pending, res.Series...)
+		}
+	}
+	return true
+}
+
+func (c *rowCursor) value() []interface{} {
+	return c.series.Values[c.row]
+}
+
+type cursorHeap struct {
+	cursors []*rowCursor
+	less    compareLess
+}
+
+func (h *cursorHeap) Len() int { return len(h.cursors) }
+
+func (h *cursorHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	return h.less(a.value()[0], b.value()[0])
+}
+
+func (h *cursorHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *cursorHeap) Push(x interface{}) { h.cursors = append(h.cursors, x.(*rowCursor)) }
+
+func (h *cursorHeap) Pop() interface{} {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
+
+// mergeStreams merges the rows of all cursors by series and time, and writes them in chunks with at most
+// size rows. Like in mergeSortResults, identical points are only included once as replicas may have
+// returned the same data.
+func mergeStreams(cursors []*rowCursor, less compareLess, size int, flusher ResultFlusher) error {
+	h := &cursorHeap{less: less}
+	for _, c := range cursors {
+		if c.next() {
+			h.cursors = append(h.cursors, c)
+		} else if c.err != nil {
+			return c.err
+		}
+	}
+	heap.Init(h)
+
+	// A chunk is written once the next one is started, as it is first then known if more will follow.
+	var pending *models.Row
+	writePending := func(more bool) error {
+		if pending == nil {
+			return nil
+		}
+		return flusher.Write(Result{Series: []*models.Row{pending}, Partial: more})
+	}
+
+	var chunk *models.Row
+	var chunkKey, lastTime string
+	seen := map[string]bool{}
+	for h.Len() > 0 {
+		c := h.cursors[0]
+		row := c.value()
+		if chunkKey != c.key {
+			seen = map[string]bool{}
+		}
+		if chunk == nil || chunkKey != c.key || len(chunk.Values) >= size {
+			if chunk != nil {
+				chunk.Partial = chunkKey == c.key
+				if err := writePending(true); err != nil {
+					return err
+				}
+				pending = chunk
+			}
+			chunk = &models.Row{Name: c.series.Name, Tags: c.series.Tags, Columns: c.series.Columns, Values: [][]interface{}{}}
+			chunkKey = c.key
+		}
+		// Duplicates have the same time, so only points at the current time need to be remembered.
+		if t := fmt.Sprint(row[0]); t != lastTime {
+			seen = map[string]bool{}
+			lastTime = t
+		}
+		if point := hashPoint(row); !seen[point] {
+			chunk.Values = append(chunk.Values, row)
+			seen[point] = true
+		}
+
+		if c.next() {
+			heap.Fix(h, 0)
+		} else {
+			if c.err != nil {
+				return c.err
+			}
+			heap.Pop(h)
+		}
+	}
+	if chunk == nil {
+		// Let the client know that the statement did not return anything.
+		return flusher.Write(Result{})
+	}
+	if err := writePending(true); err != nil {
+		return err
+	}
+	pending = chunk
+	return writePending(false)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readChunks(t *testing.T, recorder *httptest.ResponseRecorder) []Result {
+	results := []Result{}
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		var chunk response
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &chunk))
+		results = append(results, chunk.Results...)
+	}
+	return results
+}
+
+func TestCoordinator_HandleChunkedMergesNodes(t *testing.T) {
+	one := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","value"],"values":[[1,1],[3,3]],"partial":true}],"partial":true}]}
+{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","value"],"values":[[5,5],[5,5]]}]}]}`, nil)
+	defer one.Close()
+	two := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","value"],"values":[[2,2],[4,4]]}]}]}`, nil)
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT value FROM treasures`)
+	r := httptest.NewRequest("GET", "/query?db="+testDB+"&chunked=true&chunk_size=2&epoch=s&q="+url.QueryEscape(stmt.String()), nil)
+	w := httptest.NewRecorder()
+	flusher := NewResultFlusher(w, r)
+	err, _ := c.HandleChunked(stmt, r, testDB, flusher)
+	assert.NoError(t, err)
+	assert.NoError(t, flusher.Flush())
+
+	chunks := readChunks(t, w)
+	assert.Len(t, chunks, 3)
+	assert.True(t, chunks[0].Partial)
+	assert.True(t, chunks[0].Series[0].Partial)
+	assert.Equal(t, [][]interface{}{{1., 1.}, {2., 2.}}, chunks[0].Series[0].Values)
+	assert.Equal(t, [][]interface{}{{3., 3.}, {4., 4.}}, chunks[1].Series[0].Values)
+	assert.False(t, chunks[2].Partial)
+	assert.False(t, chunks[2].Series[0].Partial)
+	// The duplicated point is only included once.
+	assert.Equal(t, [][]interface{}{{5., 5.}}, chunks[2].Series[0].Values)
+}
+
+func TestCoordinator_HandleChunkedForwardsSingleNode(t *testing.T) {
+	one := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","value"],"values":[[1,1]],"partial":true}],"partial":true}]}
+{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","value"],"values":[[2,2]]}]}]}`, nil)
+	defer one.Close()
+
+	c := NewCoordinator(newFakeCluster(one), newPartitioner())
+	stmt := mustGetSelect(`SELECT value FROM treasures WHERE type = 'gold'`)
+	r := httptest.NewRequest("GET", "/query?db="+testDB+"&chunked=true&epoch=s&q="+url.QueryEscape(stmt.String()), nil)
+	w := httptest.NewRecorder()
+	flusher := NewResultFlusher(w, r)
+	flusher.SetStatementID(1)
+	err, _ := c.HandleChunked(stmt, r, testDB, flusher)
+	assert.NoError(t, err)
+
+	chunks := readChunks(t, w)
+	assert.Len(t, chunks, 2)
+	assert.True(t, chunks[0].Partial)
+	assert.Equal(t, 1, chunks[0].StatementID)
+	assert.Equal(t, 1, chunks[1].StatementID)
+}
+
+func TestCoordinator_HandleChunkedDeadline(t *testing.T) {
+	// The node sends the first chunk and then stops responding.
+	done := make(chan bool)
+	defer close(done)
+	one := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","value"],"values":[[1,1]],"partial":true}],"partial":true}]}`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer one.Close()
+
+	c := NewCoordinator(newFakeCluster(one), newPartitioner())
+	c.timeout = 50 * time.Millisecond
+	stmt := mustGetSelect(`SELECT value FROM treasures WHERE type = 'gold'`)
+	r := httptest.NewRequest("GET", "/query?db="+testDB+"&chunked=true&epoch=s&q="+url.QueryEscape(stmt.String()), nil)
+	w := httptest.NewRecorder()
+	flusher := NewResultFlusher(w, r)
+	err, _ := c.HandleChunked(stmt, r, testDB, flusher)
+	assert.NoError(t, err)
+
+	chunks := readChunks(t, w)
+	assert.Len(t, chunks, 2)
+	assert.NotEmpty(t, chunks[1].Err)
+}
+
+func TestRouteWithCoordination_ChunkedErrorAfterStart(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonError(w, http.StatusInternalServerError, "unexpected")
+	}))
+	defer failing.Close()
+
+	route := RouteWithCoordination(newFakeCluster(failing), newPartitioner(), NewFieldKeyCache(), QueryOptions{}, testDB)
+	stmt := mustGetSelect(`SELECT value FROM treasures WHERE type = 'gold' OR type = 'silver'`)
+	r := httptest.NewRequest("GET", "/query?db="+testDB+"&chunked=true&epoch=s&q="+url.QueryEscape(stmt.String()), nil)
+	w := httptest.NewRecorder()
+	flusher := NewResultFlusher(w, r)
+	// An earlier statement has already started the response.
+	flusher.Write(Result{})
+	flusher.SetStatementID(1)
+	_, err := route(w, r, stmt, flusher)
+	assert.NoError(t, err)
+
+	chunks := readChunks(t, w)
+	assert.Len(t, chunks, 2)
+	assert.Equal(t, 1, chunks[1].StatementID)
+	assert.Equal(t, "unexpected", chunks[1].Err)
+}
+
+func TestResultFlusher_Buffered(t *testing.T) {
+	r := httptest.NewRequest("GET", "/query?q=SHOW+DATABASES", nil)
+	w := httptest.NewRecorder()
+	flusher := NewResultFlusher(w, r)
+	flusher.Write(Result{})
+	flusher.SetStatementID(1)
+	flusher.Write(Result{})
+	assert.Equal(t, 0, w.Body.Len())
+	flusher.Flush()
+
+	chunks := readChunks(t, w)
+	assert.Len(t, chunks, 2)
+	assert.Equal(t, 1, chunks[1].StatementID)
+}
@@ -0,0 +1,496 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/adamringhede/influxdb-ha/service/merge"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+)
+
+// MaxSelectBuckets is the maximum number of intervals that an outer statement is evaluated for,
+// like max-select-buckets of InfluxDB.
+var MaxSelectBuckets = 100000
+
+// subQuery returns the subquery if it is the source of the statement.
+func subQuery(stmt *influxql.SelectStatement) (*influxql.SubQuery, bool) {
+	for _, source := range stmt.Sources {
+		if sub, ok := source.(*influxql.SubQuery); ok {
+			return sub, true
+		}
+	}
+	return nil, false
+}
+
+// handleSubQuery sends the entire statement to a single node if the subquery only needs data from
+// one partition. Otherwise the subquery is handled like any other statement and the outer statement is
+// evaluated on the merged results.
+func (c *Coordinator) handleSubQuery(stmt *influxql.SelectStatement, sub *influxql.SubQuery, r *http.Request, db string, client *http.Client) ([]Result, error, *http.Response) {
+	if len(stmt.Sources) > 1 {
+		return []Result{}, errors.New("a subquery can not be combined with other sources"), nil
+	}
+	hashes, _ := c.resolveHashes(sub.Statement, db)
+	switch len(hashes) {
+	case 0:
+		return []Result{}, fmt.Errorf("there are no nodes available to handle the query"), nil
+	case 1:
+		scope := readScope(sub.Statement, db)
+		locations := c.resolver.FindByKey(hashes[0], scope.Database, scope.Measurement, cluster.READ)
+		return c.requestReplicas(stmt.String(), locations, client, r)
+	}
+
+	// Times are parsed when evaluating the outer statement, which is easiest without an epoch.
+	inner, err, res := c.Handle(sub.Statement, withoutEpoch(r), db)
+	if err != nil {
+		return []Result{}, err, res
+	}
+	for _, result := range inner {
+		if result.Err != "" {
+			return []Result{}, errors.New(result.Err), res
+		}
+	}
+	results, err := evaluateOuter(stmt, inner, r.URL.Query().Get("epoch"), time.Now())
+	if err != nil {
+		return []Result{}, err, nil
+	}
+	return results, nil, res
+}
+
+// subPoint is a row from the results of a subquery with the values by column and tag.
+type subPoint struct {
+	time   time.Time
+	values map[string]interface{}
+}
+
+type subSeries struct {
+	name   string
+	tags   map[string]string
+	points []subPoint
+}
+
+// evaluateOuter evaluates the statement on the results of its subquery in the same way as InfluxDB
+// would do on a single node. It supports selecting fields and expressions of them, or aggregations
+// of them grouped by time and tags.
+func evaluateOuter(stmt *influxql.SelectStatement, inner []Result, epoch string, now time.Time) ([]Result, error) {
+	cond, timeRange, err := influxql.ConditionExpr(stmt.Condition, &influxql.NowValuer{Now: now})
+	if err != nil {
+		return nil, err
+	}
+	window, err := newTimeWindow(stmt, epoch)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := outerFields(stmt, inner)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := groupSubPoints(stmt, inner, cond, timeRange)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregate := false
+	for _, field := range fields {
+		if hasAggregate(field.Expr) {
+			aggregate = true
+		} else if aggregate || window.interval != 0 {
+			return nil, errors.New("mixing aggregate and non-aggregate queries is not supported")
+		}
+	}
+
+	results := []Result{}
+	for _, group := range groups {
+		sort.SliceStable(group.points, func(i, j int) bool { return group.points[i].time.Before(group.points[j].time) })
+		row := &models.Row{Name: group.name, Tags: group.tags, Columns: []string{"time"}}
+		for _, field := range fields {
+			row.Columns = append(row.Columns, field.Name())
+		}
+		if aggregate {
+			row.Values, err = aggregateSubPoints(stmt, fields, group.points, window, timeRange)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			row.Values = projectSubPoints(fields, group.points, window)
+		}
+		if !stmt.TimeAscending() {
+			for i, j := 0, len(row.Values)-1; i < j; i, j = i+1, j-1 {
+				row.Values[i], row.Values[j] = row.Values[j], row.Values[i]
+			}
+		}
+		row.Values = limitValues(row.Values, stmt.Limit, stmt.Offset)
+		if len(row.Values) > 0 {
+			results = append(results, Result{Series: []*models.Row{row}})
+		}
+	}
+	return combineResults(results), nil
+}
+
+// outerFields returns the fields of the statement where a wildcard is replaced by the columns of the results.
+func outerFields(stmt *influxql.SelectStatement, inner []Result) (influxql.Fields, error) {
+	fields := influxql.Fields{}
+	for _, field := range stmt.Fields {
+		switch field.Expr.(type) {
+		case *influxql.Wildcard:
+			seen := map[string]bool{}
+			for _, res := range inner {
+				for _, series := range res.Series {
+					for _, col := range series.Columns[1:] {
+						if !seen[col] {
+							seen[col] = true
+							fields = append(fields, &influxql.Field{Expr: &influxql.VarRef{Val: col}})
+						}
+					}
+				}
+			}
+		case *influxql.RegexLiteral:
+			return nil, fmt.Errorf("regular expressions are not supported in the fields of a subquery: %s", field)
+		default:
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// groupSubPoints filters the rows of the results and groups them by the tags in the dimensions of the statement.
+func groupSubPoints(stmt *influxql.SelectStatement, inner []Result, cond influxql.Expr, timeRange influxql.TimeRange) ([]*subSeries, error) {
+	grouped := tagDimensions(stmt)
+	wildcard := stmt.HasDimensionWildcard()
+	min, max := timeRange.MinTime(), timeRange.MaxTime()
+
+	groups := map[string]*subSeries{}
+	for _, res := range inner {
+		for _, series := range res.Series {
+			tags := map[string]string{}
+			for key, value := range series.Tags {
+				if wildcard || grouped[key] {
+					tags[key] = value
+				}
+			}
+			key := seriesKey(&models.Row{Name: series.Name, Tags: tags})
+			group, ok := groups[key]
+			if !ok {
+				group = &subSeries{name: series.Name, tags: tags}
+				groups[key] = group
+			}
+			for _, row := range series.Values {
+				t, err := parseTime(row[0])
+				if err != nil {
+					return nil, err
+				}
+				if t.Before(min) || t.After(max) {
+					continue
+				}
+				values := map[string]interface{}{}
+				for key, value := range series.Tags {
+					values[key] = value
+				}
+				for i, col := range series.Columns[1:] {
+					values[col] = row[i+1]
+				}
+				if cond != nil && !influxql.EvalBool(cond, values) {
+					continue
+				}
+				group.points = append(group.points, subPoint{t, values})
+			}
+		}
+	}
+
+	sorted := []*subSeries{}
+	for _, key := range sortedKeys(groups) {
+		sorted = append(sorted, groups[key])
+	}
+	return sorted, nil
+}
+
+func sortedKeys(groups map[string]*subSeries) []string {
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func projectSubPoints(fields influxql.Fields, points []subPoint, window timeWindow) [][]interface{} {
+	values := [][]interface{}{}
+	for _, p := range points {
+		row := []interface{}{window.format(p.time)}
+		for _, field := range fields {
+			row = append(row, influxql.Eval(field.Expr, p.values))
+		}
+		if !allNil(row[1:]) {
+			values = append(values, row)
+		}
+	}
+	return values
+}
+
+func hasAggregate(expr influxql.Expr) bool {
+	found := false
+	influxql.WalkFunc(expr, func(n influxql.Node) {
+		if _, ok := n.(*influxql.Call); ok {
+			found = true
+		}
+	})
+	return found
+}
+
+// aggregateSubPoints calculates the fields for every interval. If the statement is not grouped by time,
+// all points are in a single interval that starts at the beginning of the time range.
+func aggregateSubPoints(stmt *influxql.SelectStatement, fields influxql.Fields, points []subPoint, window timeWindow, timeRange influxql.TimeRange) ([][]interface{}, error) {
+	buckets := map[time.Time][]subPoint{}
+	intervals := []time.Time{}
+	if window.interval == 0 {
+		if len(points) == 0 {
+			return [][]interface{}{}, nil
+		}
+		start := time.Unix(0, 0).UTC()
+		if !timeRange.Min.IsZero() {
+			start = timeRange.Min
+		}
+		buckets[start] = points
+		intervals = append(intervals, start)
+	} else {
+		for _, p := range points {
+			start := window.startOf(p.time)
+			buckets[start] = append(buckets[start], p)
+		}
+		var err error
+		intervals, err = fillIntervals(buckets, window, timeRange)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A single selector returns the time of the selected point when not grouped by time.
+	var selector *influxql.Call
+	if len(fields) == 1 && window.interval == 0 {
+		if call, ok := fields[0].Expr.(*influxql.Call); ok && isSelector(call.Name) {
+			selector = call
+		}
+	}
+
+	values := [][]interface{}{}
+	var previous []interface{}
+	for _, start := range intervals {
+		bucket := buckets[start]
+		row := []interface{}{window.format(start)}
+		if len(bucket) == 0 {
+			switch stmt.Fill {
+			case influxql.NoFill:
+				continue
+			case influxql.NumberFill:
+				for range fields {
+					row = append(row, stmt.FillValue)
+				}
+			case influxql.PreviousFill:
+				if previous != nil {
+					row = append(row, previous[1:]...)
+					break
+				}
+				fallthrough
+			default:
+				for range fields {
+					row = append(row, nil)
+				}
+			}
+			values = append(values, row)
+			continue
+		}
+		for _, field := range fields {
+			value, t, err := evalAggregate(field.Expr, bucket)
+			if err != nil {
+				return nil, err
+			}
+			if selector != nil && !t.IsZero() {
+				row[0] = window.format(t)
+			}
+			row = append(row, value)
+		}
+		previous = row
+		values = append(values, row)
+	}
+	return values, nil
+}
+
+// fillIntervals returns the start of every interval in the time range, or between the first and last
+// point if the range is not bounded. An error is returned if there are more than MaxSelectBuckets intervals.
+func fillIntervals(buckets map[time.Time][]subPoint, window timeWindow, timeRange influxql.TimeRange) ([]time.Time, error) {
+	var first, last time.Time
+	for start := range buckets {
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if last.IsZero() || start.After(last) {
+			last = start
+		}
+	}
+	if !timeRange.Min.IsZero() {
+		first = window.startOf(timeRange.Min)
+	}
+	if !timeRange.Max.IsZero() {
+		last = window.startOf(timeRange.Max)
+	}
+	intervals := []time.Time{}
+	if first.IsZero() && last.IsZero() {
+		return intervals, nil
+	}
+	if count := int64(last.Sub(first)/window.interval) + 1; count > int64(MaxSelectBuckets) {
+		return nil, fmt.Errorf("max-select-buckets limit exceeded: (%d/%d)", count, MaxSelectBuckets)
+	}
+	for t := first; !t.After(last); t = t.Add(window.interval) {
+		intervals = append(intervals, t)
+	}
+	return intervals, nil
+}
+
+func isSelector(name string) bool {
+	switch name {
+	case "first", "last", "min", "max":
+		return true
+	}
+	return false
+}
+
+// evalAggregate calculates the calls in the expression on the points and then evaluates the expression
+// with their results. The time of the selected point is returned for selectors.
+func evalAggregate(expr influxql.Expr, points []subPoint) (interface{}, time.Time, error) {
+	if call, ok := expr.(*influxql.Call); ok {
+		return reduceCall(call, points)
+	}
+	values := map[string]interface{}{}
+	var err error
+	rewritten := influxql.RewriteExpr(influxql.CloneExpr(expr), func(e influxql.Expr) influxql.Expr {
+		call, ok := e.(*influxql.Call)
+		if !ok || err != nil {
+			return e
+		}
+		key := call.String()
+		values[key], _, err = reduceCall(call, points)
+		return &influxql.VarRef{Val: key}
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return influxql.Eval(rewritten, values), time.Time{}, nil
+}
+
+// reduceCall calculates an aggregation over a field of the points.
+func reduceCall(call *influxql.Call, points []subPoint) (interface{}, time.Time, error) {
+	if len(call.Args) == 0 {
+		return nil, time.Time{}, fmt.Errorf("invalid number of arguments for %s", call.Name)
+	}
+	ref, ok := call.Args[0].(*influxql.VarRef)
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("expected field argument in %s(), found %s", call.Name, call.Args[0])
+	}
+	type point struct {
+		time  time.Time
+		value interface{}
+	}
+	all := []point{}
+	numbers := []float64{}
+	for _, p := range points {
+		value := p.values[ref.Val]
+		if value == nil {
+			continue
+		}
+		all = append(all, point{p.time, value})
+		if f, ok := toFloat(value); ok {
+			numbers = append(numbers, f)
+		}
+	}
+	if len(all) == 0 {
+		if call.Name == "count" {
+			return 0., time.Time{}, nil
+		}
+		return nil, time.Time{}, nil
+	}
+
+	switch call.Name {
+	case "count":
+		return float64(len(all)), time.Time{}, nil
+	case "first":
+		return all[0].value, all[0].time, nil
+	case "last":
+		return all[len(all)-1].value, all[len(all)-1].time, nil
+	}
+	if len(numbers) != len(all) {
+		return nil, time.Time{}, fmt.Errorf("%s() can only be used on numeric values", call.Name)
+	}
+	switch call.Name {
+	case "sum":
+		var sum float64
+		for _, v := range numbers {
+			sum += v
+		}
+		return sum, time.Time{}, nil
+	case "mean":
+		var sum float64
+		for _, v := range numbers {
+			sum += v
+		}
+		return sum / float64(len(numbers)), time.Time{}, nil
+	case "min", "max":
+		selected := 0
+		for i, v := range numbers {
+			if (call.Name == "min" && v < numbers[selected]) || (call.Name == "max" && v > numbers[selected]) {
+				selected = i
+			}
+		}
+		return numbers[selected], all[selected].time, nil
+	case "spread":
+		min, max := numbers[0], numbers[0]
+		for _, v := range numbers {
+			min, max = math.Min(min, v), math.Max(max, v)
+		}
+		return max - min, time.Time{}, nil
+	case "stddev":
+		if len(numbers) < 2 {
+			return nil, time.Time{}, nil
+		}
+		var sum float64
+		for _, v := range numbers {
+			sum += v
+		}
+		mean := sum / float64(len(numbers))
+		var squares float64
+		for _, v := range numbers {
+			squares += (v - mean) * (v - mean)
+		}
+		return math.Sqrt(squares / float64(len(numbers)-1)), time.Time{}, nil
+	case "median":
+		return firstOf(merge.MedianOf(numbers)), time.Time{}, nil
+	case "percentile":
+		if len(call.Args) != 2 {
+			return nil, time.Time{}, fmt.Errorf("invalid number of arguments for percentile, expected 2, got %d", len(call.Args))
+		}
+		var p float64
+		switch arg := call.Args[1].(type) {
+		case *influxql.IntegerLiteral:
+			p = float64(arg.Val)
+		case *influxql.NumberLiteral:
+			p = arg.Val
+		default:
+			return nil, time.Time{}, fmt.Errorf("expected float argument in percentile()")
+		}
+		return firstOf(merge.PercentileOf(numbers, p)), time.Time{}, nil
+	}
+	return nil, time.Time{}, fmt.Errorf("InfluxQL function '%s' is not supported in the outer query of a subquery", call.Name)
+}
+
+// firstOf returns the first of the values calculated by a merge function, or nil if there are none.
+func firstOf(values []float64) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoordinator_SubQueryEvaluatesOuter(t *testing.T) {
+	one := newFakeNodeFunc(t, func(q string) string {
+		return fakeSeries(t, q, "trash", []string{"1970-01-01T00:00:00Z"}, map[string][]interface{}{
+			"sum_value_":   {6},
+			"count_value_": {3},
+		})
+	})
+	defer one.Close()
+	two := newFakeNodeFunc(t, func(q string) string {
+		return fakeSeries(t, q, "gold", []string{"1970-01-01T00:00:00Z"}, map[string][]interface{}{
+			"sum_value_":   {30},
+			"count_value_": {3},
+		})
+	})
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT max(m), min(m) + 1 AS low FROM (SELECT mean(value) AS m FROM treasures GROUP BY type)`)
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.Len(t, results[0].Series, 1)
+	assert.Equal(t, "treasures", results[0].Series[0].Name)
+	assert.Equal(t, []string{"time", "max", "low"}, results[0].Series[0].Columns)
+	assert.Equal(t, [][]interface{}{{"1970-01-01T00:00:00Z", 10., 3.}}, results[0].Series[0].Values)
+}
+
+func TestCoordinator_SubQueryOnSingleNode(t *testing.T) {
+	queries := make(chan string, 1)
+	one := newFakeNode(t, `{"results":[{"statement_id":0}]}`, nil)
+	defer one.Close()
+	two := newFakeNode(t, `{"results":[{"statement_id":0,"series":[{"name":"treasures","columns":["time","max"],"values":[["1970-01-01T00:00:00Z",10]]}]}]}`, queries)
+	defer two.Close()
+
+	c := NewCoordinator(newFakeCluster(one, two), newPartitioner())
+	stmt := mustGetSelect(`SELECT max(m) FROM (SELECT mean(value) AS m FROM treasures WHERE type = 'gold' GROUP BY time(1m))`)
+	results, err, _ := c.Handle(stmt, newQueryRequest(stmt.String()), testDB)
+	assert.NoError(t, err)
+	assert.Equal(t, 10., results[0].Series[0].Values[0][1])
+	assert.Equal(t, stmt.String(), <-queries)
+}
+
+func Test_evaluateOuter(t *testing.T) {
+	inner := []Result{{Series: []*models.Row{
+		{Name: "treasures", Tags: map[string]string{"type": "gold", "location": "a"}, Columns: []string{"time", "value"}, Values: [][]interface{}{
+			{"1970-01-01T00:00:10Z", 1.},
+			{"1970-01-01T00:02:10Z", 3.},
+		}},
+		{Name: "treasures", Tags: map[string]string{"type": "trash", "location": "a"}, Columns: []string{"time", "value"}, Values: [][]interface{}{
+			{"1970-01-01T00:00:20Z", 5.},
+			{"1970-01-01T00:02:20Z", 100.},
+		}},
+	}}}
+
+	stmt := mustGetSelect(`SELECT sum(value) FROM (SELECT value FROM treasures) WHERE value < 50 AND time < 3m GROUP BY time(1m), location fill(0)`)
+	results, err := evaluateOuter(stmt, inner, "s", time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, results[0].Series, 1)
+	assert.Equal(t, map[string]string{"location": "a"}, results[0].Series[0].Tags)
+	assert.Equal(t, [][]interface{}{{0., 6.}, {60., int64(0)}, {120., 3.}}, results[0].Series[0].Values)
+
+	stmt = mustGetSelect(`SELECT value * 2 AS double FROM (SELECT value FROM treasures) WHERE type = 'gold'`)
+	results, err = evaluateOuter(stmt, inner, "", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{"1970-01-01T00:00:10Z", 2.}, {"1970-01-01T00:02:10Z", 6.}}, results[0].Series[0].Values)
+
+	stmt = mustGetSelect(`SELECT median(value), percentile(value, 75) FROM (SELECT value FROM treasures)`)
+	results, err = evaluateOuter(stmt, inner, "s", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{0., 4., 5.}}, results[0].Series[0].Values)
+}
+
+func Test_evaluateOuterBucketLimit(t *testing.T) {
+	defer func(max int) { MaxSelectBuckets = max }(MaxSelectBuckets)
+	MaxSelectBuckets = 3
+	inner := []Result{{Series: []*models.Row{
+		{Name: "treasures", Columns: []string{"time", "value"}, Values: [][]interface{}{{"1970-01-01T00:00:10Z", 1.}}},
+	}}}
+
+	stmt := mustGetSelect(`SELECT sum(value) FROM (SELECT value FROM treasures) WHERE time < 3m GROUP BY time(1m)`)
+	_, err := evaluateOuter(stmt, inner, "s", time.Now())
+	assert.NoError(t, err)
+
+	stmt = mustGetSelect(`SELECT sum(value) FROM (SELECT value FROM treasures) WHERE time < 4m GROUP BY time(1m)`)
+	_, err = evaluateOuter(stmt, inner, "s", time.Now())
+	assert.EqualError(t, err, "max-select-buckets limit exceeded: (4/3)")
+}
@@ -48,7 +48,7 @@ func mustQuery(clnt influx.Client, cmd string) []influx.Result {
 }
 
 func getPartitionKey() cluster.PartitionKey {
-	return cluster.PartitionKey{"sharded", "treasures", []string{"type"}}
+	return cluster.PartitionKey{Database: "sharded", Measurement: "treasures", Tags: []string{"type"}}
 }
 
 func newPartitioner() *cluster.BasicPartitioner {
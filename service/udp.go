@@ -0,0 +1,150 @@
+package service
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+const udpBufferSize = 64 * 1024
+
+const (
+	// listenerWorkers is the number of batches of points that a listener writes at the same time.
+	listenerWorkers = 8
+	// listenerQueueSize is the number of batches of points that wait for a worker before new ones are dropped.
+	listenerQueueSize = 1000
+)
+
+// UDPConfig configures a listener for line protocol sent over UDP, like the UDP service of InfluxDB.
+type UDPConfig struct {
+	BindAddress     string `toml:"bind-address"`
+	Database        string `toml:"database"`
+	RetentionPolicy string `toml:"retention-policy"`
+	Precision       string `toml:"precision"`
+}
+
+// ingestFunc writes points to a database and retention policy.
+type ingestFunc func(points []models.Point, db, rp string) error
+
+// UDPListener writes the points in every packet that it receives. Packets are not acknowledged,
+// so points that can not be parsed or written are only logged. Packets that arrive while the
+// write queue is full are dropped.
+type UDPListener struct {
+	config UDPConfig
+	ingest ingestFunc
+	conn   net.PacketConn
+	queue  *pointsQueue
+}
+
+func NewUDPListener(config UDPConfig, ingest ingestFunc) *UDPListener {
+	return &UDPListener{config: config, ingest: ingest}
+}
+
+func (l *UDPListener) Open() error {
+	conn, err := net.ListenPacket("udp", l.config.BindAddress)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+	l.queue = newPointsQueue("UDP", listenerWorkers, listenerQueueSize, l.write)
+	log.Printf("Listening for UDP line protocol on %s", conn.LocalAddr())
+	return nil
+}
+
+func (l *UDPListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Dropped returns the number of packets that have been dropped because the write queue was full.
+func (l *UDPListener) Dropped() uint64 {
+	return l.queue.Dropped()
+}
+
+// Serve reads packets until the listener is closed.
+func (l *UDPListener) Serve() {
+	defer l.queue.close()
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if isClosedError(err) {
+				return
+			}
+			log.Printf("Failed to read UDP packet: %s", err)
+			continue
+		}
+		// The points refer to the bytes they were parsed from, and are written after the buffer is reused.
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		points, err := models.ParsePointsWithPrecision(packet, time.Now().UTC(), l.config.Precision)
+		if err != nil {
+			log.Printf("Failed to parse points from UDP packet: %s", err)
+		}
+		if len(points) > 0 {
+			l.queue.add(points)
+		}
+	}
+}
+
+func (l *UDPListener) write(points []models.Point) {
+	if err := l.ingest(points, l.config.Database, l.config.RetentionPolicy); err != nil {
+		log.Printf("Failed to write points from UDP: %s", err)
+	}
+}
+
+func (l *UDPListener) Close() error {
+	return l.conn.Close()
+}
+
+// pointsQueue writes batches of points with a fixed number of workers. Batches that are added while
+// the queue is full are dropped and counted, rather than piling up while the writes are slow.
+type pointsQueue struct {
+	name    string
+	batches chan []models.Point
+	dropped uint64
+	wg      sync.WaitGroup
+}
+
+func newPointsQueue(name string, workers, size int, write func(points []models.Point)) *pointsQueue {
+	q := &pointsQueue{name: name, batches: make(chan []models.Point, size)}
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer q.wg.Done()
+			for points := range q.batches {
+				write(points)
+			}
+		}()
+	}
+	return q
+}
+
+// add queues the points to be written, or drops them if the queue is full.
+func (q *pointsQueue) add(points []models.Point) {
+	select {
+	case q.batches <- points:
+	default:
+		if dropped := atomic.AddUint64(&q.dropped, 1); dropped%1000 == 1 {
+			log.Printf("Dropped points from %s as the write queue is full, %d batches have been dropped", q.name, dropped)
+		}
+	}
+}
+
+func (q *pointsQueue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+// close stops the workers after the queued points have been written. Points must not be added after it is closed.
+func (q *pointsQueue) close() {
+	close(q.batches)
+	q.wg.Wait()
+}
+
+func isClosedError(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Err.Error() == "use of closed network connection"
+}
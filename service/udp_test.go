@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPListener(t *testing.T) {
+	pointsWriter := NewMockPointsWriter()
+	handler, _, _, _ := newTestWriteHandler(pointsWriter)
+
+	listener := NewUDPListener(UDPConfig{BindAddress: "127.0.0.1:0", Database: testDB, Precision: "s"}, handler.ingest)
+	require.NoError(t, listener.Open())
+	defer listener.Close()
+	go listener.Serve()
+
+	conn, err := net.Dial("udp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("treasures,type=gold value=29 1439856000\ntreasures value=30 1439856000\n"))
+	require.NoError(t, err)
+
+	waitForPoints(t, pointsWriter, 1)
+	pointsWriter.mu.Lock()
+	defer pointsWriter.mu.Unlock()
+	assert.Equal(t, testDB, pointsWriter.lastContext.db)
+	for _, points := range pointsWriter.writtenPoints {
+		assert.Equal(t, int64(1439856000), points[0].Time().Unix())
+	}
+}
+
+func TestUDPListener_ReusedBuffer(t *testing.T) {
+	pointsWriter := NewMockPointsWriter()
+	handler, _, _, _ := newTestWriteHandler(pointsWriter)
+
+	listener := NewUDPListener(UDPConfig{BindAddress: "127.0.0.1:0", Database: testDB, Precision: "s"}, handler.ingest)
+	require.NoError(t, listener.Open())
+	defer listener.Close()
+	go listener.Serve()
+
+	conn, err := net.Dial("udp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	for i := 0; i < 20; i++ {
+		_, err = conn.Write([]byte(fmt.Sprintf("treasures,type=gold value=%d %d\n", i, 1439856000+i)))
+		require.NoError(t, err)
+	}
+
+	waitForPoints(t, pointsWriter, 20)
+	pointsWriter.mu.Lock()
+	defer pointsWriter.mu.Unlock()
+	// Every point keeps its own values although the packets were read into the same buffer.
+	for _, points := range pointsWriter.writtenPoints {
+		for _, point := range points {
+			fields, err := point.Fields()
+			require.NoError(t, err)
+			assert.EqualValues(t, point.Time().Unix()-1439856000, fields["value"])
+		}
+	}
+}
+
+func TestPointsQueue_DropsWhenFull(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	written := 0
+	queue := newPointsQueue("test", 1, 1, func(points []models.Point) {
+		if written == 0 {
+			close(started)
+			<-release
+		}
+		written++
+	})
+	points := []models.Point{newModelPoint(1)}
+	queue.add(points)
+	<-started
+	// The worker is busy with the first points, so the second ones wait and the third ones are dropped.
+	queue.add(points)
+	queue.add(points)
+	assert.Equal(t, uint64(1), queue.Dropped())
+	close(release)
+	queue.close()
+	assert.Equal(t, 2, written)
+}
+
+// waitForPoints waits until the points writer has been given n points.
+func waitForPoints(t *testing.T, pointsWriter *MockPointsWriter, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		pointsWriter.mu.Lock()
+		count := 0
+		for _, points := range pointsWriter.writtenPoints {
+			count += len(points)
+		}
+		pointsWriter.mu.Unlock()
+		if count >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d points", n)
+}
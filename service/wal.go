@@ -0,0 +1,317 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWALMaxSize     = 1 << 30
+	walSegmentSize        = 16 << 20
+	walRecordHeaderLength = 8
+)
+
+var (
+	// walRetryDelay is how long to wait before an entry that failed is relayed again. The delay is doubled
+	// after every attempt up to walMaxRetryDelay.
+	walRetryDelay    = time.Second
+	walMaxRetryDelay = time.Minute
+)
+
+// ErrWALFull is returned when there is no room for more writes in the write-ahead log.
+var ErrWALFull = errors.New("write-ahead log is full")
+
+// walEntry is a write that has been accepted by the cluster. Points are in line protocol with
+// timestamps in nanoseconds.
+type walEntry struct {
+	DB     string `json:"db"`
+	RP     string `json:"rp"`
+	Points []byte `json:"points"`
+}
+
+// WriteAheadLog saves writes on disk before they are relayed to the data nodes, so that they are not
+// lost if the process stops. Writes are appended to the current segment, and segments are relayed in
+// the order they were written and removed when all their writes have been relayed. The current segment
+// is relayed while it is written to, and is only replaced by a new one when it is full. Segments that are
+// found when the log is opened are relayed first. Entries of a segment that was not removed before the
+// process stopped may be relayed again, which overwrites the points with the same values.
+//
+// Each record in a segment has a header with the length and CRC-32 checksum of the entry, which
+// makes it possible to detect a record that was only partly written when the process stopped.
+type WriteAheadLog struct {
+	dir     string
+	maxSize int64
+
+	mu          sync.Mutex
+	size        int64
+	current     *os.File
+	currentID   uint64
+	currentSize int64
+	segments    []uint64
+	notify      chan struct{}
+
+	// relayID and relayOffset are the segment that is being relayed and how much of it has been relayed.
+	relayID     uint64
+	relayOffset int64
+}
+
+// OpenWriteAheadLog opens the log in the directory, creating it if it does not exist.
+func OpenWriteAheadLog(dir string, maxSize int64) (*WriteAheadLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &WriteAheadLog{dir: dir, maxSize: maxSize, notify: make(chan struct{}, 1)}
+	matches, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range matches {
+		var id uint64
+		if _, err := fmt.Sscanf(filepath.Base(path), "%020d.wal", &id); err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		l.segments = append(l.segments, id)
+		l.size += info.Size()
+		if id >= l.currentID {
+			l.currentID = id + 1
+		}
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+	if err := l.openSegment(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *WriteAheadLog) segmentPath(id uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d.wal", id))
+}
+
+func (l *WriteAheadLog) openSegment() error {
+	f, err := os.OpenFile(l.segmentPath(l.currentID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.current, l.currentSize = f, 0
+	return nil
+}
+
+// rotate closes the current segment so that it can be relayed and starts a new one.
+func (l *WriteAheadLog) rotate() error {
+	if err := l.current.Close(); err != nil {
+		return err
+	}
+	l.segments = append(l.segments, l.currentID)
+	l.currentID++
+	return l.openSegment()
+}
+
+// Append saves the entry and returns when it is on disk.
+func (l *WriteAheadLog) Append(entry walEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, walRecordHeaderLength+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[walRecordHeaderLength:], data)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size+int64(len(record)) > l.maxSize {
+		return ErrWALFull
+	}
+	if _, err := l.current.Write(record); err != nil {
+		return err
+	}
+	if err := l.current.Sync(); err != nil {
+		return err
+	}
+	l.size += int64(len(record))
+	l.currentSize += int64(len(record))
+	if l.currentSize >= walSegmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Size returns the number of bytes of entries that have not been relayed.
+func (l *WriteAheadLog) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+// nextSegment returns the oldest segment and the number of bytes of entries that have been written to
+// it, or -1 if it is full and will not be written to anymore.
+func (l *WriteAheadLog) nextSegment() (uint64, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.segments) > 0 {
+		return l.segments[0], -1
+	}
+	return l.currentID, l.currentSize
+}
+
+// relayed subtracts the bytes of the relayed entries from the size of the log.
+func (l *WriteAheadLog) relayed(size int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.size -= size
+}
+
+// removeSegment removes the oldest segment. The size is what remains of it that was not relayed.
+func (l *WriteAheadLog) removeSegment(id uint64, size int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.segments = l.segments[1:]
+	l.size -= size
+	return os.Remove(l.segmentPath(id))
+}
+
+// Relay sends entries to the relay function until the context is done. An entry that fails is retried
+// with an increasing delay until it has been relayed, as the following entries are not relayed before it.
+// An entry is dropped instead if the relay function returns a rejectedWriteError.
+// Relay continues where it was when it was last stopped, and must not run more than once at a time.
+func (l *WriteAheadLog) Relay(ctx context.Context, relay func(entry walEntry) error) {
+	for {
+		id, end := l.nextSegment()
+		if id != l.relayID {
+			l.relayID, l.relayOffset = id, 0
+		}
+		offset := l.relayOffset
+		if end == offset {
+			// Everything that has been written to the current segment has been relayed.
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.notify:
+			case <-time.After(walRetryDelay):
+			}
+			continue
+		}
+		next, size, err := l.relaySegment(ctx, id, offset, end, relay)
+		l.relayed(next - offset)
+		l.relayOffset, offset = next, next
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to read write-ahead log segment %d: %s", id, err)
+		}
+		if end >= 0 {
+			// The current segment is removed when it is full and all of it has been relayed.
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(walRetryDelay):
+				}
+			}
+			continue
+		}
+		if err := l.removeSegment(id, size-offset); err != nil {
+			log.Printf("Failed to remove write-ahead log segment %d: %s", id, err)
+		}
+	}
+}
+
+// relaySegment relays the entries of the segment from the offset up to the end, or the end of the file
+// if the end is negative. It returns the offset after the last entry that was relayed and the size of the file.
+func (l *WriteAheadLog) relaySegment(ctx context.Context, id uint64, offset, end int64, relay func(entry walEntry) error) (int64, int64, error) {
+	f, err := os.Open(l.segmentPath(id))
+	if err != nil {
+		return offset, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return offset, 0, err
+	}
+	if end < 0 {
+		end = info.Size()
+	}
+	reader := bufio.NewReader(io.NewSectionReader(f, offset, end-offset))
+	for {
+		entry, length, err := readWALRecord(reader)
+		if err == io.EOF {
+			return offset, info.Size(), nil
+		}
+		if err != nil {
+			// The rest of the segment can not be read, which happens if the process stopped while writing.
+			return offset, info.Size(), err
+		}
+		delay := walRetryDelay
+		for attempt := 1; ; attempt++ {
+			err := relay(entry)
+			if err == nil {
+				break
+			}
+			if _, rejected := err.(rejectedWriteError); rejected {
+				log.Printf("Dropping write to %s from write-ahead log, it was rejected: %s", entry.DB, err)
+				break
+			}
+			log.Printf("Failed to relay write to %s from write-ahead log after %d attempts, retrying in %s: %s", entry.DB, attempt, delay, err)
+			select {
+			case <-ctx.Done():
+				return offset, info.Size(), ctx.Err()
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > walMaxRetryDelay {
+				delay = walMaxRetryDelay
+			}
+		}
+		offset += length
+	}
+}
+
+// readWALRecord reads the entry of the next record and returns the length of the record.
+func readWALRecord(r io.Reader) (walEntry, int64, error) {
+	var entry walEntry
+	header := make([]byte, walRecordHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return entry, 0, errors.New("incomplete record header")
+		}
+		return entry, 0, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return entry, 0, errors.New("incomplete record")
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return entry, 0, errors.New("invalid checksum")
+	}
+	err := json.Unmarshal(data, &entry)
+	return entry, int64(walRecordHeaderLength + len(data)), err
+}
+
+// Close closes the current segment. Entries that have not been relayed are relayed when the log is opened again.
+func (l *WriteAheadLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current.Close()
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWAL(t *testing.T, maxSize int64) (*WriteAheadLog, string) {
+	dir, err := ioutil.TempDir("", "wal")
+	assert.NoError(t, err)
+	wal, err := OpenWriteAheadLog(dir, maxSize)
+	assert.NoError(t, err)
+	return wal, dir
+}
+
+// relayAll relays entries until the log is empty.
+func relayAll(t *testing.T, wal *WriteAheadLog, relay func(entry walEntry) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		wal.Relay(ctx, relay)
+		close(done)
+	}()
+	for i := 0; wal.Size() > 0; i++ {
+		if i == 100 {
+			t.Fatal("entries were not relayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestWriteAheadLog_ReplaysAfterReopen(t *testing.T) {
+	wal, dir := newTestWAL(t, DefaultWALMaxSize)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, wal.Append(walEntry{DB: "first", Points: []byte("a value=1 1")}))
+	assert.NoError(t, wal.Append(walEntry{DB: "second", Points: []byte("a value=2 2")}))
+	assert.NoError(t, wal.Close())
+
+	wal, err := OpenWriteAheadLog(dir, DefaultWALMaxSize)
+	assert.NoError(t, err)
+	defer wal.Close()
+	assert.NoError(t, wal.Append(walEntry{DB: "third", Points: []byte("a value=3 3")}))
+
+	dbs := []string{}
+	relayAll(t, wal, func(entry walEntry) error {
+		dbs = append(dbs, entry.DB)
+		return nil
+	})
+	assert.Equal(t, []string{"first", "second", "third"}, dbs)
+	assert.Equal(t, int64(0), wal.Size())
+}
+
+func TestWriteAheadLog_IgnoresIncompleteRecord(t *testing.T) {
+	wal, dir := newTestWAL(t, DefaultWALMaxSize)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, wal.Append(walEntry{DB: "first", Points: []byte("a value=1 1")}))
+	assert.NoError(t, wal.Close())
+
+	// Simulate a record that was only partly written before the process stopped.
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	f, err := os.OpenFile(matches[0], os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	wal, err = OpenWriteAheadLog(dir, DefaultWALMaxSize)
+	assert.NoError(t, err)
+	defer wal.Close()
+	dbs := []string{}
+	relayAll(t, wal, func(entry walEntry) error {
+		dbs = append(dbs, entry.DB)
+		return nil
+	})
+	assert.Equal(t, []string{"first"}, dbs)
+}
+
+func TestWriteAheadLog_RetriesUntilRelayed(t *testing.T) {
+	defer func(delay time.Duration) { walRetryDelay = delay }(walRetryDelay)
+	walRetryDelay = time.Millisecond
+	wal, dir := newTestWAL(t, DefaultWALMaxSize)
+	defer os.RemoveAll(dir)
+	defer wal.Close()
+	assert.NoError(t, wal.Append(walEntry{DB: "first", Points: []byte("a value=1 1")}))
+	assert.NoError(t, wal.Append(walEntry{DB: "second", Points: []byte("a value=2 2")}))
+
+	attempts := 0
+	dbs := []string{}
+	relayAll(t, wal, func(entry walEntry) error {
+		if entry.DB == "first" && attempts < 6 {
+			attempts++
+			return errors.New("no replica is available")
+		}
+		dbs = append(dbs, entry.DB)
+		return nil
+	})
+	assert.Equal(t, []string{"first", "second"}, dbs)
+	// The current segment is relayed in place rather than replaced by a new one.
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	assert.Len(t, matches, 1)
+
+	assert.NoError(t, wal.Append(walEntry{DB: "third", Points: []byte("a value=3 3")}))
+	relayAll(t, wal, func(entry walEntry) error {
+		dbs = append(dbs, entry.DB)
+		return nil
+	})
+	assert.Equal(t, []string{"first", "second", "third"}, dbs)
+}
+
+func TestWriteAheadLog_DropsRejectedEntries(t *testing.T) {
+	wal, dir := newTestWAL(t, DefaultWALMaxSize)
+	defer os.RemoveAll(dir)
+	defer wal.Close()
+	assert.NoError(t, wal.Append(walEntry{DB: "missing", Points: []byte("a value=1 1")}))
+	assert.NoError(t, wal.Append(walEntry{DB: "second", Points: []byte("a value=2 2")}))
+
+	dbs := []string{}
+	relayAll(t, wal, func(entry walEntry) error {
+		if entry.DB == "missing" {
+			return rejectedWriteError{errors.New("database not found: missing")}
+		}
+		dbs = append(dbs, entry.DB)
+		return nil
+	})
+	assert.Equal(t, []string{"second"}, dbs)
+}
+
+func TestWriteAheadLog_Full(t *testing.T) {
+	wal, dir := newTestWAL(t, 100)
+	defer os.RemoveAll(dir)
+	defer wal.Close()
+	assert.NoError(t, wal.Append(walEntry{DB: "first", Points: []byte("a value=1 1")}))
+	assert.Equal(t, ErrWALFull, wal.Append(walEntry{DB: "second", Points: []byte("a value=2 2")}))
+}
+
+func TestWriteHandler_WriteAheadLog(t *testing.T) {
+	pointsWriter := NewMockPointsWriter()
+	handler, _, _, _ := newTestWriteHandler(pointsWriter)
+	wal, dir := newTestWAL(t, 200)
+	defer os.RemoveAll(dir)
+	defer wal.Close()
+	handler.wal = wal
+
+	write := func() int {
+		writeUrl := fmt.Sprintf("http://localhost/write?db=%s&precision=s", testDB)
+		req := httptest.NewRequest("POST", writeUrl, strings.NewReader("treasures,type=gold value=29 1439856000"))
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, 204, write())
+	// Nothing is written to the nodes until the log is relayed.
+	assert.Equal(t, 0, pointsWriter.numNodesWithPoints())
+	assert.Equal(t, 503, write())
+
+	relayAll(t, wal, handler.relayEntry)
+	assert.Equal(t, 1, pointsWriter.numNodesWithPoints())
+	for _, points := range pointsWriter.writtenPoints {
+		assert.Equal(t, int64(1439856000), points[0].Time().Unix())
+	}
+	assert.Equal(t, 204, write())
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"compress/gzip"
 	"fmt"
 	"github.com/influxdata/influxdb/services/meta"
@@ -9,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,14 +24,18 @@ type WriteHandler struct {
 	partitioner     cluster.Partitioner
 	authService     AuthService
 	pointsWriter    PointsWriter
+	consistency     *DefaultConsistency
+	wal             *WriteAheadLog
 }
 
-func NewWriteHandler(resolver *cluster.Resolver, partitioner cluster.Partitioner, authService AuthService, pointsWriter PointsWriter) *WriteHandler {
+func NewWriteHandler(resolver *cluster.Resolver, partitioner cluster.Partitioner, authService AuthService, pointsWriter PointsWriter, consistency *DefaultConsistency) *WriteHandler {
 	return &WriteHandler{
 		resolver,
 		partitioner,
 		authService,
 		pointsWriter,
+		consistency,
+		nil,
 	}
 }
 
@@ -49,6 +55,20 @@ func (h *WriteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if precision == "" {
 		precision = "nanoseconds"
 	}
+	h.serveWrite(w, r, db, rp, precision)
+}
+
+// serveWrite writes the points in the body of the request to the database and retention policy.
+func (h *WriteHandler) serveWrite(w http.ResponseWriter, r *http.Request, db, rp, precision string) {
+	consistency := h.consistency.Get()
+	if level := r.URL.Query().Get("consistency"); level != "" {
+		var err error
+		consistency, err = ParseConsistencyLevel(level)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 	body := r.Body
 
 	// Handle gzip decoding of the body
@@ -70,12 +90,15 @@ func (h *WriteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	buf := bytes.NewBuffer(bs)
 	_, err := buf.ReadFrom(body)
-
-	points, err := models.ParsePoints(buf.Bytes())
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, "unable to parse points")
 		return
 	}
+	points, lines, dropped := parseLines(buf.Bytes(), precision)
+	if len(points) == 0 && len(dropped) > 0 {
+		jsonError(w, http.StatusBadRequest, strings.Join(dropped, "\n"))
+		return
+	}
 
 	if h.authService != nil {
 		user, err := authenticate(r, h.authService)
@@ -100,49 +123,132 @@ func (h *WriteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	pointGroups, err := partitionPoints(points, h.partitioner, db)
-	if err != nil {
-		switch err.(type) {
-		case partitionValidationError:
-			jsonError(w, http.StatusBadRequest, err.Error())
-		default:
-			jsonError(w, http.StatusInternalServerError, err.Error())
+	pointGroups, rejected := partitionPoints(points, h.partitioner, db)
+	for i := range points {
+		if err, ok := rejected[i]; ok {
+			dropped = append(dropped, fmt.Sprintf("%s at line %d", err, lines[i]))
+		}
+	}
+
+	if h.wal != nil {
+		// The points are relayed from the log, so the write is done once they are saved in it.
+		valid := make([]models.Point, 0, len(points)-len(rejected))
+		for i, point := range points {
+			if _, ok := rejected[i]; !ok {
+				valid = append(valid, point)
+			}
 		}
+		if len(valid) > 0 {
+			err := h.wal.Append(walEntry{DB: db, RP: rp, Points: convertPointToBytes(valid, "n")})
+			if err == ErrWALFull {
+				jsonError(w, http.StatusServiceUnavailable, err.Error())
+				return
+			} else if err != nil {
+				jsonError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+	} else {
+		writeContext := WriteContext{
+			precision: precision,
+			db:        db,
+			rp:        rp,
+		}
+		if writeErr := h.writeGroups(pointGroups, writeContext, consistency); writeErr != nil {
+			jsonError(w, http.StatusInternalServerError, fmt.Sprintf("One ore more writes failed: %s", writeErr.Error()))
+			return
+		}
+	}
+	if len(dropped) > 0 {
+		// The valid points have been written, like InfluxDB does with partial writes.
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("partial write: %s dropped=%d", strings.Join(dropped, "\n"), len(dropped)))
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	// auth := r.Header.Get("Authorization")
-	// TODO Handle the case that the underlying InfluxDB instances requires authentication.
+// replicaGroup is a group of points that are written to the same replicas. The replicas of a partition
+// depend on the replication factor of the measurement, which may differ between measurements of a database.
+type replicaGroup struct {
+	hash  int
+	scope cluster.ReplicationScope
+}
 
-	writeContext := WriteContext{
-		precision: precision,
-		db:        db,
-		rp:        rp,
+// writeGroups writes each group of points to the replicas of its partition.
+func (h *WriteHandler) writeGroups(pointGroups map[int][]models.Point, writeContext WriteContext, consistency ConsistencyLevel) error {
+	groups := map[replicaGroup][]models.Point{}
+	for numericHash, points := range pointGroups {
+		for _, point := range points {
+			group := replicaGroup{numericHash, h.resolver.ReplicationScopeOf(writeContext.db, string(point.Name()))}
+			groups[group] = append(groups[group], point)
+		}
 	}
 
 	wg := sync.WaitGroup{}
-	wg.Add(len(pointGroups))
+	wg.Add(len(groups))
+	var mu sync.Mutex
 	var writeErr error
-	for numericHash, points := range pointGroups {
-		go (func(numericHash int, points []models.Point) {
-			locations := h.resolver.FindNodesByKey(numericHash, cluster.WRITE)
-			relayErr := h.pointsWriter.WritePoints(points, locations, writeContext)
+	for group, points := range groups {
+		go (func(group replicaGroup, points []models.Point) {
+			defer wg.Done()
+			locations := h.resolver.FindNodesByKey(group.hash, group.scope.Database, group.scope.Measurement, cluster.WRITE)
+			relayErr := writeWithConsistency(h.pointsWriter, points, locations, writeContext, consistency)
 			if relayErr != nil {
-				writeErr = relayErr
+				mu.Lock()
+				// An error that may not happen again is kept over a rejection of the points.
+				if _, rejected := writeErr.(rejectedWriteError); writeErr == nil || rejected {
+					writeErr = relayErr
+				}
+				mu.Unlock()
 				log.Printf("Failed to write: %s\n", relayErr.Error())
 			}
-			wg.Done()
-		})(numericHash, points)
+		})(group, points)
 	}
 	wg.Wait()
+	return writeErr
+}
 
-	if writeErr != nil {
-		jsonError(w, http.StatusInternalServerError, fmt.Sprintf("One ore more writes failed: %s", writeErr.Error()))
-		return
+// UseWriteAheadLog makes the handler save writes in the log and relay them from there until the context is done.
+func (h *WriteHandler) UseWriteAheadLog(ctx context.Context, wal *WriteAheadLog) {
+	h.wal = wal
+	go wal.Relay(ctx, h.relayEntry)
+}
+
+// relayEntry writes the points of an entry from the write-ahead log. Points are only required to be written
+// or saved for recovery by one replica, as the entry would otherwise be retried for all of them. The error
+// is a rejectedWriteError if the points were rejected, in which case the entry is not retried.
+func (h *WriteHandler) relayEntry(entry walEntry) error {
+	points, err := models.ParsePoints(entry.Points)
+	if err != nil {
+		// Retrying would not help, as the points were valid when they were saved.
+		return rejectedWriteError{err}
 	}
-	fmt.Printf("Wrote %d points", len(points))
+	pointGroups, rejected := partitionPoints(points, h.partitioner, entry.DB)
+	for _, err := range rejected {
+		log.Printf("Dropping point from write-ahead log: %s", err)
+	}
+	return h.writeGroups(pointGroups, WriteContext{precision: "n", db: entry.DB, rp: entry.RP}, ConsistencyAny)
+}
 
-	w.WriteHeader(http.StatusNoContent)
+// ingest writes points received by a listener. Points that do not have the tags of their partition key are dropped.
+func (h *WriteHandler) ingest(points []models.Point, db, rp string) error {
+	pointGroups, rejected := partitionPoints(points, h.partitioner, db)
+	for _, err := range rejected {
+		log.Printf("Dropping point: %s", err)
+	}
+	if h.wal != nil {
+		valid := make([]models.Point, 0, len(points)-len(rejected))
+		for i, point := range points {
+			if _, ok := rejected[i]; !ok {
+				valid = append(valid, point)
+			}
+		}
+		if len(valid) == 0 {
+			return nil
+		}
+		return h.wal.Append(walEntry{DB: db, RP: rp, Points: convertPointToBytes(valid, "n")})
+	}
+	return h.writeGroups(pointGroups, WriteContext{precision: "n", db: db, rp: rp}, h.consistency.Get())
 }
 
 type HttpPointsWriter struct {
@@ -151,12 +257,13 @@ type HttpPointsWriter struct {
 }
 
 func NewHttpPointsWriter(recoveryStorage cluster.RecoveryStorage) *HttpPointsWriter {
-	return &HttpPointsWriter{&http.Client{Timeout: 10 * time.Second}, recoveryStorage}
+	return &HttpPointsWriter{cluster.NewDataClient(10 * time.Second), recoveryStorage}
 }
 
 func (w *HttpPointsWriter) WritePoints(points []models.Point, locations []*cluster.Node, wc WriteContext) error {
-	data := convertPointToBytes(points, wc.precision)
-	relayErr := w.relayToLocations(locations, "", data, wc.db, wc.rp)
+	// Timestamps are always written in nanoseconds, as data saved for recovery does not keep the precision.
+	data := convertPointToBytes(points, "n")
+	relayErr := w.relayToLocations(locations, data, wc.db, wc.rp)
 	if relayErr != nil {
 		log.Printf("Failed to write: %s\n", relayErr.Error())
 	}
@@ -171,51 +278,70 @@ func convertPointToBytes(points []models.Point, precision string) []byte {
 	return []byte(pointsString)
 }
 
-func (w *HttpPointsWriter) relayToLocations(nodes []*cluster.Node, auth string, buf []byte, db, rp string) error {
-	var err error
+// relayToLocations writes the data to all nodes in parallel. Data that can not be sent to a node
+// is saved in the recovery storage so that it can be written when the node is available again.
+func (w *HttpPointsWriter) relayToLocations(nodes []*cluster.Node, buf []byte, db, rp string) error {
+	errs := make(chan error, len(nodes))
 	for _, node := range nodes {
-		location := node.DataLocation
+		go func(node *cluster.Node) {
+			errs <- w.relayToNode(node, buf, db, rp)
+		}(node)
+	}
+	var err error
+	for range nodes {
+		if nodeErr := <-errs; nodeErr != nil {
+			err = nodeErr
+		}
+	}
+	return err
+}
+
+func (w *HttpPointsWriter) relayToNode(node *cluster.Node, buf []byte, db, rp string) error {
+	location := node.DataLocation
+
+	var url string
+	if rp != "" {
+		url = fmt.Sprintf("%s/write?db=%s&rp=%s", cluster.DataURL(location), db, rp)
+	} else {
+		url = fmt.Sprintf("%s/write?db=%s", cluster.DataURL(location), db)
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
 
-		// TODO Create a proper http client for requesting InfluxDB to also support SSL and authentication
-		var url string
-		if rp != "" {
-			url = fmt.Sprintf("http://%s/write?db=%s&rp=%s", location, db, rp)
-		} else {
-			url = fmt.Sprintf("http://%s/write?db=%s", location, db)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Length", strconv.Itoa(len(buf)))
+	cluster.AuthorizeDataRequest(req, location)
+	resp, err := w.client.Do(req)
+	if err != nil {
+		rErr := w.recoveryStorage.Put(node.Name, db, rp, buf)
+		if rErr != nil {
+			log.Printf("Recovery storage failed: %s\n", rErr.Error())
+			return err
 		}
-		req, err := http.NewRequest("POST", url, bytes.NewReader(buf))
+		return hintedWriteError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 204 {
+		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
 		}
-
-		req.Header.Set("Content-Type", "text/plain")
-		req.Header.Set("Content-Length", strconv.Itoa(len(buf)))
-		if auth != "" {
-			req.Header.Set("Authorization", auth)
-		}
-		resp, responseErr := w.client.Do(req)
-		if responseErr != nil {
-			rErr := w.recoveryStorage.Put(node.Name, db, rp, buf)
-			if rErr != nil {
-				log.Printf("Recovery storage failed: %s\n", rErr.Error())
-			}
-			err = responseErr
-		} else if resp.StatusCode != 204 {
-			body, rErr := ioutil.ReadAll(resp.Body)
-			if rErr != nil {
-				log.Fatal(rErr)
-			}
-			err = fmt.Errorf("received error from InfluxDB at %s: %s", location, string(body))
+		err = fmt.Errorf("received error from InfluxDB at %s: %s", location, string(body))
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return rejectedWriteError{err}
 		}
+		return err
 	}
-	return err
+	return nil
 }
 
 const maxWriteRetries = 10
 const retryTimeoutSeconds = 5
 
 func (h *WriteHandler) retryWrite(req *http.Request) bool {
-	client := http.Client{Timeout: time.Second * 5}
+	client := cluster.NewDataClient(time.Second * 5)
 	retries := 0
 	for {
 		retries += 1
@@ -4,11 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/adamringhede/influxdb-ha/hash"
 	"github.com/influxdata/influxdb/models"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -35,6 +38,84 @@ func TestRouting(t *testing.T) {
 	assert.Len(t, result[0].Series[0].Values, 2)*/
 }
 
+func TestPartialWrite(t *testing.T) {
+	pointsWriter := NewMockPointsWriter()
+	handler, _, _, _ := newTestWriteHandler(pointsWriter)
+
+	lines := "treasures,type=gold value=29 1439856000\n" +
+		"treasures,type=gold value=\n" +
+		"treasures value=29 1439856000\n" +
+		"treasures,type=silver value=30 1439856000"
+	writeUrl := fmt.Sprintf("http://localhost/write?db=%s&precision=s", testDB)
+	req := httptest.NewRequest("POST", writeUrl, strings.NewReader(lines))
+	req.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "partial write: ")
+	assert.Contains(t, body, "at line 2")
+	assert.Contains(t, body, "requires the tags [type] at line 3")
+	assert.Contains(t, body, "dropped=2")
+
+	written := []models.Point{}
+	for _, points := range pointsWriter.writtenPoints {
+		written = append(written, points...)
+	}
+	assert.Len(t, written, 2)
+	assert.Equal(t, int64(1439856000), written[0].Time().Unix())
+}
+
+func TestPartitionPointsDraining(t *testing.T) {
+	partitioner := cluster.NewPartitioner()
+	key := cluster.PartitionKey{Database: testDB, Measurement: "treasures", Tags: []string{"type"}}
+	partitioner.AddKey(key)
+	points, err := models.ParsePointsString("treasures,type=gold value=29 1439856000")
+	assert.NoError(t, err)
+
+	groups, rejected := partitionPoints(points, partitioner, testDB)
+	assert.Len(t, rejected, 0)
+	assert.Len(t, groups, 1)
+
+	key.Status = cluster.PartitionKeyStatusDraining
+	partitioner.AddKey(key)
+	groups, rejected = partitionPoints(points, partitioner, testDB)
+	assert.Len(t, rejected, 0)
+	assert.Len(t, groups, 2)
+	dbHash := int(hash.String(cluster.CreatePartitionKeyIdentifier(testDB, "")))
+	assert.Len(t, groups[dbHash], 1)
+}
+
+func TestPartitionPointsResharding(t *testing.T) {
+	partitioner := cluster.NewPartitioner()
+	key := cluster.PartitionKey{Database: testDB, Measurement: "treasures", Tags: []string{"type"},
+		MigrationTags: []string{"captain"}, Status: cluster.PartitionKeyStatusResharding}
+	partitioner.AddKey(key)
+	points, err := models.ParsePointsString("treasures,type=gold,captain=flint value=29 1439856000\n" +
+		"treasures,type=gold value=29 1439856000")
+	assert.NoError(t, err)
+
+	groups, rejected := partitionPoints(points, partitioner, testDB)
+	assert.Len(t, rejected, 1)
+	assert.Contains(t, rejected[1].Error(), "is being changed and requires the tags [captain]")
+	assert.Len(t, groups, 2)
+	newHash, _ := cluster.GetHash(key.MigrationKey(), map[string][]string{"captain": {"flint"}})
+	assert.Len(t, groups[newHash], 1)
+}
+
+func TestSplitLines(t *testing.T) {
+	lines := splitLines([]byte("a,t=x v=\"first\nsecond\" 1\n  b\\ c v=1\nc v=\"\\\"\n\""))
+	assert.Equal(t, []string{"a,t=x v=\"first\nsecond\" 1", "  b\\ c v=1", "c v=\"\\\"\n\""}, toStrings(lines))
+}
+
+func toStrings(lines [][]byte) []string {
+	strs := make([]string, len(lines))
+	for i, line := range lines {
+		strs[i] = string(line)
+	}
+	return strs
+}
+
 func BenchmarkRouting(b *testing.B) {
 	pointsWriter := NewMockPointsWriter()
 
@@ -65,7 +146,7 @@ func newTestWriteHandler(pointsWriter PointsWriter) (*WriteHandler, *cluster.Res
 
 	resolver := newTestResolver()
 	partitioner := newPartitioner()
-	handler := NewWriteHandler(resolver, partitioner, authService, pointsWriter)
+	handler := NewWriteHandler(resolver, partitioner, authService, pointsWriter, NewDefaultConsistency(DefaultConsistencyLevel))
 	return handler, resolver, partitioner, authService
 }
 
@@ -75,14 +156,21 @@ TODO Test saving to recovery with mocked recovery storage
 */
 
 type MockPointsWriter struct {
+	mu            sync.Mutex
 	writtenPoints map[string][]models.Point
+	writes        int
+	lastContext   WriteContext
 }
 
 func NewMockPointsWriter() *MockPointsWriter {
-	return &MockPointsWriter{map[string][]models.Point{}}
+	return &MockPointsWriter{writtenPoints: map[string][]models.Point{}}
 }
 
 func (w *MockPointsWriter) WritePoints(points []models.Point, locations []*cluster.Node, writeContext WriteContext) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes++
+	w.lastContext = writeContext
 	for _, node := range locations {
 		if _, ok := w.writtenPoints[node.Name]; !ok {
 			w.writtenPoints[node.Name] = []models.Point{}
@@ -130,4 +218,24 @@ func (rs *MockRecoveryStorage) Drop(nodeName string) error {
 
 func (rs *MockRecoveryStorage) hasData() bool {
 	return len(rs.data) > 0
-}
\ No newline at end of file
+}
+func TestHttpPointsWriter_RejectedPoints(t *testing.T) {
+	codes := map[string]int{"missing": http.StatusNotFound, "conflict": http.StatusBadRequest, "broken": http.StatusInternalServerError}
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonError(w, codes[r.URL.Query().Get("db")], "failed")
+	}))
+	defer node.Close()
+	writer := NewHttpPointsWriter(nil)
+	nodes := []*cluster.Node{{Name: "a", DataLocation: strings.TrimPrefix(node.URL, "http://")}}
+	points := []models.Point{newModelPoint(1)}
+
+	for _, db := range []string{"missing", "conflict"} {
+		err := writer.WritePoints(points, nodes, WriteContext{db: db})
+		assert.IsType(t, rejectedWriteError{}, err, db)
+	}
+	// Errors of the node itself may not happen again.
+	err := writer.WritePoints(points, nodes, WriteContext{db: "broken"})
+	assert.Error(t, err)
+	_, rejected := err.(rejectedWriteError)
+	assert.False(t, rejected)
+}
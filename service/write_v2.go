@@ -0,0 +1,54 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+)
+
+// V2WriteHandler accepts writes to the /api/v2/write endpoint of InfluxDB 2.0. The bucket is the database
+// and optionally the retention policy as "database/retention-policy", and users are authenticated
+// with a token in the form "username:password". The organization is not used.
+type V2WriteHandler struct {
+	writeHandler *WriteHandler
+}
+
+func NewV2WriteHandler(writeHandler *WriteHandler) *V2WriteHandler {
+	return &V2WriteHandler{writeHandler}
+}
+
+func (h *V2WriteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	bucket := query.Get("bucket")
+	if bucket == "" {
+		jsonError(w, http.StatusBadRequest, "missing parameter: bucket")
+		return
+	}
+	db, rp := bucket, ""
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		db, rp = bucket[:i], bucket[i+1:]
+	}
+	if db == "" {
+		jsonError(w, http.StatusBadRequest, "invalid bucket: "+bucket)
+		return
+	}
+
+	precision, ok := v2Precisions[query.Get("precision")]
+	if !ok {
+		jsonError(w, http.StatusBadRequest, "invalid precision: "+query.Get("precision"))
+		return
+	}
+	h.writeHandler.serveWrite(w, r, db, rp, precision)
+}
+
+// v2Precisions maps the precisions of InfluxDB 2.0 to those of line protocol in InfluxDB 1.x.
+var v2Precisions = map[string]string{
+	"":   "n",
+	"ns": "n",
+	"us": "u",
+	"ms": "ms",
+	"s":  "s",
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestV2WriteHandler(t *testing.T) {
+	pointsWriter := NewMockPointsWriter()
+	writeHandler, _, _, _ := newTestWriteHandler(pointsWriter)
+	handler := NewV2WriteHandler(writeHandler)
+
+	write := func(bucket, token string) int {
+		writeUrl := fmt.Sprintf("http://localhost/api/v2/write?org=ignored&bucket=%s&precision=s", bucket)
+		req := httptest.NewRequest("POST", writeUrl, strings.NewReader("treasures,type=gold value=29 1439856000"))
+		req.Header.Set("Authorization", "Token "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, 204, write(testDB+"/autogen", "admin:secret"))
+	assert.Equal(t, WriteContext{precision: "s", db: testDB, rp: "autogen"}, pointsWriter.lastContext)
+	for _, points := range pointsWriter.writtenPoints {
+		assert.Equal(t, int64(1439856000), points[0].Time().Unix())
+	}
+
+	assert.Equal(t, 204, write(testDB, "admin:secret"))
+	assert.Equal(t, WriteContext{precision: "s", db: testDB, rp: ""}, pointsWriter.lastContext)
+
+	assert.Equal(t, 401, write(testDB, "admin:wrong"))
+	assert.Equal(t, 401, write(testDB, "admin"))
+	assert.Equal(t, 400, write("", "admin:secret"))
+	assert.Equal(t, 400, write("/autogen", "admin:secret"))
+}
@@ -0,0 +1,232 @@
+package syncing
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/adamringhede/influxdb-ha/hash"
+)
+
+const (
+	PartitionKeyDrainWorkName = "drain"
+)
+
+// DrainDelay is the time between marking a partition key as draining and starting to move its data,
+// so that every node has seen the status and writes new points to the nodes of the database as well.
+var DrainDelay = time.Minute
+
+type DrainPayload struct {
+	Database    string
+	Measurement string
+	// NotBefore is the time when the data starts being moved.
+	NotBefore time.Time
+}
+
+type DrainCheckpoint struct {
+	// Imported holds the names of the nodes that have imported the data they hold without the key.
+	Imported []string
+	// Dropped is set when the key has been removed and reads are routed by the database.
+	Dropped bool
+}
+
+// PartitionKeyDrainer drops partition keys in stages. While a key is draining, points are written
+// both by the key and by the database. Every node first imports the data of the key that it should
+// have according to the hash of the database, after which the key is removed so that reads are
+// routed by the database. Finally the copies on nodes that no longer should have them are deleted.
+type PartitionKeyDrainer struct {
+	wq            cluster.WorkQueue
+	resolver      *cluster.Resolver
+	partitionKeys cluster.PartitionKeyCollection
+	storage       cluster.PartitionKeyStorage
+	localNode     string
+	stopChan      chan bool
+}
+
+func NewPartitionKeyDrainer(wq cluster.WorkQueue, resolver *cluster.Resolver, partitionKeys cluster.PartitionKeyCollection,
+	storage cluster.PartitionKeyStorage, localNode string) *PartitionKeyDrainer {
+	return &PartitionKeyDrainer{wq, resolver, partitionKeys, storage, localNode, make(chan bool)}
+}
+
+// Drain creates a task for dropping the key, which has to be saved as draining first.
+func (d *PartitionKeyDrainer) Drain(key cluster.PartitionKey) error {
+	d.wq.Push(d.localNode, DrainPayload{key.Database, key.Measurement, time.Now().Add(DrainDelay)})
+	return nil
+}
+
+func (d *PartitionKeyDrainer) Start() {
+	tasks := d.wq.Subscribe()
+	for {
+		select {
+		case task := <-tasks:
+			var checkpoint DrainCheckpoint
+			var payload DrainPayload
+			err := task.Unmarshal(&payload, &checkpoint)
+			if err != nil {
+				log.Printf("Failed to unmarshal task data. Payload %s, Checkpoint: %s",
+					string(task.Payload), string(task.Checkpoint))
+				continue
+			}
+			d.process(task.ID, payload, checkpoint)
+		case <-d.stopChan:
+			return
+		}
+	}
+}
+
+func (d *PartitionKeyDrainer) Stop() {
+	d.wq.Unsubscribe()
+	close(d.stopChan)
+}
+
+func (d *PartitionKeyDrainer) process(taskID string, payload DrainPayload, checkpoint DrainCheckpoint) {
+	name := cluster.CreatePartitionKeyIdentifier(payload.Database, payload.Measurement)
+	log.Printf("Processing task: DrainPartitionKey %s (%s)", name, taskID)
+
+	task := cluster.Task{}
+	task.ID = taskID
+	task.Payload = payload
+
+	if !waitOrStop(time.Until(payload.NotBefore), d.stopChan) {
+		return
+	}
+
+	key, err := findPartitionKey(d.storage, payload.Database, payload.Measurement)
+	if err != nil {
+		log.Printf("Failed to drain partition key on %s: %s", name, err)
+		return
+	}
+	if !checkpoint.Dropped && (key == nil || !key.Draining()) {
+		log.Printf("Partition key on %s is no longer draining", name)
+		d.wq.Complete(task)
+		return
+	}
+
+	match := func(node *cluster.Node, replica bool) func(db, msmt string) bool {
+		return func(db, msmt string) bool {
+			return drains(payload.Database, payload.Measurement, d.partitionKeys.GetPartitionKeys(), db, msmt) &&
+				d.isReplica(node, db, msmt) == replica
+		}
+	}
+
+	if !checkpoint.Dropped {
+		importer := NewImporter(d.resolver, d.partitionKeys, AlwaysNoImport)
+		nodes := d.resolver.FindAllNodes()
+		for _, node := range nodes {
+			if contains(checkpoint.Imported, node.Name) {
+				continue
+			}
+			target, err := NewInfluxClientHTTPFromNode(*node)
+			if err != nil {
+				log.Printf("Failed to drain partition key on %s: %s", name, err)
+				return
+			}
+			// The key is only dropped once every node has all of its data, so the task is left to be
+			// processed again rather than moving on without the data of a node.
+			if err := importer.ImportMeasurements(target, match(node, true)); err != nil {
+				log.Printf("Failed to import data of partition key on %s to %s: %s", name, node.Name, err)
+				return
+			}
+			checkpoint.Imported = append(checkpoint.Imported, node.Name)
+			task.Checkpoint = checkpoint
+			d.wq.CheckIn(task)
+			saveProgress(d.storage, key, fmt.Sprintf("imported data to %d of %d nodes", len(checkpoint.Imported), len(nodes)))
+		}
+
+		if err := d.storage.Drop(payload.Database, payload.Measurement); err != nil {
+			log.Printf("Failed to drop partition key on %s: %s", name, err)
+			return
+		}
+		checkpoint.Dropped = true
+		task.Checkpoint = checkpoint
+		d.wq.CheckIn(task)
+
+		// Reads may still be routed by the key until every node has seen that it is dropped.
+		if !waitOrStop(DrainDelay, d.stopChan) {
+			return
+		}
+	}
+
+	// A new importer is used as the measurements on the nodes have changed.
+	importer := NewImporter(d.resolver, d.partitionKeys, AlwaysNoImport)
+	for _, node := range d.resolver.FindAllNodes() {
+		location, err := NewInfluxClientHTTPFromNode(*node)
+		if err == nil {
+			err = importer.DeleteMeasurements(location, match(node, false))
+		}
+		if err != nil {
+			log.Printf("Failed to delete data of partition key on %s at %s: %s", name, node.Name, err)
+		}
+	}
+	log.Printf("Dropped partition key on %s", name)
+	d.wq.Complete(task)
+}
+
+func findPartitionKey(storage cluster.PartitionKeyStorage, db, msmt string) (*cluster.PartitionKey, error) {
+	keys, err := storage.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partition keys: %s", err)
+	}
+	for _, key := range keys {
+		if key.Database == db && key.Measurement == msmt {
+			return key, nil
+		}
+	}
+	return nil, nil
+}
+
+// saveProgress records how far the key has been changed, which is shown by SHOW PARTITION KEYS.
+func saveProgress(storage cluster.PartitionKeyStorage, key *cluster.PartitionKey, progress string) {
+	key.Progress = progress
+	if err := storage.Save(key); err != nil {
+		log.Printf("Failed to save progress of partition key on %s: %s", key.Identifier(), err)
+	}
+}
+
+// waitOrStop returns false if the worker is stopped before the duration has passed.
+func waitOrStop(d time.Duration, stop <-chan bool) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// isReplica returns true if the node should have the data of the measurement when it is not partitioned.
+func (d *PartitionKeyDrainer) isReplica(node *cluster.Node, db, msmt string) bool {
+	key := hash.String(cluster.CreatePartitionKeyIdentifier(db, ""))
+	for _, replica := range d.resolver.FindNodesByKey(int(key), db, msmt, cluster.WRITE) {
+		if replica.Name == node.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// drains returns true if the measurement is partitioned by the key on the database and measurement.
+// A key on a database is not used by measurements that have a key of their own.
+func drains(keyDB, keyMsmt string, keys []cluster.PartitionKey, db, msmt string) bool {
+	if db != keyDB {
+		return false
+	}
+	if keyMsmt != "" {
+		return msmt == keyMsmt
+	}
+	for _, pk := range keys {
+		if pk.Database == db && pk.Measurement == msmt {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
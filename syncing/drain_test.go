@@ -0,0 +1,228 @@
+package syncing
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrains(t *testing.T) {
+	keys := []cluster.PartitionKey{
+		{Database: "db", Tags: []string{"type"}},
+		{Database: "db", Measurement: "cpu", Tags: []string{"host"}},
+	}
+	assert.True(t, drains("db", "cpu", keys, "db", "cpu"))
+	assert.False(t, drains("db", "cpu", keys, "db", "mem"))
+	assert.False(t, drains("db", "cpu", keys, "other", "cpu"))
+
+	// Measurements with a key of their own keep it when the key of the database is dropped.
+	assert.True(t, drains("db", "", keys, "db", "mem"))
+	assert.False(t, drains("db", "", keys, "db", "cpu"))
+}
+
+// testNode is a data node that holds a point of cpu in db. It records the points written to it and
+// the series that are dropped.
+type testNode struct {
+	*httptest.Server
+	failWrites bool
+
+	mu        sync.Mutex
+	writes    int
+	drops     []string
+	droppedAt time.Time
+}
+
+func newTestNode() *testNode {
+	n := &testNode{}
+	n.Server = httptest.NewServer(http.HandlerFunc(n.serve))
+	return n
+}
+
+func (n *testNode) serve(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if r.URL.Path == "/write" {
+		if n.failWrites {
+			http.Error(w, `{"error":"timeout"}`, http.StatusInternalServerError)
+			return
+		}
+		n.writes++
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var series string
+	q := r.FormValue("q")
+	switch {
+	case q == "SHOW DATABASES":
+		series = `[{"name":"databases","columns":["name"],"values":[["db"]]}]`
+	case q == "SHOW MEASUREMENTS":
+		series = `[{"name":"measurements","columns":["name"],"values":[["cpu"]]}]`
+	case q == "SHOW RETENTION POLICIES":
+		series = `[{"columns":["name","duration","shardGroupDuration","replicaN","default"],"values":[["autogen","0s","168h0m0s",1,true]]}]`
+	case q == "SHOW TAG KEYS":
+		series = `[{"name":"cpu","columns":["tagKey"],"values":[["host"]]}]`
+	case strings.HasPrefix(q, "SHOW SERIES") && strings.HasSuffix(q, "OFFSET 0"):
+		series = `[{"columns":["key"],"values":[["cpu,host=a"]]}]`
+	case strings.HasPrefix(q, "SELECT") && strings.HasSuffix(q, "OFFSET 0"):
+		series = `[{"name":"cpu","columns":["time","host","value"],"values":[[1,"a",1]]}]`
+	case strings.HasPrefix(q, "DROP SERIES"):
+		n.drops = append(n.drops, q)
+		n.droppedAt = time.Now()
+	}
+	if series == "" {
+		series = "[]"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"results":[{"statement_id":0,"series":%s}]}`, series)
+}
+
+type testPartitionKeyStorage struct {
+	keys      []*cluster.PartitionKey
+	droppedAt time.Time
+}
+
+func (s *testPartitionKeyStorage) Watch() clientv3.WatchChan {
+	return make(<-chan clientv3.WatchResponse)
+}
+
+func (s *testPartitionKeyStorage) Save(key *cluster.PartitionKey) error {
+	for i, pk := range s.keys {
+		if pk.Identifier() == key.Identifier() {
+			s.keys[i] = key
+			return nil
+		}
+	}
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func (s *testPartitionKeyStorage) Drop(db, msmt string) error {
+	for i, pk := range s.keys {
+		if pk.Database == db && pk.Measurement == msmt {
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			s.droppedAt = time.Now()
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *testPartitionKeyStorage) GetAll() ([]*cluster.PartitionKey, error) {
+	return s.keys, nil
+}
+
+type testWorkQueue struct {
+	checkIns  []cluster.Task
+	completed bool
+}
+
+func (wq *testWorkQueue) Push(target string, payload interface{}) {}
+
+func (wq *testWorkQueue) Drop(task cluster.Task) {}
+
+func (wq *testWorkQueue) Subscribe() <-chan cluster.TaskData {
+	return nil
+}
+
+func (wq *testWorkQueue) Unsubscribe() {}
+
+func (wq *testWorkQueue) CheckIn(task cluster.Task) {
+	wq.checkIns = append(wq.checkIns, task)
+}
+
+func (wq *testWorkQueue) Complete(task cluster.Task) {
+	wq.completed = true
+}
+
+// newTestCluster creates a resolver of two nodes that each hold one replica of the data.
+func newTestCluster() (*cluster.Resolver, map[string]*testNode) {
+	resolver := cluster.NewResolver()
+	resolver.SetReplicationFactor(cluster.ReplicationScope{}, 1)
+	nodes := map[string]*testNode{}
+	for i, name := range []string{"a", "b"} {
+		node := newTestNode()
+		resolver.AddToken(i<<31, &cluster.Node{Status: cluster.NodeStatusUp, DataLocation: node.URL, Name: name})
+		nodes[name] = node
+	}
+	return resolver, nodes
+}
+
+func closeTestCluster(nodes map[string]*testNode) {
+	for _, node := range nodes {
+		node.Close()
+	}
+}
+
+func newTestDrainer(key cluster.PartitionKey) (*PartitionKeyDrainer, *testWorkQueue, *testPartitionKeyStorage, map[string]*testNode) {
+	resolver, nodes := newTestCluster()
+	partitioner := cluster.NewPartitioner()
+	partitioner.AddKey(key)
+	wq := &testWorkQueue{}
+	storage := &testPartitionKeyStorage{keys: []*cluster.PartitionKey{&key}}
+	return NewPartitionKeyDrainer(wq, resolver, partitioner, storage, "a"), wq, storage, nodes
+}
+
+// replicaOf returns the name of the node that should have the data of the database and the name of the other.
+func (d *PartitionKeyDrainer) replicaOf(db, msmt string) (string, string) {
+	nodes := d.resolver.FindAllNodes()
+	if d.isReplica(nodes[0], db, msmt) {
+		return nodes[0].Name, nodes[1].Name
+	}
+	return nodes[1].Name, nodes[0].Name
+}
+
+func TestPartitionKeyDrainer_Process(t *testing.T) {
+	defer func(delay time.Duration) { DrainDelay = delay }(DrainDelay)
+	DrainDelay = 50 * time.Millisecond
+
+	d, wq, storage, nodes := newTestDrainer(cluster.PartitionKey{Database: "db", Tags: []string{"host"},
+		Status: cluster.PartitionKeyStatusDraining})
+	defer closeTestCluster(nodes)
+	replica, other := d.replicaOf("db", "cpu")
+
+	d.process("task", DrainPayload{Database: "db"}, DrainCheckpoint{})
+
+	// The node that should have the data imports it from the other, which then deletes its copy
+	// once every node has seen that the key is dropped.
+	assert.Equal(t, 1, nodes[replica].writes)
+	assert.Equal(t, 0, nodes[other].writes)
+	assert.Empty(t, storage.keys)
+	assert.Empty(t, nodes[replica].drops)
+	assert.Equal(t, []string{"DROP SERIES FROM cpu"}, nodes[other].drops)
+	assert.True(t, nodes[other].droppedAt.Sub(storage.droppedAt) >= DrainDelay)
+
+	checkpoint := wq.checkIns[len(wq.checkIns)-1].Checkpoint.(DrainCheckpoint)
+	assert.ElementsMatch(t, []string{"a", "b"}, checkpoint.Imported)
+	assert.True(t, checkpoint.Dropped)
+	assert.True(t, wq.completed)
+}
+
+func TestPartitionKeyDrainer_ProcessStopsWhenImportFails(t *testing.T) {
+	d, wq, storage, nodes := newTestDrainer(cluster.PartitionKey{Database: "db", Tags: []string{"host"},
+		Status: cluster.PartitionKeyStatusDraining})
+	defer closeTestCluster(nodes)
+	replica, _ := d.replicaOf("db", "cpu")
+	nodes[replica].failWrites = true
+
+	d.process("task", DrainPayload{Database: "db"}, DrainCheckpoint{})
+
+	// The key is kept and no data is deleted, so that the task can be processed again.
+	assert.Len(t, storage.keys, 1)
+	for name, node := range nodes {
+		assert.Empty(t, node.drops, name)
+	}
+	for _, task := range wq.checkIns {
+		checkpoint := task.Checkpoint.(DrainCheckpoint)
+		assert.NotContains(t, checkpoint.Imported, replica)
+		assert.False(t, checkpoint.Dropped)
+	}
+	assert.False(t, wq.completed)
+}
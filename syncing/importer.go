@@ -30,6 +30,8 @@ type Importer interface {
 	ImportPartitioned(tokens []int, target *InfluxClient)
 	ImportNonPartitioned(target *InfluxClient)
 	DeleteByToken(location *InfluxClient, token int) error
+	// InScope returns an importer that only imports and deletes data of measurements in the replication scope.
+	InScope(scope cluster.ReplicationScope) Importer
 }
 
 type ErrorNoNodeFound struct{ Token int }
@@ -80,7 +82,7 @@ func (p *ClusterImportPredicate) Test(db, msmt string) ImportDecision {
 		}
 	}
 	key := hash.String(cluster.CreatePartitionKeyIdentifier(db, ""))
-	nodes := p.Resolver.FindNodesByKey(int(key), cluster.WRITE)
+	nodes := p.Resolver.FindNodesByKey(int(key), db, msmt, cluster.WRITE)
 	for _, node := range nodes {
 		if node.Name == p.LocalNode.Name {
 			return FullImport
@@ -113,29 +115,38 @@ func NewInfluxImporter() *InfluxImporter {
 
 func (i *InfluxImporter) ImportAll(location, target *InfluxClient, bookmark ImportBookmark) {
 	errorCount := 0
-	i.forEachDatabase(location, target, func(db string, dbMeta *DatabaseMeta) {
+	err := i.forEachDatabase(location, target, func(db string, dbMeta *DatabaseMeta) error {
 		for _, msmt := range dbMeta.Measurements {
 			for _, rp := range dbMeta.Rps {
 				offsetTime, _, _ := bookmark.Get(db, rp, msmt)
-				importCh, err := streamData(location, db, rp, msmt, fmt.Sprintf("time > '%s'", time.Unix(0, int64(offsetTime)).Format(time.RFC3339)))
+				done := make(chan struct{})
+				importCh, err := streamData(location, db, rp, msmt, fmt.Sprintf("time > '%s'", time.Unix(0, int64(offsetTime)).Format(time.RFC3339)), done)
 				if err != nil {
 					fmt.Printf("Failed to fetch data: %s", err.Error())
 					errorCount++
 					if errorCount > 10 {
 						fmt.Printf("Received 10 errors during import from %s and giving up.", db)
-						return
+						return nil
 					}
 				}
 				for res := range importCh {
 					points := convertResultToPoints(res, dbMeta)
 					if len(points) > 0 {
-						writePoints(points, target, db, rp)
+						if err := writePoints(points, target, db, rp); err != nil {
+							log.Println(err.Error())
+							break
+						}
 						bookmark.Set(db, rp, msmt, int(points[len(points)-1].Time().UnixNano()), false)
 					}
 				}
+				close(done)
 			}
 		}
+		return nil
 	})
+	if err != nil {
+		log.Println(err.Error())
+	}
 }
 
 type MetaImporter struct {
@@ -153,6 +164,7 @@ func (i *MetaImporter) ensureCache() {
 }
 
 func (i *MetaImporter) getLocationsMeta(location *InfluxClient) (locationMeta, error) {
+	i.ensureCache()
 	meta, ok := i.locationsMeta[location]
 	if !ok {
 		fetchedMeta, err := fetchLocationMeta(location)
@@ -165,12 +177,13 @@ func (i *MetaImporter) getLocationsMeta(location *InfluxClient) (locationMeta, e
 	return meta, nil
 }
 
-func (i *MetaImporter) forEachDatabase(location, target *InfluxClient, fn func(db string, dbMeta *DatabaseMeta)) {
+// forEachDatabase calls fn with every database at the location after creating it at the target. It
+// stops at the first error, which is returned.
+func (i *MetaImporter) forEachDatabase(location, target *InfluxClient, fn func(db string, dbMeta *DatabaseMeta) error) error {
 	i.ensureCache()
 	meta, err := i.getLocationsMeta(location)
 	if err != nil {
-		log.Printf("Failed fetching meta from location %s. Error: %s", location, err.Error())
-		return
+		return fmt.Errorf("failed fetching meta from location %s: %s", location, err.Error())
 	}
 	for db, dbMeta := range meta.databases {
 		if _, hasDB := i.createdDatabases[db]; !hasDB {
@@ -179,8 +192,11 @@ func (i *MetaImporter) forEachDatabase(location, target *InfluxClient, fn func(d
 			target.CreateContinuousQueries(db, dbMeta.Cqs)
 			i.createdDatabases[db] = true
 		}
-		fn(db, dbMeta)
+		if err := fn(db, dbMeta); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 type ClusterImporter struct {
@@ -189,44 +205,90 @@ type ClusterImporter struct {
 	Predicate     ImportDecisionTester
 	PartitionKeys cluster.PartitionKeyCollection
 	Resolver      *cluster.Resolver
+	scope         *cluster.ReplicationScope
 }
 
 func NewImporter(resolver *cluster.Resolver, partitionKeys cluster.PartitionKeyCollection, predicate ImportDecisionTester) *ClusterImporter {
 	return &ClusterImporter{Predicate: predicate, Resolver: resolver, PartitionKeys: partitionKeys}
 }
 
+func (i *ClusterImporter) InScope(scope cluster.ReplicationScope) Importer {
+	scoped := *i
+	// The meta of locations is cached, which is not wanted when importing after the cluster has changed.
+	scoped.MetaImporter = MetaImporter{}
+	scoped.scope = &scope
+	return &scoped
+}
+
+func (i *ClusterImporter) inScope(db, msmt string) bool {
+	return i.scope == nil || i.Resolver.InReplicationScope(*i.scope, db, msmt)
+}
+
+func (i *ClusterImporter) decide(db, msmt string) ImportDecision {
+	if !i.inScope(db, msmt) {
+		return NoImport
+	}
+	return i.Predicate(db, msmt)
+}
+
 func (i *ClusterImporter) ImportNonPartitioned(target *InfluxClient) {
+	// TODO only import if there is no partition key
+	err := i.ImportMeasurements(target, func(db, msmt string) bool {
+		return i.decide(db, msmt) == FullImport
+	})
+	if err != nil {
+		log.Printf("Failed to import data to %s: %s", target, err)
+	}
+}
+
+// ImportMeasurements imports all data of the measurements that match from every other node. It stops
+// at the first node that can not be imported from, as the target would otherwise miss its data.
+func (i *ClusterImporter) ImportMeasurements(target *InfluxClient, match func(db, msmt string) bool) error {
 	for _, address := range i.Resolver.FindAll() {
-		location, _ := NewInfluxClientHTTP(address, "", "")
-		if location.String() != target.String() {
-			i.forEachDatabase(location, target, func(db string, dbMeta *DatabaseMeta) {
-				for _, msmt := range dbMeta.Measurements {
-					// TODO only import if there is no partition key
-					if importType := i.Predicate(db, msmt); importType == FullImport {
-						for _, rp := range dbMeta.Rps {
-							importCh, _ := streamData(location, db, rp, msmt, "")
-							for res := range importCh {
-								points := convertResultToPoints(res, dbMeta)
-								writePoints(points, target, db, rp)
-							}
+		location, err := NewInfluxClientHTTPFromLocation(address)
+		if err != nil {
+			return err
+		}
+		if location.String() == target.String() {
+			continue
+		}
+		err = i.forEachDatabase(location, target, func(db string, dbMeta *DatabaseMeta) error {
+			for _, msmt := range dbMeta.Measurements {
+				if match(db, msmt) {
+					for _, rp := range dbMeta.Rps {
+						if err := importData(location, target, db, rp, msmt, "", dbMeta); err != nil {
+							return err
 						}
 					}
 				}
-			})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
 // ImportPartitioned data given a set of tokens. The tokens should include those stolen from
 // other nodes as well as token for which this node is holding replicated data
 func (i *ClusterImporter) ImportPartitioned(tokens []int, target *InfluxClient) {
 	for _, token := range tokens {
-		nodes := i.Resolver.FindNodesByKey(token, cluster.READ)
+		var scope cluster.ReplicationScope
+		if i.scope != nil {
+			scope = *i.scope
+		}
+		nodes := i.Resolver.FindNodesByKey(token, scope.Database, scope.Measurement, cluster.READ)
 		for _, node := range nodes {
 			locationClient, _ := NewInfluxClientHTTPFromNode(*node)
-			i.forEachDatabase(locationClient, target, func(db string, dbMeta *DatabaseMeta) {
+			err := i.forEachDatabase(locationClient, target, func(db string, dbMeta *DatabaseMeta) error {
 				i.importTokenData(locationClient, target, token, db, dbMeta, i.Resolver)
+				return nil
 			})
+			if err != nil {
+				log.Println(err.Error())
+			}
 		}
 	}
 	// TODO check that import worked
@@ -236,14 +298,12 @@ func (i *ClusterImporter) ImportPartitioned(tokens []int, target *InfluxClient)
 func (i *ClusterImporter) importTokenData(location, target *InfluxClient, token int, db string, dbMeta *DatabaseMeta, resolver *cluster.Resolver) {
 	for _, rp := range dbMeta.Rps {
 		for _, msmt := range dbMeta.Measurements {
-			if importType := i.Predicate(db, msmt); importType == PartitionImport {
+			if importType := i.decide(db, msmt); importType == PartitionImport {
 				for _, series := range dbMeta.series {
 					for _, pk := range i.PartitionKeys.GetPartitionKeys() { // fixme this may result in importing daa for the same token multiple times
 						if ((pk.Measurement == msmt && pk.Measurement == series.Measurement) || pk.Measurement == "") && series.Matches(token, pk, resolver) {
-							importCh, _ := streamData(location, db, rp, msmt, series.Where())
-							for res := range importCh {
-								points := convertResultToPoints(res, dbMeta)
-								writePoints(points, target, db, rp)
+							if err := importData(location, target, db, rp, msmt, series.Where(), dbMeta); err != nil {
+								log.Println(err.Error())
 							}
 						}
 					}
@@ -260,6 +320,9 @@ func (i *ClusterImporter) DeleteByToken(location *InfluxClient, token int) error
 	}
 	for db, dbMeta := range meta.databases {
 		for _, msmt := range dbMeta.Measurements {
+			if !i.inScope(db, msmt) {
+				continue
+			}
 			if measurementHasPartitionKey(db, msmt, i.PartitionKeys.GetPartitionKeys()) {
 				for _, series := range dbMeta.series {
 					for _, pk := range i.PartitionKeys.GetPartitionKeys() {
@@ -288,7 +351,73 @@ func (i *ClusterImporter) DeleteByToken(location *InfluxClient, token int) error
 	return nil
 }
 
-func writePoints(points []*influx.Point, target *InfluxClient, db, rp string) {
+// ImportSeries imports the data of the series that match from every other node. It stops at the
+// first node that can not be imported from, as the target would otherwise miss its data.
+func (i *ClusterImporter) ImportSeries(target *InfluxClient, match func(db string, series Series) bool) error {
+	for _, address := range i.Resolver.FindAll() {
+		location, err := NewInfluxClientHTTPFromLocation(address)
+		if err != nil {
+			return err
+		}
+		if location.String() == target.String() {
+			continue
+		}
+		err = i.forEachDatabase(location, target, func(db string, dbMeta *DatabaseMeta) error {
+			for _, series := range dbMeta.series {
+				if match(db, series) {
+					for _, rp := range dbMeta.Rps {
+						if err := importData(location, target, db, rp, series.Measurement, series.Where(), dbMeta); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSeries deletes the data of the series that match at the location.
+func (i *ClusterImporter) DeleteSeries(location *InfluxClient, match func(db string, series Series) bool) error {
+	meta, err := i.getLocationsMeta(location)
+	if err != nil {
+		return fmt.Errorf("failed fetching meta from location %s: %s", location, err.Error())
+	}
+	for db, dbMeta := range meta.databases {
+		for _, series := range dbMeta.series {
+			if match(db, series) {
+				if err := location.DropSeriesFromWhere(db, series.Measurement, series.Where()); err != nil {
+					log.Println("Failed to delete data at " + location.String() + " where " + series.Where())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteMeasurements deletes all data of the measurements that match at the location.
+func (i *ClusterImporter) DeleteMeasurements(location *InfluxClient, match func(db, msmt string) bool) error {
+	meta, err := i.getLocationsMeta(location)
+	if err != nil {
+		return fmt.Errorf("failed fetching meta from location %s: %s", location, err.Error())
+	}
+	for db, dbMeta := range meta.databases {
+		for _, msmt := range dbMeta.Measurements {
+			if match(db, msmt) {
+				if err := location.DropSeriesFrom(db, msmt); err != nil {
+					log.Println(fmt.Sprintf("Failed to delete data at %s for measurement %s", location.String(), msmt))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func writePoints(points []*influx.Point, target *InfluxClient, db, rp string) error {
 	batch, _ := influx.NewBatchPoints(influx.BatchPointsConfig{
 		Precision:        "ns",
 		Database:         db,
@@ -298,8 +427,31 @@ func writePoints(points []*influx.Point, target *InfluxClient, db, rp string) {
 	batch.AddPoints(points)
 	err := target.Write(batch)
 	if err != nil {
-		log.Panicf("Failed to post data to target node %s: %s", target, err)
+		return fmt.Errorf("failed to post data to target node %s: %s", target, err)
 	}
+	return nil
+}
+
+// importData writes the data of the measurement at the location to the target. It stops at the first
+// error, in which case the target may have been given some of the data.
+func importData(location, target *InfluxClient, db, rp, msmt, where string, dbMeta *DatabaseMeta) error {
+	done := make(chan struct{})
+	defer close(done)
+	importCh, err := streamData(location, db, rp, msmt, where, done)
+	if err != nil {
+		return fmt.Errorf("failed to fetch data of %s from %s: %s", msmt, location, err)
+	}
+	for res := range importCh {
+		if res.Err != "" {
+			return fmt.Errorf("failed to fetch data of %s from %s: %s", msmt, location, res.Err)
+		}
+		if points := convertResultToPoints(res, dbMeta); len(points) > 0 {
+			if err := writePoints(points, target, db, rp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func (c *InfluxClient) CreateDatabase(db string) error {
@@ -337,7 +489,7 @@ func postLines(location, db, rp string, lines []string) (*http.Response, error)
 }
 
 func postData(location, db, rp string, buf []byte) (*http.Response, error) {
-	req, err := http.NewRequest("POST", "http://"+location+"/write", bytes.NewReader(buf))
+	req, err := http.NewRequest("POST", cluster.DataURL(location)+"/write", bytes.NewReader(buf))
 	if err != nil {
 		return nil, err
 	}
@@ -349,7 +501,8 @@ func postData(location, db, rp string, buf []byte) (*http.Response, error) {
 	req.URL.RawQuery = strings.Join(query, "&")
 	req.Header.Set("Content-Type", "text/plain")
 	req.Header.Set("Content-Length", strconv.Itoa(len(buf)))
-	client := http.Client{Timeout: 60 * time.Second}
+	cluster.AuthorizeDataRequest(req, location)
+	client := cluster.NewDataClient(60 * time.Second)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -494,9 +647,10 @@ func NewInfluxClient(client influx.Client, name string, location string) *Influx
 
 func NewInfluxClientHTTP(addr, username, password string) (*InfluxClient, error) {
 	config := influx.HTTPConfig{
-		Addr:     "http://" + addr,
-		Username: username,
-		Password: password,
+		Addr:      cluster.DataURL(addr),
+		TLSConfig: cluster.DataTLSConfig(),
+		Username:  username,
+		Password:  password,
 	}
 	client, err := influx.NewHTTPClient(config)
 	if err != nil {
@@ -507,7 +661,13 @@ func NewInfluxClientHTTP(addr, username, password string) (*InfluxClient, error)
 }
 
 func NewInfluxClientHTTPFromNode(node cluster.Node) (*InfluxClient, error) {
-	return NewInfluxClientHTTP(node.DataLocation, "", "")
+	return NewInfluxClientHTTPFromLocation(node.DataLocation)
+}
+
+// NewInfluxClientHTTPFromLocation creates a client for the data node at the location using its credentials.
+func NewInfluxClientHTTPFromLocation(location string) (*InfluxClient, error) {
+	credentials, _ := cluster.GetDataCredentials(location)
+	return NewInfluxClientHTTP(location, credentials.Username, credentials.Password)
 }
 
 func (c *InfluxClient) String() string {
@@ -615,14 +775,14 @@ func (c *InfluxClient) FetchTagKeys(db string) (map[string]bool, error) {
 // We could also wrap it to support decoding responses and creating new things.
 // Also, we it should have inbuilt retry support or the ability to configure it.
 func get(q string, location string, db string, chunked bool) (*http.Response, error) {
-	client := &http.Client{}
+	client := cluster.NewDataClient(0)
 	params := []string{"db=" + db, "q=" + q, "chunked=" + strconv.FormatBool(chunked)}
 	values, err := url.ParseQuery(strings.Join(params, "&"))
 	if err != nil {
 		log.Panic(err)
 	}
 	encoded := values.Encode()
-	return getWithRetry(client, "http://"+location+"/query?"+encoded, 5)
+	return getWithRetry(client, cluster.DataURL(location)+"/query?"+encoded, 5)
 }
 
 func getWithRetry(client *http.Client, url string, attempts int) (*http.Response, error) {
@@ -639,7 +799,9 @@ func getWithRetry(client *http.Client, url string, attempts int) (*http.Response
 	return nil, err
 }
 
-func streamData(location *InfluxClient, db, rp string, measurement string, where string) (chan influx.Result, error) {
+// streamData sends the data of the measurement in results until all of it has been sent or done is
+// closed. If the data can not be fetched, the channel is closed after a result with the error.
+func streamData(location *InfluxClient, db, rp string, measurement string, where string, done <-chan struct{}) (chan influx.Result, error) {
 	var stmt = `SELECT * FROM ` + rp + "." + measurement
 	if where != "" {
 		stmt += ` WHERE ` + where
@@ -650,6 +812,7 @@ func streamData(location *InfluxClient, db, rp string, measurement string, where
 	// Check if it is able to respond to fail fast
 	_, err := location.Query(influx.NewQuery(limitQuery(stmt, 1, 0), db, "rfc3339"))
 	if err != nil {
+		close(ch)
 		return ch, err
 	}
 
@@ -662,14 +825,21 @@ func streamData(location *InfluxClient, db, rp string, measurement string, where
 			var resultCount int
 			limitedQuery := limitQuery(stmt, limit, offset)
 
-			// If there is an error here, it is not much we can do.
 			resp, err := location.Query(influx.NewQuery(limitedQuery, db, "rfc3339"))
 			if err != nil {
+				select {
+				case ch <- influx.Result{Err: err.Error()}:
+				case <-done:
+				}
 				return
 			}
 
 			for _, result := range resp.Results {
-				ch <- result
+				select {
+				case ch <- result:
+				case <-done:
+					return
+				}
 				resultCount += len(result.Series)
 			}
 
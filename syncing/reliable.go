@@ -18,6 +18,8 @@ type ReliableImportPayload struct {
 	Tokens         []int `json:"Tokens"`
 	NonPartitioned bool
 	AssignToSelf   bool
+	// Scope limits the import to the measurements in a replication scope, such as when its factor has changed.
+	Scope *cluster.ReplicationScope `json:",omitempty"`
 }
 
 type ReliableImportCheckpoint struct {
@@ -72,8 +74,13 @@ func (imp *ReliableImporter) process(taskID string, payload ReliableImportPayloa
 	task.ID = taskID
 	task.Payload = payload
 
+	importer := imp.importer
+	if payload.Scope != nil {
+		importer = importer.InScope(*payload.Scope)
+	}
+
 	if payload.NonPartitioned && !checkpoint.NonPartitioned {
-		imp.importer.ImportNonPartitioned(imp.target)
+		importer.ImportNonPartitioned(imp.target)
 
 		checkpoint.NonPartitioned = true
 		task.Checkpoint = checkpoint
@@ -90,11 +97,12 @@ func (imp *ReliableImporter) process(taskID string, payload ReliableImportPayloa
 
 		// maybe instead of just using tokens, the importer also can take an option of a batch size which could be in terms of series or points imported.
 		// also, it could be a good idea to distribute databases (not Measurements as some queries need them to be one the same node)
-		imp.importer.ImportPartitioned([]int{token}, imp.target)
+		importer.ImportPartitioned([]int{token}, imp.target)
 		checkpoint.TokenIndex = i + 1
 		task.Checkpoint = checkpoint
 
-		if imp.AfterImport != nil {
+		// Scoped imports are of replicas, so the tokens stay with their current nodes.
+		if imp.AfterImport != nil && payload.Scope == nil {
 			imp.AfterImport(token)
 		}
 
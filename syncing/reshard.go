@@ -0,0 +1,230 @@
+package syncing
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+)
+
+const (
+	PartitionKeyReshardWorkName = "reshard"
+)
+
+type ReshardPayload struct {
+	Database    string
+	Measurement string
+	// Tags are the new tags of the key.
+	Tags []string
+	// NotBefore is the time when the data starts being moved.
+	NotBefore time.Time
+}
+
+type ReshardCheckpoint struct {
+	// Imported holds the names of the nodes that have imported the data they hold with the new tags.
+	Imported []string
+	// Switched is set when reads use the new tags, while points are still written by both.
+	Switched bool
+	// Settled is set when points are only written by the new tags.
+	Settled bool
+}
+
+// PartitionKeyResharder changes the tags of partition keys without making their data unavailable.
+// Points are written by both the old and the new tags while every node imports the series that it
+// should have with the new tags. The key is then switched so that reads use the new tags, and once
+// every node has seen the switch, points are only written by them and the copies on nodes that no
+// longer should have them are deleted.
+type PartitionKeyResharder struct {
+	wq            cluster.WorkQueue
+	resolver      *cluster.Resolver
+	partitionKeys cluster.PartitionKeyCollection
+	storage       cluster.PartitionKeyStorage
+	localNode     string
+	stopChan      chan bool
+}
+
+func NewPartitionKeyResharder(wq cluster.WorkQueue, resolver *cluster.Resolver, partitionKeys cluster.PartitionKeyCollection,
+	storage cluster.PartitionKeyStorage, localNode string) *PartitionKeyResharder {
+	return &PartitionKeyResharder{wq, resolver, partitionKeys, storage, localNode, make(chan bool)}
+}
+
+// Reshard creates a task for changing the key to its migration tags. The key has to be saved as
+// resharding first.
+func (r *PartitionKeyResharder) Reshard(key cluster.PartitionKey) error {
+	r.wq.Push(r.localNode, ReshardPayload{key.Database, key.Measurement, key.MigrationTags, time.Now().Add(DrainDelay)})
+	return nil
+}
+
+func (r *PartitionKeyResharder) Start() {
+	tasks := r.wq.Subscribe()
+	for {
+		select {
+		case task := <-tasks:
+			var checkpoint ReshardCheckpoint
+			var payload ReshardPayload
+			err := task.Unmarshal(&payload, &checkpoint)
+			if err != nil {
+				log.Printf("Failed to unmarshal task data. Payload %s, Checkpoint: %s",
+					string(task.Payload), string(task.Checkpoint))
+				continue
+			}
+			r.process(task.ID, payload, checkpoint)
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+func (r *PartitionKeyResharder) Stop() {
+	r.wq.Unsubscribe()
+	close(r.stopChan)
+}
+
+func (r *PartitionKeyResharder) process(taskID string, payload ReshardPayload, checkpoint ReshardCheckpoint) {
+	name := cluster.CreatePartitionKeyIdentifier(payload.Database, payload.Measurement)
+	log.Printf("Processing task: ReshardPartitionKey %s (%s)", name, taskID)
+
+	task := cluster.Task{}
+	task.ID = taskID
+	task.Payload = payload
+
+	if !waitOrStop(time.Until(payload.NotBefore), r.stopChan) {
+		return
+	}
+
+	key, err := findPartitionKey(r.storage, payload.Database, payload.Measurement)
+	if err != nil {
+		log.Printf("Failed to reshard partition key on %s: %s", name, err)
+		return
+	}
+	if !checkpoint.expects(key, payload.Tags) {
+		log.Printf("Partition key on %s is no longer resharding to [%s]", name, strings.Join(payload.Tags, ", "))
+		r.wq.Complete(task)
+		return
+	}
+
+	newKey := cluster.PartitionKey{Database: payload.Database, Measurement: payload.Measurement, Tags: payload.Tags}
+	match := func(node *cluster.Node, replica bool) func(db string, series Series) bool {
+		return func(db string, series Series) bool {
+			if !drains(payload.Database, payload.Measurement, r.partitionKeys.GetPartitionKeys(), db, series.Measurement) {
+				return false
+			}
+			isReplica, ok := r.isReplica(node, newKey, db, series)
+			return ok && isReplica == replica
+		}
+	}
+
+	if !checkpoint.Switched {
+		importer := NewImporter(r.resolver, r.partitionKeys, AlwaysNoImport)
+		nodes := r.resolver.FindAllNodes()
+		for _, node := range nodes {
+			if contains(checkpoint.Imported, node.Name) {
+				continue
+			}
+			target, err := NewInfluxClientHTTPFromNode(*node)
+			if err != nil {
+				log.Printf("Failed to reshard partition key on %s: %s", name, err)
+				return
+			}
+			// The key is only switched once every node has all of its data, so the task is left to be
+			// processed again rather than moving on without the data of a node.
+			if err := importer.ImportSeries(target, match(node, true)); err != nil {
+				log.Printf("Failed to import data of partition key on %s to %s: %s", name, node.Name, err)
+				return
+			}
+			checkpoint.Imported = append(checkpoint.Imported, node.Name)
+			task.Checkpoint = checkpoint
+			r.wq.CheckIn(task)
+			saveProgress(r.storage, key, fmt.Sprintf("imported data to %d of %d nodes", len(checkpoint.Imported), len(nodes)))
+		}
+
+		// Nodes that have not yet seen the switch write points by the old tags only, so the
+		// old tags are kept as migration tags until every node uses the new ones.
+		key.Tags, key.MigrationTags = payload.Tags, key.Tags
+		key.Progress = "switched to the new tags"
+		if err := r.storage.Save(key); err != nil {
+			log.Printf("Failed to switch partition key on %s: %s", name, err)
+			return
+		}
+		checkpoint.Switched = true
+		task.Checkpoint = checkpoint
+		r.wq.CheckIn(task)
+	}
+
+	if !checkpoint.Settled {
+		if !waitOrStop(DrainDelay, r.stopChan) {
+			return
+		}
+		key.Status, key.MigrationTags, key.Progress = "", nil, ""
+		if err := r.storage.Save(key); err != nil {
+			log.Printf("Failed to save partition key on %s: %s", name, err)
+			return
+		}
+		checkpoint.Settled = true
+		task.Checkpoint = checkpoint
+		r.wq.CheckIn(task)
+
+		// Points may still be written by the old tags until every node has seen that the key is settled.
+		if !waitOrStop(DrainDelay, r.stopChan) {
+			return
+		}
+	}
+
+	// A new importer is used as the series on the nodes have changed.
+	importer := NewImporter(r.resolver, r.partitionKeys, AlwaysNoImport)
+	for _, node := range r.resolver.FindAllNodes() {
+		location, err := NewInfluxClientHTTPFromNode(*node)
+		if err == nil {
+			err = importer.DeleteSeries(location, match(node, false))
+		}
+		if err != nil {
+			log.Printf("Failed to delete data of partition key on %s at %s: %s", name, node.Name, err)
+		}
+	}
+	log.Printf("Resharded partition key on %s to [%s]", name, strings.Join(payload.Tags, ", "))
+	r.wq.Complete(task)
+}
+
+// isReplica returns true if the node should have the series when it is partitioned by the key. Series
+// that do not have the tags of the key can not be placed by it, in which case false is returned as
+// the second value.
+func (r *PartitionKeyResharder) isReplica(node *cluster.Node, key cluster.PartitionKey, db string, series Series) (bool, bool) {
+	hash, err := cluster.GetHash(key, series.Tags)
+	if err != nil {
+		return false, false
+	}
+	for _, replica := range r.resolver.FindNodesByKey(hash, db, series.Measurement, cluster.WRITE) {
+		if replica.Name == node.Name {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// expects returns true if the key is in the state that the checkpoint was saved in. Otherwise the
+// key has been changed or dropped by someone else and the task is abandoned.
+func (c ReshardCheckpoint) expects(key *cluster.PartitionKey, tags []string) bool {
+	switch {
+	case key == nil:
+		return false
+	case !c.Switched:
+		return key.Resharding() && equalTags(key.MigrationTags, tags)
+	case !c.Settled:
+		return key.Resharding() && equalTags(key.Tags, tags)
+	}
+	return key.Status == "" && equalTags(key.Tags, tags)
+}
+
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package syncing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adamringhede/influxdb-ha/cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReshardCheckpointExpects(t *testing.T) {
+	key := &cluster.PartitionKey{Database: "db", Tags: []string{"type"}, MigrationTags: []string{"type", "captain"},
+		Status: cluster.PartitionKeyStatusResharding}
+	newTags := []string{"type", "captain"}
+
+	assert.True(t, ReshardCheckpoint{}.expects(key, newTags))
+	assert.False(t, ReshardCheckpoint{}.expects(key, []string{"captain"}))
+	assert.False(t, ReshardCheckpoint{}.expects(nil, newTags))
+	assert.False(t, ReshardCheckpoint{Switched: true}.expects(key, newTags))
+
+	key.Tags, key.MigrationTags = key.MigrationTags, key.Tags
+	assert.True(t, ReshardCheckpoint{Switched: true}.expects(key, newTags))
+
+	key.Status, key.MigrationTags = "", nil
+	assert.False(t, ReshardCheckpoint{Switched: true}.expects(key, newTags))
+	assert.True(t, ReshardCheckpoint{Switched: true, Settled: true}.expects(key, newTags))
+}
+
+func newTestResharder(key cluster.PartitionKey) (*PartitionKeyResharder, *testWorkQueue, *testPartitionKeyStorage, map[string]*testNode) {
+	resolver, nodes := newTestCluster()
+	partitioner := cluster.NewPartitioner()
+	partitioner.AddKey(key)
+	wq := &testWorkQueue{}
+	storage := &testPartitionKeyStorage{keys: []*cluster.PartitionKey{&key}}
+	return NewPartitionKeyResharder(wq, resolver, partitioner, storage, "a"), wq, storage, nodes
+}
+
+// replicaOf returns the name of the node that should have the series with the key and the name of the other.
+func (r *PartitionKeyResharder) replicaOf(key cluster.PartitionKey, db string, series Series) (string, string) {
+	nodes := r.resolver.FindAllNodes()
+	if isReplica, _ := r.isReplica(nodes[0], key, db, series); isReplica {
+		return nodes[0].Name, nodes[1].Name
+	}
+	return nodes[1].Name, nodes[0].Name
+}
+
+func TestPartitionKeyResharder_Process(t *testing.T) {
+	defer func(delay time.Duration) { DrainDelay = delay }(DrainDelay)
+	DrainDelay = 10 * time.Millisecond
+
+	r, wq, storage, nodes := newTestResharder(cluster.PartitionKey{Database: "db", Tags: []string{"dc"},
+		MigrationTags: []string{"host"}, Status: cluster.PartitionKeyStatusResharding})
+	defer closeTestCluster(nodes)
+	replica, other := r.replicaOf(cluster.PartitionKey{Database: "db", Tags: []string{"host"}}, "db",
+		NewSeriesFromKey("cpu,host=a"))
+
+	r.process("task", ReshardPayload{Database: "db", Tags: []string{"host"}}, ReshardCheckpoint{})
+
+	// The node that should have the series with the new tags imports it from the other, which
+	// deletes its copy once the key has been switched and settled.
+	assert.Equal(t, 1, nodes[replica].writes)
+	assert.Equal(t, 0, nodes[other].writes)
+	assert.Equal(t, []string{"host"}, storage.keys[0].Tags)
+	assert.Empty(t, storage.keys[0].MigrationTags)
+	assert.Equal(t, "", storage.keys[0].Status)
+	assert.Empty(t, nodes[replica].drops)
+	assert.Len(t, nodes[other].drops, 1)
+
+	var stages []ReshardCheckpoint
+	for _, task := range wq.checkIns {
+		stages = append(stages, task.Checkpoint.(ReshardCheckpoint))
+	}
+	// Every node imports before the key is switched, after which it is settled.
+	if assert.Len(t, stages, 4) {
+		assert.False(t, stages[1].Switched)
+		assert.ElementsMatch(t, []string{"a", "b"}, stages[1].Imported)
+		assert.True(t, stages[2].Switched)
+		assert.False(t, stages[2].Settled)
+		assert.True(t, stages[3].Settled)
+	}
+	assert.True(t, wq.completed)
+}
+
+func TestPartitionKeyResharder_ProcessStopsWhenImportFails(t *testing.T) {
+	r, wq, storage, nodes := newTestResharder(cluster.PartitionKey{Database: "db", Tags: []string{"dc"},
+		MigrationTags: []string{"host"}, Status: cluster.PartitionKeyStatusResharding})
+	defer closeTestCluster(nodes)
+	replica, _ := r.replicaOf(cluster.PartitionKey{Database: "db", Tags: []string{"host"}}, "db",
+		NewSeriesFromKey("cpu,host=a"))
+	nodes[replica].failWrites = true
+
+	r.process("task", ReshardPayload{Database: "db", Tags: []string{"host"}}, ReshardCheckpoint{})
+
+	// Reads keep using the old tags and no data is deleted, so that the task can be processed again.
+	assert.Equal(t, []string{"dc"}, storage.keys[0].Tags)
+	assert.True(t, storage.keys[0].Resharding())
+	for name, node := range nodes {
+		assert.Empty(t, node.drops, name)
+	}
+	for _, task := range wq.checkIns {
+		checkpoint := task.Checkpoint.(ReshardCheckpoint)
+		assert.NotContains(t, checkpoint.Imported, replica)
+		assert.False(t, checkpoint.Switched)
+	}
+	assert.False(t, wq.completed)
+}
@@ -30,7 +30,7 @@ func TestStart(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	node1 := launcher.NewLauncher(clusterId, "node-a", EtcdAddress, utils.InfluxOne, createHttpConfig(8081))
+	node1 := launcher.NewLauncher(clusterId, "node-a", EtcdAddress, utils.InfluxOne, "", nil, createHttpConfig(8081))
 	node1.Join()
 	go node1.Listen(ctx)
 
@@ -45,8 +45,8 @@ func TestStart(t *testing.T) {
 		utils.NewPoint("f", 2),
 	}, clnt1)
 
-	node2 := launcher.NewLauncher(clusterId, "node-b", EtcdAddress, utils.InfluxTwo, createHttpConfig(8082))
-	node3 := launcher.NewLauncher(clusterId, "node-c", EtcdAddress, utils.InfluxThree, createHttpConfig(8083))
+	node2 := launcher.NewLauncher(clusterId, "node-b", EtcdAddress, utils.InfluxTwo, "", nil, createHttpConfig(8082))
+	node3 := launcher.NewLauncher(clusterId, "node-c", EtcdAddress, utils.InfluxThree, "", nil, createHttpConfig(8083))
 
 	assert.True(t, node1.IsNew)
 	assert.True(t, node2.IsNew)
@@ -75,7 +75,7 @@ func TestStartWithPartitioned(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	node1 := launcher.NewLauncher(clusterId, "node-a", EtcdAddress, utils.InfluxOne, createHttpConfig(8081))
+	node1 := launcher.NewLauncher(clusterId, "node-a", EtcdAddress, utils.InfluxOne, "", nil, createHttpConfig(8081))
 	assert.NoError(t, node1.Join())
 	go node1.Listen(ctx)
 
@@ -91,8 +91,8 @@ func TestStartWithPartitioned(t *testing.T) {
 	utils.WritePoints(points, clnt1)
 	time.Sleep(1000 * time.Millisecond)
 
-	node2 := launcher.NewLauncher(clusterId, "node-b", EtcdAddress, utils.InfluxTwo, createHttpConfig(8082))
-	node3 := launcher.NewLauncher(clusterId, "node-c", EtcdAddress, utils.InfluxThree, createHttpConfig(8083))
+	node2 := launcher.NewLauncher(clusterId, "node-b", EtcdAddress, utils.InfluxTwo, "", nil, createHttpConfig(8082))
+	node3 := launcher.NewLauncher(clusterId, "node-c", EtcdAddress, utils.InfluxThree, "", nil, createHttpConfig(8083))
 
 	assert.True(t, node1.IsNew)
 	assert.True(t, node2.IsNew)
@@ -50,7 +50,7 @@ func MustQuery(clnt influx.Client, cmd string) []influx.Result {
 }
 
 func GetPartitionKey() cluster.PartitionKey {
-	return cluster.PartitionKey{"sharded", "treasures", []string{"type"}}
+	return cluster.PartitionKey{Database: "sharded", Measurement: "treasures", Tags: []string{"type"}}
 }
 
 func NewPartitioner() cluster.Partitioner {